@@ -0,0 +1,218 @@
+/*
+Package at24 is a driver for 24xx series I2C EEPROMs (24LCxx, 24AAxx,
+AT24Cxx and compatible parts) attached to an FTDI chip through libmpsse.
+
+The EEPROM implements io.ReaderAt and io.WriterAt. Parts are addressed
+with one or two address bytes; the address bits above those are sent as
+block select bits in the low bits of the device address. Writes are split
+along page boundaries, and the EEPROM is polled for an ACK of its device
+address until each write cycle completes.
+
+The Mpsse must be opened in I2C mode.
+*/
+package at24
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/vapor-ware/libmpsse"
+)
+
+// writeTimeout is the longest a write cycle is allowed to take. Datasheets
+// list a maximum of 5-10ms.
+const writeTimeout = 50 * time.Millisecond
+
+// maxBlockBits is the number of device address bits that can be used to
+// select a block.
+const maxBlockBits = 3
+
+// ErrTimeout is returned when the EEPROM does not finish a write cycle
+// within the expected time.
+var ErrTimeout = errors.New("timed out waiting for EEPROM write cycle")
+
+// Config describes the organization of an EEPROM part.
+type Config struct {
+	// Size is the size of the EEPROM, in bytes, which must be a power of
+	// two.
+	Size int
+
+	// PageSize is the size of a write page, in bytes. Writes can not cross
+	// a page boundary.
+	PageSize int
+
+	// AddrWidth is the number of address bytes sent with each read or
+	// write, 1 or 2. Parts larger than the address bytes can cover use the
+	// low bits of the device address as block select bits.
+	AddrWidth int
+}
+
+// Configurations for some common parts.
+var (
+	Part24xx01  = Config{Size: 128, PageSize: 8, AddrWidth: 1}
+	Part24xx02  = Config{Size: 256, PageSize: 8, AddrWidth: 1}
+	Part24xx04  = Config{Size: 512, PageSize: 16, AddrWidth: 1}
+	Part24xx08  = Config{Size: 1024, PageSize: 16, AddrWidth: 1}
+	Part24xx16  = Config{Size: 2048, PageSize: 16, AddrWidth: 1}
+	Part24xx32  = Config{Size: 4 * 1024, PageSize: 32, AddrWidth: 2}
+	Part24xx64  = Config{Size: 8 * 1024, PageSize: 32, AddrWidth: 2}
+	Part24xx128 = Config{Size: 16 * 1024, PageSize: 64, AddrWidth: 2}
+	Part24xx256 = Config{Size: 32 * 1024, PageSize: 64, AddrWidth: 2}
+	Part24xx512 = Config{Size: 64 * 1024, PageSize: 128, AddrWidth: 2}
+	Part24xxM01 = Config{Size: 128 * 1024, PageSize: 256, AddrWidth: 2}
+)
+
+// EEPROM is a 24xx series I2C EEPROM.
+type EEPROM struct {
+	bus    libmpsse.I2CBus
+	addr   uint16
+	config Config
+}
+
+// New creates an EEPROM for the part described by config, at the 7-bit
+// device address addr on bus. For parts that use block select bits, addr
+// is the address of the first block, with the block select bits clear.
+func New(bus libmpsse.I2CBus, addr uint16, config Config) (*EEPROM, error) {
+	if config.Size <= 0 || config.PageSize <= 0 {
+		return nil, fmt.Errorf("invalid EEPROM size %d / page size %d", config.Size, config.PageSize)
+	}
+	if config.Size&(config.Size-1) != 0 {
+		// The block select bits address a power of two number of blocks.
+		return nil, fmt.Errorf("EEPROM size %d is not a power of two", config.Size)
+	}
+	if config.AddrWidth < 1 || config.AddrWidth > 2 {
+		return nil, fmt.Errorf("invalid EEPROM address width %d", config.AddrWidth)
+	}
+
+	e := &EEPROM{
+		bus:    bus,
+		addr:   addr,
+		config: config,
+	}
+
+	blocks := (config.Size + e.blockSize() - 1) / e.blockSize()
+	if blocks > 1<<maxBlockBits {
+		return nil, fmt.Errorf("EEPROM size %d can not be addressed with %d address bytes", config.Size, config.AddrWidth)
+	}
+	if config.PageSize > e.blockSize() {
+		return nil, fmt.Errorf("EEPROM page size %d is larger than its address range", config.PageSize)
+	}
+	if int(addr)&(blocks-1) != 0 {
+		return nil, fmt.Errorf("EEPROM address 0x%02x overlaps its block select bits", addr)
+	}
+
+	return e, nil
+}
+
+// Size returns the size of the EEPROM, in bytes.
+func (e *EEPROM) Size() int64 {
+	return int64(e.config.Size)
+}
+
+// ReadAt reads len(p) bytes from the EEPROM starting at offset off. Reads
+// are split at block boundaries, which sequential reads can not cross. It
+// implements io.ReaderAt.
+func (e *EEPROM) ReadAt(p []byte, off int64) (int, error) {
+	if err := e.checkRange(len(p), off); err != nil {
+		return 0, err
+	}
+
+	addr := int(off)
+	read := 0
+	for read < len(p) {
+		n := e.blockSize() - addr%e.blockSize()
+		if n > len(p)-read {
+			n = len(p) - read
+		}
+
+		dev, out := e.addressed(addr)
+		err := e.bus.I2CTransfer([]libmpsse.I2CMsg{
+			{Addr: dev, Buf: out},
+			{Addr: dev, Flags: libmpsse.I2CMsgRead, Buf: p[read : read+n]},
+		})
+		if err != nil {
+			return read, err
+		}
+		addr += n
+		read += n
+	}
+	return read, nil
+}
+
+// WriteAt writes len(p) bytes to the EEPROM starting at offset off. The
+// write is split into page writes, each of which waits for the write cycle
+// to complete before the next is started. It implements io.WriterAt.
+func (e *EEPROM) WriteAt(p []byte, off int64) (int, error) {
+	if err := e.checkRange(len(p), off); err != nil {
+		return 0, err
+	}
+
+	addr := int(off)
+	written := 0
+	for written < len(p) {
+		n := e.config.PageSize - addr%e.config.PageSize
+		if n > len(p)-written {
+			n = len(p) - written
+		}
+
+		if err := e.writePage(addr, p[written:written+n]); err != nil {
+			return written, err
+		}
+		addr += n
+		written += n
+	}
+	return written, nil
+}
+
+// WaitReady polls the EEPROM until it acknowledges its device address,
+// which it does not do during a write cycle, or the timeout expires.
+func (e *EEPROM) WaitReady(timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		err := e.bus.I2CTransfer([]libmpsse.I2CMsg{{Addr: e.addr}})
+		if _, nack := err.(*libmpsse.I2CNackError); !nack {
+			return err
+		}
+		if time.Now().After(deadline) {
+			return ErrTimeout
+		}
+	}
+}
+
+// writePage writes data, which must not cross a page boundary, at addr.
+func (e *EEPROM) writePage(addr int, data []byte) error {
+	dev, out := e.addressed(addr)
+	err := e.bus.I2CTransfer([]libmpsse.I2CMsg{
+		{Addr: dev, Buf: append(out, data...)},
+	})
+	if err != nil {
+		return err
+	}
+	return e.WaitReady(writeTimeout)
+}
+
+// checkRange checks that an access of size bytes at off is inside the
+// EEPROM.
+func (e *EEPROM) checkRange(size int, off int64) error {
+	if off < 0 || off+int64(size) > int64(e.config.Size) {
+		return fmt.Errorf("access of %d bytes at 0x%x is outside of EEPROM (size 0x%x)", size, off, e.config.Size)
+	}
+	return nil
+}
+
+// blockSize returns the number of bytes that the address bytes cover.
+func (e *EEPROM) blockSize() int {
+	return 1 << (uint(e.config.AddrWidth) * 8)
+}
+
+// addressed returns the device address and the address bytes for addr.
+func (e *EEPROM) addressed(addr int) (uint16, []byte) {
+	dev := e.addr | uint16(addr/e.blockSize())
+
+	buf := make([]byte, 0, e.config.AddrWidth)
+	for i := e.config.AddrWidth - 1; i >= 0; i-- {
+		buf = append(buf, byte(addr>>(uint(i)*8)))
+	}
+	return dev, buf
+}
@@ -0,0 +1,118 @@
+package at24
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/vapor-ware/libmpsse"
+	"github.com/vapor-ware/libmpsse/i2csim"
+)
+
+// newEEPROM returns a simulated EEPROM for the part described by config,
+// attached at 0x50 and the addresses of its other blocks.
+func newEEPROM(config Config, cycle time.Duration) (*i2csim.Bus, *i2csim.EEPROM) {
+	bus := i2csim.NewBus()
+	sim := &i2csim.EEPROM{
+		Data:       make([]byte, config.Size),
+		PageSize:   config.PageSize,
+		AddrWidth:  config.AddrWidth,
+		WriteCycle: cycle,
+	}
+	for addr := uint16(0x50); addr < 0x58; addr++ {
+		bus.Attach(addr, sim)
+	}
+	return bus, sim
+}
+
+func TestNew(t *testing.T) {
+	tests := []struct {
+		name   string
+		addr   uint16
+		config Config
+	}{
+		{"zero size", 0x50, Config{Size: 0, PageSize: 8, AddrWidth: 1}},
+		{"size not a power of two", 0x50, Config{Size: 3 * 256, PageSize: 16, AddrWidth: 1}},
+		{"address width", 0x50, Config{Size: 256, PageSize: 8, AddrWidth: 3}},
+		{"too many blocks", 0x50, Config{Size: 4096, PageSize: 16, AddrWidth: 1}},
+		{"page larger than a block", 0x50, Config{Size: 128, PageSize: 512, AddrWidth: 1}},
+		{"address overlaps block bits", 0x51, Part24xx04},
+	}
+
+	for _, tt := range tests {
+		if _, err := New(i2csim.NewBus(), tt.addr, tt.config); err == nil {
+			t.Errorf("%s: got no error", tt.name)
+		}
+	}
+
+	if _, err := New(i2csim.NewBus(), 0x50, Part24xx16); err != nil {
+		t.Errorf("24xx16: got error %v", err)
+	}
+}
+
+func TestReadWrite(t *testing.T) {
+	for _, part := range []Config{Part24xx04, Part24xx64} {
+		bus, sim := newEEPROM(part, time.Millisecond)
+		e, err := New(bus, 0x50, part)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Write across several pages and, for the 24xx04, from the
+		// first block into the second.
+		data := make([]byte, 3*part.PageSize)
+		for i := range data {
+			data[i] = byte(i + 1)
+		}
+		off := int64(part.Size/2 - part.PageSize - 3)
+		if n, err := e.WriteAt(data, off); err != nil || n != len(data) {
+			t.Fatalf("WriteAt: got %d, %v", n, err)
+		}
+		if !bytes.Equal(sim.Data[off:off+int64(len(data))], data) {
+			t.Errorf("%+v: EEPROM holds % X, want % X", part, sim.Data[off:off+int64(len(data))], data)
+		}
+
+		got := make([]byte, len(data))
+		if n, err := e.ReadAt(got, off); err != nil || n != len(got) {
+			t.Fatalf("ReadAt: got %d, %v", n, err)
+		}
+		if !bytes.Equal(got, data) {
+			t.Errorf("%+v: read % X, want % X", part, got, data)
+		}
+
+		if _, err := e.ReadAt(got, e.Size()-1); err == nil {
+			t.Errorf("%+v: read past the end: got no error", part)
+		}
+	}
+}
+
+func TestErrors(t *testing.T) {
+	bus, _ := newEEPROM(Part24xx02, time.Hour)
+	e, err := New(bus, 0x50, Part24xx02)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The write cycle never finishes.
+	if _, err := e.WriteAt([]byte{1}, 0); err != ErrTimeout {
+		t.Errorf("got error %v, want %v", err, ErrTimeout)
+	}
+
+	missing, err := New(bus, 0x58, Part24xx02)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = missing.ReadAt(make([]byte, 4), 0)
+	want := &libmpsse.I2CNackError{Addr: 0x58, Msg: 0, Byte: 0}
+	if !reflect.DeepEqual(err, want) {
+		t.Errorf("missing device: got error %v, want %v", err, want)
+	}
+
+	failed := errors.New("USB transfer failed")
+	bus.FailNext(failed)
+	if _, err := missing.WriteAt([]byte{1}, 0); err != failed {
+		t.Errorf("got error %v, want %v", err, failed)
+	}
+}
@@ -0,0 +1,256 @@
+/*
+Package at25 is a driver for 25xx series SPI EEPROMs (25LCxxx, 25AAxxx,
+AT25xxx and compatible parts) attached to an FTDI chip through libmpsse.
+
+The EEPROM implements io.ReaderAt and io.WriterAt. Writes are split along
+page boundaries, with the write enable latch set before each page and the
+status register polled until the write cycle completes.
+
+The EEPROM is accessed through a libmpsse.SPIBus, such as an Mpsse opened
+in SPI0 or SPI3 mode, MSB first.
+*/
+package at25
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/vapor-ware/libmpsse"
+)
+
+// 25xx instruction set.
+const (
+	cmdWriteStatus = 0x01
+	cmdWrite       = 0x02
+	cmdRead        = 0x03
+	cmdReadStatus  = 0x05
+	cmdWriteEnable = 0x06
+)
+
+// Status register bits.
+const (
+	// StatusWIP is set while a write cycle is in progress.
+	StatusWIP = 0x01
+
+	// StatusWEL is the write enable latch.
+	StatusWEL = 0x02
+
+	// StatusBP is the mask of the block protect bits (BP0-BP1).
+	StatusBP = 0x0C
+
+	// StatusWPEN enables the WP# pin to lock the status register.
+	StatusWPEN = 0x80
+)
+
+// address8 is set in the instruction byte to select the upper half of
+// parts with 512 bytes and a 1 byte address (e.g. 25xx040).
+const address8 = 0x08
+
+// writeTimeout is the longest a write cycle is allowed to take. Datasheets
+// list a maximum of 5-10ms.
+const writeTimeout = 50 * time.Millisecond
+
+// pollInterval is the time between status register reads while waiting
+// for a write cycle to finish.
+const pollInterval = time.Millisecond
+
+// ErrTimeout is returned when the EEPROM does not finish a write cycle
+// within the expected time.
+var ErrTimeout = errors.New("timed out waiting for EEPROM write cycle")
+
+// Config describes the organization of an EEPROM part.
+type Config struct {
+	// Size is the size of the EEPROM, in bytes.
+	Size int
+
+	// PageSize is the size of a write page, in bytes. Writes can not cross
+	// a page boundary.
+	PageSize int
+
+	// AddrWidth is the number of address bytes sent with each read or
+	// write, between 1 and 3. Parts with 512 bytes and a 1 byte address
+	// use bit 3 of the instruction for the ninth address bit.
+	AddrWidth int
+}
+
+// Configurations for some common parts.
+var (
+	Part25xx040  = Config{Size: 512, PageSize: 16, AddrWidth: 1}
+	Part25xx256  = Config{Size: 32 * 1024, PageSize: 64, AddrWidth: 2}
+	Part25xx512  = Config{Size: 64 * 1024, PageSize: 128, AddrWidth: 2}
+	Part25xx1024 = Config{Size: 128 * 1024, PageSize: 256, AddrWidth: 3}
+)
+
+// EEPROM is a 25xx series SPI EEPROM.
+type EEPROM struct {
+	bus    libmpsse.SPIBus
+	config Config
+}
+
+// New creates an EEPROM for the part described by config, attached to an
+// SPI bus.
+func New(bus libmpsse.SPIBus, config Config) (*EEPROM, error) {
+	if config.Size <= 0 || config.PageSize <= 0 {
+		return nil, fmt.Errorf("invalid EEPROM size %d / page size %d", config.Size, config.PageSize)
+	}
+	if config.AddrWidth < 1 || config.AddrWidth > 3 {
+		return nil, fmt.Errorf("invalid EEPROM address width %d", config.AddrWidth)
+	}
+
+	limit := 1 << (uint(config.AddrWidth) * 8)
+	if config.AddrWidth == 1 {
+		limit *= 2
+	}
+	if config.Size > limit {
+		return nil, fmt.Errorf("EEPROM size %d can not be addressed with %d address bytes", config.Size, config.AddrWidth)
+	}
+
+	return &EEPROM{
+		bus:    bus,
+		config: config,
+	}, nil
+}
+
+// Size returns the size of the EEPROM, in bytes.
+func (e *EEPROM) Size() int64 {
+	return int64(e.config.Size)
+}
+
+// ReadAt reads len(p) bytes from the EEPROM starting at offset off. It
+// implements io.ReaderAt.
+func (e *EEPROM) ReadAt(p []byte, off int64) (int, error) {
+	if err := e.checkRange(len(p), off); err != nil {
+		return 0, err
+	}
+	if len(p) == 0 {
+		return 0, nil
+	}
+
+	data, err := e.transaction(e.addressed(cmdRead, int(off)), len(p))
+	if err != nil {
+		return 0, err
+	}
+	return copy(p, data), nil
+}
+
+// WriteAt writes len(p) bytes to the EEPROM starting at offset off. The
+// write is split into page writes, each of which waits for the write cycle
+// to complete before the next is started. It implements io.WriterAt.
+func (e *EEPROM) WriteAt(p []byte, off int64) (int, error) {
+	if err := e.checkRange(len(p), off); err != nil {
+		return 0, err
+	}
+
+	addr := int(off)
+	written := 0
+	for written < len(p) {
+		n := e.config.PageSize - addr%e.config.PageSize
+		if n > len(p)-written {
+			n = len(p) - written
+		}
+
+		if err := e.writePage(addr, p[written:written+n]); err != nil {
+			return written, err
+		}
+		addr += n
+		written += n
+	}
+	return written, nil
+}
+
+// ReadStatus reads the status register.
+func (e *EEPROM) ReadStatus() (byte, error) {
+	data, err := e.transaction([]byte{cmdReadStatus}, 1)
+	if err != nil {
+		return 0, err
+	}
+	return data[0], nil
+}
+
+// WriteStatus writes the status register, which holds the block protect
+// and WPEN bits.
+func (e *EEPROM) WriteStatus(status byte) error {
+	if err := e.writeEnable(); err != nil {
+		return err
+	}
+	if _, err := e.transaction([]byte{cmdWriteStatus, status}, 0); err != nil {
+		return err
+	}
+	return e.WaitReady(writeTimeout)
+}
+
+// WaitReady polls the status register until the write in progress bit
+// clears or the timeout expires.
+func (e *EEPROM) WaitReady(timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		status, err := e.ReadStatus()
+		if err != nil {
+			return err
+		}
+		if status&StatusWIP == 0 {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return ErrTimeout
+		}
+		time.Sleep(pollInterval)
+	}
+}
+
+// writePage writes data, which must not cross a page boundary, at addr.
+func (e *EEPROM) writePage(addr int, data []byte) error {
+	if err := e.writeEnable(); err != nil {
+		return err
+	}
+	if _, err := e.transaction(append(e.addressed(cmdWrite, addr), data...), 0); err != nil {
+		return err
+	}
+	return e.WaitReady(writeTimeout)
+}
+
+// writeEnable sets the write enable latch and checks that it was set. The
+// latch is cleared by the EEPROM at the end of every write cycle.
+func (e *EEPROM) writeEnable() error {
+	if _, err := e.transaction([]byte{cmdWriteEnable}, 0); err != nil {
+		return err
+	}
+
+	status, err := e.ReadStatus()
+	if err != nil {
+		return err
+	}
+	if status&StatusWEL == 0 {
+		return fmt.Errorf("EEPROM write enable latch did not set (status 0x%02x)", status)
+	}
+	return nil
+}
+
+// checkRange checks that an access of size bytes at off is inside the
+// EEPROM.
+func (e *EEPROM) checkRange(size int, off int64) error {
+	if off < 0 || off+int64(size) > int64(e.config.Size) {
+		return fmt.Errorf("access of %d bytes at 0x%x is outside of EEPROM (size 0x%x)", size, off, e.config.Size)
+	}
+	return nil
+}
+
+// addressed builds an instruction followed by the address.
+func (e *EEPROM) addressed(cmd byte, addr int) []byte {
+	if e.config.AddrWidth == 1 && addr > 0xFF {
+		cmd |= address8
+	}
+
+	buf := []byte{cmd}
+	for i := e.config.AddrWidth - 1; i >= 0; i-- {
+		buf = append(buf, byte(addr>>(uint(i)*8)))
+	}
+	return buf
+}
+
+// transaction writes out while the chip select is asserted, then reads
+// size bytes back before deasserting the chip select.
+func (e *EEPROM) transaction(out []byte, size int) ([]byte, error) {
+	return e.bus.SPITransaction(out, size)
+}
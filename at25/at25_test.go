@@ -0,0 +1,252 @@
+package at25
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+// spiEEPROM simulates a 25xx EEPROM on an SPI bus.
+type spiEEPROM struct {
+	data      []byte
+	pageSize  int
+	addrWidth int
+	status    byte
+
+	// busy is the number of status reads that report a write in progress
+	// after each write cycle starts; -1 keeps the write in progress.
+	busy int
+	wip  int
+
+	// stuckWEL stops the write enable latch from setting.
+	stuckWEL bool
+
+	// fail is returned by the next transaction, if set.
+	fail error
+
+	// cmds holds the instruction byte of each transaction, and pages the
+	// address and length of each page write.
+	cmds  []byte
+	pages [][2]int
+}
+
+func newSPIEEPROM(config Config) *spiEEPROM {
+	return &spiEEPROM{
+		data:      make([]byte, config.Size),
+		pageSize:  config.PageSize,
+		addrWidth: config.AddrWidth,
+	}
+}
+
+func (s *spiEEPROM) SPITransaction(out []byte, size int) ([]byte, error) {
+	if err := s.fail; err != nil {
+		s.fail = nil
+		return nil, err
+	}
+	s.cmds = append(s.cmds, out[0])
+	in := make([]byte, size)
+
+	switch out[0] &^ address8 {
+	case cmdWriteEnable:
+		if !s.stuckWEL {
+			s.status |= StatusWEL
+		}
+	case cmdReadStatus:
+		status := s.status
+		if s.wip != 0 {
+			status |= StatusWIP
+			if s.wip > 0 {
+				s.wip--
+			}
+		}
+		for i := range in {
+			in[i] = status
+		}
+	case cmdWriteStatus:
+		if s.startWrite() {
+			s.status = s.status&^(StatusBP|StatusWPEN) | out[1]&(StatusBP|StatusWPEN)
+		}
+	case cmdRead:
+		addr := s.addr(out)
+		for i := range in {
+			in[i] = s.data[(addr+i)%len(s.data)]
+		}
+	case cmdWrite:
+		if s.startWrite() {
+			addr := s.addr(out)
+			data := out[1+s.addrWidth:]
+			s.pages = append(s.pages, [2]int{addr, len(data)})
+
+			// Like the real parts, the address wraps around within the
+			// page.
+			page := addr - addr%s.pageSize
+			for i, b := range data {
+				s.data[page+(addr-page+i)%s.pageSize] = b
+			}
+		}
+	}
+	return in, nil
+}
+
+// startWrite starts a write cycle if the write enable latch is set, and
+// clears the latch.
+func (s *spiEEPROM) startWrite() bool {
+	if s.status&StatusWEL == 0 || s.wip != 0 {
+		return false
+	}
+	s.status &^= StatusWEL
+	s.wip = s.busy
+	return true
+}
+
+// addr decodes the address that follows the instruction in out.
+func (s *spiEEPROM) addr(out []byte) int {
+	addr := 0
+	for _, b := range out[1 : 1+s.addrWidth] {
+		addr = addr<<8 | int(b)
+	}
+	if s.addrWidth == 1 && out[0]&address8 != 0 {
+		addr |= 0x100
+	}
+	return addr
+}
+
+func TestNew(t *testing.T) {
+	tests := []struct {
+		name   string
+		config Config
+	}{
+		{"zero size", Config{Size: 0, PageSize: 16, AddrWidth: 1}},
+		{"zero page size", Config{Size: 512, PageSize: 0, AddrWidth: 1}},
+		{"address width", Config{Size: 512, PageSize: 16, AddrWidth: 4}},
+		{"size too large for address", Config{Size: 1024, PageSize: 16, AddrWidth: 1}},
+	}
+
+	for _, tt := range tests {
+		if _, err := New(newSPIEEPROM(Part25xx040), tt.config); err == nil {
+			t.Errorf("%s: got no error", tt.name)
+		}
+	}
+}
+
+func TestReadWrite(t *testing.T) {
+	for _, part := range []Config{Part25xx040, Part25xx256, Part25xx1024} {
+		sim := newSPIEEPROM(part)
+		sim.busy = 3
+		e, err := New(sim, part)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Write across several pages and, for the 25xx040, from the
+		// lower half into the upper half.
+		data := make([]byte, 3*part.PageSize)
+		for i := range data {
+			data[i] = byte(i + 1)
+		}
+		off := int64(part.Size/2 - part.PageSize - 3)
+		if n, err := e.WriteAt(data, off); err != nil || n != len(data) {
+			t.Fatalf("%+v: WriteAt: got %d, %v", part, n, err)
+		}
+		if !bytes.Equal(sim.data[off:off+int64(len(data))], data) {
+			t.Errorf("%+v: EEPROM holds % X, want % X", part, sim.data[off:off+int64(len(data))], data)
+		}
+
+		// The write is split at the page boundaries.
+		if len(sim.pages) != 4 {
+			t.Errorf("%+v: got %d page writes, want 4", part, len(sim.pages))
+		}
+		for _, p := range sim.pages {
+			if p[0]/part.PageSize != (p[0]+p[1]-1)/part.PageSize {
+				t.Errorf("%+v: page write of %d bytes at 0x%x crosses a page boundary", part, p[1], p[0])
+			}
+		}
+
+		got := make([]byte, len(data))
+		if n, err := e.ReadAt(got, off); err != nil || n != len(got) {
+			t.Fatalf("%+v: ReadAt: got %d, %v", part, n, err)
+		}
+		if !bytes.Equal(got, data) {
+			t.Errorf("%+v: read % X, want % X", part, got, data)
+		}
+
+		if _, err := e.ReadAt(got, e.Size()-1); err == nil {
+			t.Errorf("%+v: read past the end: got no error", part)
+		}
+		if _, err := e.WriteAt(got, -1); err == nil {
+			t.Errorf("%+v: write before the start: got no error", part)
+		}
+	}
+}
+
+func TestWriteSequence(t *testing.T) {
+	sim := newSPIEEPROM(Part25xx256)
+	sim.busy = 2
+	e, err := New(sim, Part25xx256)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := e.WriteAt([]byte{1, 2}, 0x10); err != nil {
+		t.Fatal(err)
+	}
+
+	// The write enable latch is set and checked, the page is written, and
+	// the status register is polled until the write in progress bit
+	// clears.
+	want := []byte{
+		cmdWriteEnable, cmdReadStatus, cmdWrite,
+		cmdReadStatus, cmdReadStatus, cmdReadStatus,
+	}
+	if !bytes.Equal(sim.cmds, want) {
+		t.Errorf("got instructions % X, want % X", sim.cmds, want)
+	}
+
+	sim.cmds = nil
+	if err := e.WriteStatus(StatusBP); err != nil {
+		t.Fatal(err)
+	}
+	if status, err := e.ReadStatus(); err != nil || status != StatusBP {
+		t.Errorf("ReadStatus: got 0x%02X, %v, want 0x%02X", status, err, StatusBP)
+	}
+	if sim.cmds[0] != cmdWriteEnable || sim.cmds[2] != cmdWriteStatus {
+		t.Errorf("WriteStatus: got instructions % X", sim.cmds)
+	}
+}
+
+func TestErrors(t *testing.T) {
+	sim := newSPIEEPROM(Part25xx256)
+	e, err := New(sim, Part25xx256)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The write enable latch does not set, so nothing is written.
+	sim.stuckWEL = true
+	if n, err := e.WriteAt([]byte{1}, 0); err == nil || n != 0 {
+		t.Errorf("write enable latch stuck: got %d, %v, want error", n, err)
+	}
+	if len(sim.pages) != 0 {
+		t.Errorf("write enable latch stuck: got %d page writes", len(sim.pages))
+	}
+	sim.stuckWEL = false
+
+	// The write cycle never finishes.
+	sim.busy = -1
+	if _, err := e.WriteAt([]byte{1}, 0); err != ErrTimeout {
+		t.Errorf("got error %v, want %v", err, ErrTimeout)
+	}
+	sim.wip, sim.busy = 0, 0
+
+	// A failed transfer stops the write after the pages already written.
+	data := make([]byte, 2*Part25xx256.PageSize)
+	failed := errors.New("USB transfer failed")
+	sim.fail = failed
+	if n, err := e.WriteAt(data, 0); err != failed || n != 0 {
+		t.Errorf("got %d, %v, want 0, %v", n, err, failed)
+	}
+	sim.fail = failed
+	if _, err := e.ReadAt(data, 0); err != failed {
+		t.Errorf("got error %v, want %v", err, failed)
+	}
+}
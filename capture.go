@@ -0,0 +1,318 @@
+package libmpsse
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// captureChunkSize is the number of samples read at a time while
+// capturing. It matches SYNCBB_TRANSFER_SIZE in the C implementation.
+const captureChunkSize = 128
+
+// captureAhead is the number of chunks of pin levels that Capture keeps
+// queued in the chip ahead of the samples it has read. This keeps the
+// chip sampling while earlier samples are read over USB, and is well
+// below the size of the chip's FIFOs, so writing the next chunk never
+// blocks while the chip is waiting for its samples to be read.
+const captureAhead = 4
+
+// ErrTriggerTimeout is returned by Capture when the trigger condition is
+// not met within the trigger's timeout.
+var ErrTriggerTimeout = errors.New("timed out waiting for capture trigger")
+
+// Trigger is the condition that starts a capture. The zero Trigger starts
+// capturing immediately.
+type Trigger struct {
+	// Mask and Value form a pattern trigger: the capture starts at the
+	// first sample where the pins in Mask are at the levels in Value.
+	Mask  byte
+	Value byte
+
+	// Edge, if not zero, adds an edge trigger: the capture starts at the
+	// first sample where pin EdgePin (0-7) has made the transition, which
+	// may be RisingEdge, FallingEdge or BothEdges. If a pattern is also
+	// set, both must match at the same sample.
+	Edge    Edge
+	EdgePin int
+
+	// Timeout is how long to wait for the trigger, or 0 to wait forever.
+	Timeout time.Duration
+}
+
+// immediate reports whether the trigger starts the capture immediately.
+func (t Trigger) immediate() bool {
+	return t.Mask == 0 && t.Edge == 0
+}
+
+// matches reports whether sample meets the trigger condition, given the
+// previous sample.
+func (t Trigger) matches(prev, sample byte) bool {
+	if sample&t.Mask != t.Value&t.Mask {
+		return false
+	}
+	if t.Edge == 0 {
+		return true
+	}
+
+	bit := byte(1) << uint(t.EdgePin)
+	rising := prev&bit == 0 && sample&bit != 0
+	falling := prev&bit != 0 && sample&bit == 0
+	return (t.Edge&RisingEdge != 0 && rising) || (t.Edge&FallingEdge != 0 && falling)
+}
+
+// Capture samples all 8 pins at rate samples per second using synchronous
+// bitbang, and returns samples bytes, one per sample, with bit n holding
+// the level of pin n. Sampling starts when trigger is met; the sample that
+// met it is the first one returned.
+//
+// Synchronous bitbang takes a sample for each byte written to the chip, so
+// Capture keeps several chunks of writes queued ahead of the samples it
+// reads, as a Stream does with its read commands. The pins are sampled
+// continuously unless the chip's receive FIFO fills because the samples
+// are not read over USB fast enough, which may happen at high rates. Pins
+// that are outputs keep being driven at their current levels while
+// capturing. The rate is set through the chip's baud rate generator, so it
+// is approximate (see SetBitbangRate).
+//
+// For use in BITBANG mode only, with FlushAfterRead disabled. The Mpsse
+// stays locked for the whole capture. The bitbang rate is restored at the
+// end of the capture, and synchronous bitbang is disabled again if it was
+// not enabled before.
+func (m *Mpsse) Capture(samples int, rate uint32, trigger Trigger) (data []byte, err error) {
+	if samples <= 0 {
+		return nil, fmt.Errorf("invalid capture sample count %d", samples)
+	}
+	if rate == 0 {
+		return nil, fmt.Errorf("invalid capture sample rate %d", rate)
+	}
+	if trigger.Edge != 0 && (trigger.EdgePin < 0 || trigger.EdgePin > 7) {
+		return nil, fmt.Errorf("invalid capture trigger pin %d", trigger.EdgePin)
+	}
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	state := m.state()
+	if state.mode != BITBANG {
+		return nil, fmt.Errorf("capture requires BITBANG mode")
+	}
+	if state.flushAfterRead {
+		return nil, fmt.Errorf("capture requires FlushAfterRead to be disabled")
+	}
+
+	if err := m.setBitbangRate(int(rate)); err != nil {
+		return nil, err
+	}
+	if state.bitbangRate > 0 {
+		defer func() {
+			if rerr := m.setBitbangRate(state.bitbangRate); rerr != nil && err == nil {
+				data, err = nil, rerr
+			}
+		}()
+	}
+	if !state.syncBitbang {
+		if err := m.setSyncBitbang(1); err != nil {
+			return nil, err
+		}
+		defer func() {
+			if serr := m.setSyncBitbang(0); serr != nil && err == nil {
+				data, err = nil, serr
+			}
+		}()
+	}
+
+	// Write the current pin levels back with every sample, so that
+	// outputs hold their levels.
+	hold := bytes.Repeat([]byte{byte(m.readPins())}, captureChunkSize)
+
+	return capture(m, hold, samples, trigger)
+}
+
+// capture reads samples from port once trigger fires, writing hold for each
+// chunk of samples to clock in.
+func capture(port rawPort, hold []byte, samples int, trigger Trigger) ([]byte, error) {
+	var deadline time.Time
+	if trigger.Timeout > 0 {
+		deadline = time.Now().Add(trigger.Timeout)
+	}
+
+	queued := 0
+	for ; queued < captureAhead; queued++ {
+		if err := port.rawWrite(hold); err != nil {
+			drainCapture(port, queued)
+			return nil, err
+		}
+	}
+
+	data := make([]byte, 0, samples+captureChunkSize)
+	chunk := make([]byte, captureChunkSize)
+	triggered := trigger.immediate()
+	first := true
+	var prev byte
+
+	for len(data) < samples {
+		if !triggered && !deadline.IsZero() && time.Now().After(deadline) {
+			drainCapture(port, queued)
+			return nil, ErrTriggerTimeout
+		}
+
+		// A failed read has taken the chunk it was for.
+		if err := port.rawRead(chunk); err != nil {
+			drainCapture(port, queued-1)
+			return nil, err
+		}
+		queued--
+
+		if triggered {
+			data = append(data, chunk...)
+		} else {
+			for i, sample := range chunk {
+				// An edge trigger needs a previous sample to compare
+				// against.
+				if !first || trigger.Edge == 0 {
+					if trigger.matches(prev, sample) {
+						triggered = true
+						data = append(data, chunk[i:]...)
+						break
+					}
+				}
+				prev = sample
+				first = false
+			}
+		}
+
+		// Only queue more samples than are already on their way if they
+		// are needed.
+		if !triggered || len(data)+queued*captureChunkSize < samples {
+			if err := port.rawWrite(hold); err != nil {
+				drainCapture(port, queued)
+				return nil, err
+			}
+			queued++
+		}
+	}
+
+	if err := drainCapture(port, queued); err != nil {
+		return nil, err
+	}
+	return data[:samples], nil
+}
+
+// drainCapture reads and discards the samples of the chunks that are still
+// queued, so that they are not returned by later reads. When a capture
+// fails, the error from draining is dropped in favour of the first one.
+func drainCapture(port rawPort, queued int) error {
+	chunk := make([]byte, captureChunkSize)
+	for ; queued > 0; queued-- {
+		if err := port.rawRead(chunk); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// captureNames returns names, or the default pin names D0-D7 if names is
+// empty.
+func captureNames(names []string) ([]string, error) {
+	if len(names) == 0 {
+		return []string{"D0", "D1", "D2", "D3", "D4", "D5", "D6", "D7"}, nil
+	}
+	if len(names) > 8 {
+		return nil, fmt.Errorf("%d names given for 8 capture channels", len(names))
+	}
+	return names, nil
+}
+
+// WriteVCD writes samples returned by Capture, taken at rate samples per
+// second, to w as a Value Change Dump file. names are the names of the
+// channels, from pin 0 up; if names is empty, the channels are named D0-D7.
+// If fewer than 8 names are given, only that many channels are written.
+func WriteVCD(w io.Writer, samples []byte, rate uint32, names []string) error {
+	if rate == 0 {
+		return fmt.Errorf("invalid capture sample rate %d", rate)
+	}
+	names, err := captureNames(names)
+	if err != nil {
+		return err
+	}
+
+	v, err := newVCDWriter(w, "capture", names)
+	if err != nil {
+		return err
+	}
+	for i, sample := range samples {
+		v.set(sampleTime(i, rate), uint32(sample))
+	}
+	return v.finish(sampleTime(len(samples), rate))
+}
+
+// sampleTime returns the time of sample n at rate samples per second, in
+// nanoseconds.
+func sampleTime(n int, rate uint32) int64 {
+	return int64(n) * int64(time.Second) / int64(rate)
+}
+
+// WriteSigrok writes samples returned by Capture, taken at rate samples
+// per second, to w as a sigrok session file (.sr), which can be opened in
+// PulseView. names are the names of the channels, as for WriteVCD.
+func WriteSigrok(w io.Writer, samples []byte, rate uint32, names []string) error {
+	if rate == 0 {
+		return fmt.Errorf("invalid capture sample rate %d", rate)
+	}
+	names, err := captureNames(names)
+	if err != nil {
+		return err
+	}
+	return writeSigrok(w, samples, 1, rate, names)
+}
+
+// writeSigrok writes a sigrok session file of samples that are unitsize
+// bytes each, with a channel for each of names from bit 0 up.
+func writeSigrok(w io.Writer, samples []byte, unitsize int, rate uint32, names []string) error {
+	var metadata bytes.Buffer
+	fmt.Fprintf(&metadata, "[global]\nsigrok version=0.5.1\n\n")
+	fmt.Fprintf(&metadata, "[device 1]\ncapturefile=logic-1\ntotal probes=%d\n", len(names))
+	fmt.Fprintf(&metadata, "samplerate=%s\ntotal analog=0\n", sigrokRate(rate))
+	for i, name := range names {
+		fmt.Fprintf(&metadata, "probe%d=%s\n", i+1, name)
+	}
+	fmt.Fprintf(&metadata, "unitsize=%d\n", unitsize)
+
+	z := zip.NewWriter(w)
+	files := []struct {
+		name string
+		data []byte
+	}{
+		{"version", []byte("2")},
+		{"metadata", metadata.Bytes()},
+		{"logic-1-1", samples},
+	}
+	for _, file := range files {
+		f, err := z.Create(file.name)
+		if err != nil {
+			return err
+		}
+		if _, err := f.Write(file.data); err != nil {
+			return err
+		}
+	}
+	return z.Close()
+}
+
+// sigrokRate formats a sample rate the way sigrok does.
+func sigrokRate(rate uint32) string {
+	switch {
+	case rate%1000000000 == 0:
+		return fmt.Sprintf("%d GHz", rate/1000000000)
+	case rate%1000000 == 0:
+		return fmt.Sprintf("%d MHz", rate/1000000)
+	case rate%1000 == 0:
+		return fmt.Sprintf("%d kHz", rate/1000)
+	default:
+		return fmt.Sprintf("%d Hz", rate)
+	}
+}
@@ -0,0 +1,199 @@
+package libmpsse
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeCapturePort returns a chunk of samples for each chunk of pin levels
+// written to it. The samples count up from 0.
+type fakeCapturePort struct {
+	// queued is the number of chunks written but not yet read, and
+	// writes and reads count the chunks written and read.
+	queued int
+	writes int
+	reads  int
+	next   byte
+
+	// failRead makes the read with this index (counting from 1) fail.
+	// overread is set if a read was made with no chunk queued.
+	failRead int
+	overread bool
+}
+
+func (p *fakeCapturePort) rawWrite(buf []byte) error {
+	if len(buf) != captureChunkSize {
+		return errors.New("partial chunk written")
+	}
+	p.queued++
+	p.writes++
+	return nil
+}
+
+func (p *fakeCapturePort) rawRead(buf []byte) error {
+	if p.queued == 0 {
+		p.overread = true
+		return errors.New("read with no chunk queued")
+	}
+	p.queued--
+	p.reads++
+	if p.reads == p.failRead {
+		return errors.New("USB transfer failed")
+	}
+	for i := range buf {
+		buf[i] = p.next
+		p.next++
+	}
+	return nil
+}
+
+func TestTriggerMatches(t *testing.T) {
+	tests := []struct {
+		name        string
+		trigger     Trigger
+		prev, input byte
+		want        bool
+	}{
+		{"pattern met", Trigger{Mask: 0x03, Value: 0x01}, 0x00, 0x05, true},
+		{"pattern not met", Trigger{Mask: 0x03, Value: 0x01}, 0x00, 0x03, false},
+		{"rising edge", Trigger{Edge: RisingEdge, EdgePin: 2}, 0x00, 0x04, true},
+		{"no edge", Trigger{Edge: RisingEdge, EdgePin: 2}, 0x04, 0x04, false},
+		{"falling edge on rising", Trigger{Edge: FallingEdge, EdgePin: 2}, 0x00, 0x04, false},
+		{"both edges", Trigger{Edge: BothEdges, EdgePin: 2}, 0x04, 0x00, true},
+		{"edge without pattern", Trigger{Mask: 0x01, Value: 0x01, Edge: RisingEdge, EdgePin: 2}, 0x00, 0x04, false},
+		{"edge and pattern", Trigger{Mask: 0x01, Value: 0x01, Edge: RisingEdge, EdgePin: 2}, 0x00, 0x05, true},
+	}
+
+	for _, tt := range tests {
+		if got := tt.trigger.matches(tt.prev, tt.input); got != tt.want {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestCapture(t *testing.T) {
+	hold := make([]byte, captureChunkSize)
+
+	// All of the samples needed are already queued, so no more chunks are
+	// written; the chunk that is not needed is drained.
+	port := &fakeCapturePort{}
+	data, err := capture(port, hold, 300, Trigger{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data) != 300 || data[0] != 0 || data[299] != 299%256 {
+		t.Errorf("got %d samples from %d to %d", len(data), data[0], data[len(data)-1])
+	}
+	if port.writes != captureAhead || port.reads != captureAhead || port.queued != 0 {
+		t.Errorf("got %d chunks written and %d read, want %d of each", port.writes, port.reads, captureAhead)
+	}
+
+	// Chunks are queued while waiting for the trigger, and until enough
+	// samples are on their way.
+	port = &fakeCapturePort{}
+	data, err = capture(port, hold, 2*captureChunkSize, Trigger{Mask: 0xFF, Value: 200})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data) != 2*captureChunkSize || data[0] != 200 {
+		t.Errorf("got %d samples starting at %d, want %d starting at 200", len(data), data[0], 2*captureChunkSize)
+	}
+	if port.queued != 0 || port.overread {
+		t.Errorf("got %d chunks left queued, overread %v", port.queued, port.overread)
+	}
+	if port.writes != captureAhead+1 {
+		t.Errorf("got %d chunks written, want %d", port.writes, captureAhead+1)
+	}
+}
+
+func TestCaptureDrain(t *testing.T) {
+	hold := make([]byte, captureChunkSize)
+
+	// The chunk whose read failed is not read again, and the rest are
+	// drained.
+	for _, failRead := range []int{1, 2, 5} {
+		port := &fakeCapturePort{failRead: failRead}
+		if _, err := capture(port, hold, 10*captureChunkSize, Trigger{}); err == nil {
+			t.Errorf("read %d failed: got no error", failRead)
+		}
+		if port.queued != 0 || port.overread {
+			t.Errorf("read %d failed: got %d chunks left queued, overread %v", failRead, port.queued, port.overread)
+		}
+	}
+
+	// Pin 0 can not be low at the sample where it rises, so the trigger
+	// never fires.
+	port := &fakeCapturePort{}
+	trigger := Trigger{Mask: 0x01, Value: 0x00, Edge: RisingEdge, EdgePin: 0, Timeout: time.Millisecond}
+	if _, err := capture(port, hold, 100, trigger); err != ErrTriggerTimeout {
+		t.Errorf("got error %v, want %v", err, ErrTriggerTimeout)
+	}
+	if port.queued != 0 || port.overread {
+		t.Errorf("trigger timeout: got %d chunks left queued, overread %v", port.queued, port.overread)
+	}
+}
+
+func TestWriteVCD(t *testing.T) {
+	var buf bytes.Buffer
+	samples := []byte{0x00, 0x01, 0x01, 0x03}
+	if err := WriteVCD(&buf, samples, 1000000, []string{"CLK", "DATA"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Only the changes are written, 1 us apart.
+	want := "$enddefinitions $end\n" +
+		"#0\n$dumpvars\n0!\n0\"\n$end\n" +
+		"#1000\n1!\n" +
+		"#3000\n1\"\n" +
+		"#4000\n"
+	if got := buf.String(); !strings.HasSuffix(got, want) {
+		t.Errorf("got VCD\n%s\nwant it to end with\n%s", got, want)
+	}
+
+	if err := WriteVCD(&buf, samples, 0, nil); err == nil {
+		t.Error("zero rate: got no error")
+	}
+	if err := WriteVCD(&buf, samples, 1000, make([]string, 9)); err == nil {
+		t.Error("9 names: got no error")
+	}
+}
+
+func TestWriteSigrok(t *testing.T) {
+	var buf bytes.Buffer
+	samples := []byte{0x00, 0x81, 0xFF}
+	if err := WriteSigrok(&buf, samples, 2000000, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	z, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := make(map[string]string)
+	for _, f := range z.File {
+		r, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := ioutil.ReadAll(r)
+		r.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		files[f.Name] = string(data)
+	}
+
+	if got := files["logic-1-1"]; got != string(samples) {
+		t.Errorf("got samples % X, want % X", got, samples)
+	}
+	for _, line := range []string{"samplerate=2 MHz", "total probes=8", "probe1=D0", "probe8=D7", "unitsize=1"} {
+		if !strings.Contains(files["metadata"], line+"\n") {
+			t.Errorf("metadata is missing %q:\n%s", line, files["metadata"])
+		}
+	}
+}
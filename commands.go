@@ -0,0 +1,85 @@
+package libmpsse
+
+// MPSSE opcodes used to build raw command buffers and to interpret traces.
+// These values match the definitions in libftdi and the C implementation.
+const (
+	cmdSetBitsLow           = 0x80
+	cmdGetBitsLow           = 0x81
+	cmdSetBitsHigh          = 0x82
+	cmdGetBitsHigh          = 0x83
+	cmdLoopbackStart        = 0x84
+	cmdLoopbackEnd          = 0x85
+	cmdTCKDivisor           = 0x86
+	cmdSendImmediate        = 0x87
+	cmdTCKX5                = 0x8A
+	cmdTCKD5                = 0x8B
+	cmdEnable3PhaseClock    = 0x8C
+	cmdDisable3PhaseClock   = 0x8D
+	cmdClockNCycles         = 0x8E
+	cmdClockN8Cycles        = 0x8F
+	cmdEnableAdaptiveClock  = 0x96
+	cmdDisableAdaptiveClock = 0x97
+	cmdTristateIO           = 0x9E
+)
+
+// Flags that are combined into the MPSSE data shifting commands.
+const (
+	mpsseWriteNeg = 0x01
+	mpsseBitmode  = 0x02
+	mpsseReadNeg  = 0x04
+	mpsseLSB      = 0x08
+	mpsseDoWrite  = 0x10
+	mpsseDoRead   = 0x20
+	mpsseWriteTMS = 0x40
+)
+
+// Low byte pin bits. These values match the pins enum defined in the C
+// implementation.
+const (
+	pinSK    = 0x01
+	pinDO    = 0x02
+	pinDI    = 0x04
+	pinGPIO0 = 0x10
+)
+
+// rawPort is the part of an Mpsse that raw command buffers are sent
+// through. The code that builds command buffers takes a rawPort rather than
+// an *Mpsse so that it can be tested against a fake chip.
+type rawPort interface {
+	rawWrite(buf []byte) error
+	rawRead(buf []byte) error
+}
+
+// mpsseState is a snapshot of the pin states and data shifting commands
+// that the C implementation has configured for the current mode.
+type mpsseState struct {
+	mode    Mode
+	started bool
+
+	// Low byte pin values for the idle, start and stop conditions, and
+	// the low byte pin directions (1 is out).
+	pidle  byte
+	pstart byte
+	pstop  byte
+	tris   byte
+
+	// High byte pin values and directions.
+	gpioh byte
+	trish byte
+
+	// Data shifting commands for writes, reads and transfers.
+	tx   byte
+	rx   byte
+	txrx byte
+
+	// The ACK bit that is sent after each byte read in I2C mode.
+	tack byte
+
+	// Whether synchronous bitbang is enabled in BITBANG mode, and the rate
+	// last set through the chip's baud rate generator.
+	syncBitbang bool
+	bitbangRate int
+
+	// Whether the receive buffers are flushed after each read.
+	flushAfterRead bool
+}
@@ -0,0 +1,193 @@
+package libmpsse
+
+import (
+	"fmt"
+	"sync"
+)
+
+// DaisyChain drives a chain of SPI devices whose shift registers are wired
+// in series, such as 74HC595/TPIC6B595 shift registers or chained DAC
+// channels. It keeps the current frame for every device in the chain so a
+// single device can be updated without the caller having to re-assemble the
+// frames for the rest of the chain.
+//
+// Devices are numbered from 0, starting with the device whose serial input
+// is wired to the FTDI data out pin. Since the first bits shifted out end up
+// in the last device of the chain, frames are written from the last device
+// to the first.
+//
+// A DaisyChain is safe for concurrent use: the frames are guarded by their
+// own lock, so an Update from one goroutine can not be mixed into a Write
+// from another.
+type DaisyChain struct {
+	port  daisyPort
+	latch GPIOPin
+
+	lock   sync.Mutex
+	frames [][]byte
+}
+
+// daisyPort is the part of an Mpsse that a DaisyChain drives. All of the
+// methods but locker are called with the lock held. It is an interface so
+// that the chain can be tested without a device.
+type daisyPort interface {
+	locker() sync.Locker
+	start() error
+	write(data string) error
+	stop() error
+	pinHigh(pin GPIOPin) error
+	pinLow(pin GPIOPin) error
+}
+
+// NewDaisyChain creates a DaisyChain on an Mpsse opened in one of the SPI
+// modes. The latch pin is pulsed high after each write to transfer the
+// shifted data to the device outputs, and is driven low here so that the
+// first pulse produces a rising edge. Each width is the size of a device's
+// frame, in bytes. All frames start out zeroed.
+func NewDaisyChain(m *Mpsse, latch GPIOPin, widths ...int) (*DaisyChain, error) {
+	return newDaisyChain(m, latch, widths...)
+}
+
+// newDaisyChain is NewDaisyChain for any daisyPort.
+func newDaisyChain(port daisyPort, latch GPIOPin, widths ...int) (*DaisyChain, error) {
+	if len(widths) == 0 {
+		return nil, fmt.Errorf("daisy chain must contain at least one device")
+	}
+
+	frames := make([][]byte, len(widths))
+	for i, width := range widths {
+		if width <= 0 {
+			return nil, fmt.Errorf("invalid frame width %d for device %d", width, i)
+		}
+		frames[i] = make([]byte, width)
+	}
+
+	l := port.locker()
+	l.Lock()
+	err := port.pinLow(latch)
+	l.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	return &DaisyChain{
+		port:   port,
+		latch:  latch,
+		frames: frames,
+	}, nil
+}
+
+// Len returns the number of devices in the chain.
+func (d *DaisyChain) Len() int {
+	return len(d.frames)
+}
+
+// Frame returns a copy of the current frame for the specified device.
+func (d *DaisyChain) Frame(device int) ([]byte, error) {
+	if device < 0 || device >= len(d.frames) {
+		return nil, fmt.Errorf("device %d out of range (chain has %d devices)", device, len(d.frames))
+	}
+
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	frame := make([]byte, len(d.frames[device]))
+	copy(frame, d.frames[device])
+	return frame, nil
+}
+
+// SetFrame sets the frame for the specified device without shifting it out.
+// The frame must match the device's frame width. This can be used to stage
+// changes to several devices before a single call to Write.
+func (d *DaisyChain) SetFrame(device int, frame []byte) error {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	return d.setFrame(device, frame)
+}
+
+// setFrame is SetFrame for callers that hold the chain's lock.
+func (d *DaisyChain) setFrame(device int, frame []byte) error {
+	if device < 0 || device >= len(d.frames) {
+		return fmt.Errorf("device %d out of range (chain has %d devices)", device, len(d.frames))
+	}
+	if len(frame) != len(d.frames[device]) {
+		return fmt.Errorf("frame for device %d must be %d bytes, got %d", device, len(d.frames[device]), len(frame))
+	}
+
+	copy(d.frames[device], frame)
+	return nil
+}
+
+// Update sets the frame for the specified device and writes the whole chain.
+func (d *DaisyChain) Update(device int, frame []byte) error {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	if err := d.setFrame(device, frame); err != nil {
+		return err
+	}
+	return d.write()
+}
+
+// Write shifts the frames for every device out in a single SPI write and
+// then pulses the latch pin. If the write fails, the chip select is still
+// deasserted, and the latch is not pulsed. The Mpsse is locked for the
+// whole sequence.
+func (d *DaisyChain) Write() error {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	return d.write()
+}
+
+// write is Write for callers that hold the chain's lock.
+func (d *DaisyChain) write() error {
+	data := make([]byte, 0, d.size())
+	for i := len(d.frames) - 1; i >= 0; i-- {
+		data = append(data, d.frames[i]...)
+	}
+
+	l := d.port.locker()
+	l.Lock()
+	defer l.Unlock()
+
+	if err := d.port.start(); err != nil {
+		return err
+	}
+	if err := d.port.write(string(data)); err != nil {
+		d.port.stop()
+		return err
+	}
+	if err := d.port.stop(); err != nil {
+		return err
+	}
+	return d.latchPulse()
+}
+
+// Latch pulses the latch pin, transferring the contents of the shift
+// registers to the device outputs.
+func (d *DaisyChain) Latch() error {
+	l := d.port.locker()
+	l.Lock()
+	defer l.Unlock()
+
+	return d.latchPulse()
+}
+
+// latchPulse is Latch for callers that hold the Mpsse's lock.
+func (d *DaisyChain) latchPulse() error {
+	if err := d.port.pinHigh(d.latch); err != nil {
+		return err
+	}
+	return d.port.pinLow(d.latch)
+}
+
+// size returns the total size of the chain, in bytes.
+func (d *DaisyChain) size() int {
+	n := 0
+	for _, frame := range d.frames {
+		n += len(frame)
+	}
+	return n
+}
@@ -0,0 +1,116 @@
+package libmpsse
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+// fakeDaisyPort records the operations a DaisyChain performs.
+type fakeDaisyPort struct {
+	lock sync.Mutex
+	ops  []string
+
+	// failWrite is returned by the next write, if set.
+	failWrite error
+}
+
+func (p *fakeDaisyPort) locker() sync.Locker { return &p.lock }
+
+func (p *fakeDaisyPort) start() error {
+	p.ops = append(p.ops, "start")
+	return nil
+}
+
+func (p *fakeDaisyPort) write(data string) error {
+	if err := p.failWrite; err != nil {
+		p.failWrite = nil
+		return err
+	}
+	p.ops = append(p.ops, fmt.Sprintf("write % X", data))
+	return nil
+}
+
+func (p *fakeDaisyPort) stop() error {
+	p.ops = append(p.ops, "stop")
+	return nil
+}
+
+func (p *fakeDaisyPort) pinHigh(pin GPIOPin) error {
+	p.ops = append(p.ops, "high "+pin.String())
+	return nil
+}
+
+func (p *fakeDaisyPort) pinLow(pin GPIOPin) error {
+	p.ops = append(p.ops, "low "+pin.String())
+	return nil
+}
+
+func TestDaisyChainWrite(t *testing.T) {
+	port := &fakeDaisyPort{}
+	d, err := newDaisyChain(port, GPIOL1, 1, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := d.SetFrame(0, []byte{0xAA}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The last device's frame is shifted out first.
+	if err := d.Update(1, []byte{0x01, 0x02}); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"low GPIOL1", "start", "write 01 02 AA", "stop", "high GPIOL1", "low GPIOL1"}
+	if !reflect.DeepEqual(port.ops, want) {
+		t.Errorf("got operations %q, want %q", port.ops, want)
+	}
+
+	if frame, err := d.Frame(1); err != nil || !reflect.DeepEqual(frame, []byte{0x01, 0x02}) {
+		t.Errorf("Frame(1): got % X, %v", frame, err)
+	}
+}
+
+func TestDaisyChainWidths(t *testing.T) {
+	port := &fakeDaisyPort{}
+	if _, err := newDaisyChain(port, GPIOL1); err == nil {
+		t.Error("no devices: got no error")
+	}
+	if _, err := newDaisyChain(port, GPIOL1, 1, 0); err == nil {
+		t.Error("zero width: got no error")
+	}
+
+	d, err := newDaisyChain(port, GPIOL1, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := d.SetFrame(0, []byte{1}); err == nil {
+		t.Error("short frame: got no error")
+	}
+	if err := d.SetFrame(1, []byte{1, 2}); err == nil {
+		t.Error("device out of range: got no error")
+	}
+	if _, err := d.Frame(-1); err == nil {
+		t.Error("Frame(-1): got no error")
+	}
+}
+
+func TestDaisyChainWriteError(t *testing.T) {
+	port := &fakeDaisyPort{}
+	d, err := newDaisyChain(port, GPIOL1, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	port.ops = nil
+
+	// The chip select is released and the latch is not pulsed.
+	failed := errors.New("USB transfer failed")
+	port.failWrite = failed
+	if err := d.Write(); err != failed {
+		t.Errorf("got error %v, want %v", err, failed)
+	}
+	if want := []string{"start", "stop"}; !reflect.DeepEqual(port.ops, want) {
+		t.Errorf("got operations %q, want %q", port.ops, want)
+	}
+}
@@ -1,5 +1,9 @@
 package libmpsse
 
+import (
+	"fmt"
+)
+
 // MpsseError is the error that is returned when an MPSSE failure is
 // detected. The error message it provides is the message retrieved from
 // the ErrorString function.
@@ -10,3 +14,57 @@ type MpsseError struct {
 func (e *MpsseError) Error() string {
 	return e.Message
 }
+
+// I2CNackError is the error that is returned when an I2C slave does not
+// acknowledge a byte.
+type I2CNackError struct {
+	// Addr is the address of the slave device.
+	Addr uint16
+
+	// Msg is the index of the message (the part of the transaction
+	// between start conditions) that contained the NACKed byte.
+	Msg int
+
+	// Byte is the index of the NACKed byte within the message. The
+	// address byte is byte 0.
+	Byte int
+}
+
+func (e *I2CNackError) Error() string {
+	if e.Byte == 0 {
+		return fmt.Sprintf("I2C device 0x%02x did not acknowledge its address (message %d)", e.Addr, e.Msg)
+	}
+	return fmt.Sprintf("I2C device 0x%02x did not acknowledge byte %d of message %d", e.Addr, e.Byte, e.Msg)
+}
+
+// I2CWriteNackError is the error that is returned by Write in I2C mode
+// when a byte is not acknowledged.
+type I2CWriteNackError struct {
+	// Byte is the index of the first NACKed byte in the data passed to
+	// Write.
+	Byte int
+}
+
+func (e *I2CWriteNackError) Error() string {
+	return fmt.Sprintf("I2C write byte %d was not acknowledged", e.Byte)
+}
+
+// PECError is the error that is returned when the Packet Error Code of an
+// SMBus transaction does not match the data received.
+type PECError struct {
+	// Addr is the address of the slave device.
+	Addr uint16
+
+	// Command is the SMBus command code of the transaction.
+	Command byte
+
+	// Received is the PEC sent by the slave, and Computed is the PEC
+	// calculated over the data received.
+	Received byte
+	Computed byte
+}
+
+func (e *PECError) Error() string {
+	return fmt.Sprintf("SMBus PEC mismatch for device 0x%02x command 0x%02x: received 0x%02x, computed 0x%02x",
+		e.Addr, e.Command, e.Received, e.Computed)
+}
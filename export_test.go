@@ -0,0 +1,7 @@
+package libmpsse
+
+// CRC8 exports crc8 for tests.
+var CRC8 = crc8
+
+// ScanI2CBus exports scanI2C for tests.
+var ScanI2CBus = scanI2C
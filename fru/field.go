@@ -0,0 +1,182 @@
+package fru
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// FieldType is the encoding of a field, from bits 7:6 of its type/length
+// byte.
+type FieldType byte
+
+// Field encodings.
+const (
+	// Binary is binary or unspecified data.
+	Binary FieldType = 0
+
+	// BCDPlus packs two characters from "0123456789 -." into each byte.
+	BCDPlus FieldType = 1
+
+	// SixBitASCII packs four characters from 0x20-0x5F into every three
+	// bytes.
+	SixBitASCII FieldType = 2
+
+	// Text is 8-bit ASCII + Latin 1 for English, the only language
+	// supported here.
+	Text FieldType = 3
+)
+
+// Limits of type/length bytes.
+const (
+	// maxFieldLen is the largest number of data bytes in a field.
+	maxFieldLen = 0x3F
+
+	// endOfFields is the type/length byte that follows the last field in
+	// an area.
+	endOfFields = 0xC1
+)
+
+// bcdPlus holds the characters of the BCD plus encoding; 0xD-0xF are
+// reserved.
+const bcdPlus = "0123456789 -."
+
+// Field is a variable length field of a FRU area. The data is kept in its
+// encoded form, so that areas are written back exactly as they were read.
+type Field struct {
+	Type FieldType
+	Data []byte
+}
+
+// TextField returns an 8-bit ASCII field holding s. Since a type/length
+// byte of 0xC1 marks the end of an area's fields, s can not be a single
+// character long.
+func TextField(s string) Field {
+	return Field{Type: Text, Data: []byte(s)}
+}
+
+// BinaryField returns a binary field holding data.
+func BinaryField(data []byte) Field {
+	return Field{Type: Binary, Data: data}
+}
+
+// BCDPlusField returns a BCD plus field holding s, which may only contain
+// digits, spaces, dashes and periods. An odd length string is padded with
+// a space.
+func BCDPlusField(s string) (Field, error) {
+	if len(s)%2 != 0 {
+		s += " "
+	}
+
+	data := make([]byte, len(s)/2)
+	for i := 0; i < len(s); i++ {
+		c := strings.IndexByte(bcdPlus, s[i])
+		if c < 0 {
+			return Field{}, fmt.Errorf("character %q can not be encoded as BCD plus", s[i])
+		}
+		if i%2 == 0 {
+			data[i/2] = byte(c) << 4
+		} else {
+			data[i/2] |= byte(c)
+		}
+	}
+	return Field{Type: BCDPlus, Data: data}, nil
+}
+
+// SixBitASCIIField returns a 6-bit ASCII field holding s, which may only
+// contain the characters 0x20-0x5F (upper case letters, digits, space and
+// punctuation). Strings whose length is not a multiple of 4 decode with
+// trailing spaces.
+func SixBitASCIIField(s string) (Field, error) {
+	data := make([]byte, (len(s)*6+7)/8)
+	for i := 0; i < len(s); i++ {
+		if s[i] < 0x20 || s[i] > 0x5F {
+			return Field{}, fmt.Errorf("character %q can not be encoded as 6-bit ASCII", s[i])
+		}
+
+		// Characters are packed least significant bit first.
+		c := uint(s[i] - 0x20)
+		bit := uint(i * 6)
+		data[bit/8] |= byte(c << (bit % 8))
+		if bit%8 > 2 {
+			data[bit/8+1] |= byte(c >> (8 - bit%8))
+		}
+	}
+	return Field{Type: SixBitASCII, Data: data}, nil
+}
+
+// String decodes the field. Binary fields are returned in hex.
+func (f Field) String() string {
+	switch f.Type {
+	case BCDPlus:
+		var b strings.Builder
+		for _, v := range f.Data {
+			for _, c := range []byte{v >> 4, v & 0x0F} {
+				if int(c) < len(bcdPlus) {
+					b.WriteByte(bcdPlus[c])
+				} else {
+					b.WriteByte('?')
+				}
+			}
+		}
+		return b.String()
+
+	case SixBitASCII:
+		n := len(f.Data) * 8 / 6
+		b := make([]byte, n)
+		for i := range b {
+			bit := uint(i * 6)
+			c := uint(f.Data[bit/8]) >> (bit % 8)
+			if bit%8 > 2 {
+				c |= uint(f.Data[bit/8+1]) << (8 - bit%8)
+			}
+			b[i] = byte(c&0x3F) + 0x20
+		}
+		return string(b)
+
+	case Text:
+		return string(f.Data)
+
+	default:
+		return hex.EncodeToString(f.Data)
+	}
+}
+
+// parseFields parses the fields at the start of data, up to the end of
+// fields marker. The fields and the number of bytes that they take up,
+// excluding the end marker, are returned.
+func parseFields(data []byte) ([]Field, int, error) {
+	var fields []Field
+	off := 0
+	for {
+		if off >= len(data) {
+			return nil, 0, fmt.Errorf("FRU area has no end of fields marker")
+		}
+		if data[off] == endOfFields {
+			return fields, off, nil
+		}
+
+		n := int(data[off] & maxFieldLen)
+		if off+1+n > len(data) {
+			return nil, 0, fmt.Errorf("FRU field of %d bytes overruns its area", n)
+		}
+		fields = append(fields, Field{
+			Type: FieldType(data[off] >> 6),
+			Data: append([]byte(nil), data[off+1:off+1+n]...),
+		})
+		off += 1 + n
+	}
+}
+
+// appendField appends the encoded field f to buf.
+func appendField(buf []byte, f Field) ([]byte, error) {
+	if len(f.Data) > maxFieldLen {
+		return nil, fmt.Errorf("FRU field of %d bytes exceeds %d bytes", len(f.Data), maxFieldLen)
+	}
+
+	tl := byte(f.Type)<<6 | byte(len(f.Data))
+	if tl == endOfFields {
+		return nil, fmt.Errorf("FRU text field can not be a single byte long")
+	}
+	return append(append(buf, tl), f.Data...), nil
+}
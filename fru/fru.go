@@ -0,0 +1,427 @@
+/*
+Package fru parses and serializes IPMI FRU (Field Replaceable Unit)
+information, as defined by the IPMI Platform Management FRU Information
+Storage Definition v1.0.
+
+The common header, internal use, chassis info, board info, product info
+and multirecord areas are supported. The chassis, board and product areas
+are parsed into structs; the internal use and multirecord areas are kept
+as raw bytes. Checksums are verified when parsing and generated when
+serializing.
+
+FRU information is usually stored in a small I2C EEPROM; it can be read
+and programmed with the at24 package, as in:
+
+	eeprom, _ := at24.New(m, 0x50, at24.Part24xx02)
+	data := make([]byte, eeprom.Size())
+	eeprom.ReadAt(data, 0)
+	info, err := fru.Parse(data)
+*/
+package fru
+
+import (
+	"fmt"
+	"time"
+)
+
+// formatVersion is the format version of the common header and of each
+// area.
+const formatVersion = 0x01
+
+// Sizes of the common header and of the multirecord headers, and the unit
+// that area offsets and lengths are given in.
+const (
+	headerSize       = 8
+	recordHeaderSize = 5
+	areaUnit         = 8
+)
+
+// endOfList is set in the format byte of the last multirecord.
+const endOfList = 0x80
+
+// mfgEpoch is the start of the board area's manufacturing date.
+var mfgEpoch = time.Date(1996, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// ChecksumError is returned when the checksum of an area does not match
+// its contents.
+type ChecksumError struct {
+	Area string
+}
+
+func (e *ChecksumError) Error() string {
+	return fmt.Sprintf("FRU %s checksum mismatch", e.Area)
+}
+
+// FRU is the FRU information of a device. Areas that are not present are
+// nil.
+type FRU struct {
+	// InternalUse is the internal use area, including its format version
+	// byte.
+	InternalUse []byte
+
+	Chassis *Chassis
+	Board   *Board
+	Product *Product
+
+	// MultiRecord is the multirecord area, which holds every record up to
+	// and including the one marked as the end of the list.
+	MultiRecord []byte
+}
+
+// Chassis is the chassis info area.
+type Chassis struct {
+	// Type is the SMBIOS chassis type.
+	Type byte
+
+	PartNumber   Field
+	SerialNumber Field
+	Custom       []Field
+}
+
+// Board is the board info area.
+type Board struct {
+	Language byte
+
+	// MfgDate is the manufacturing date, with one minute resolution. The
+	// zero time means unspecified.
+	MfgDate time.Time
+
+	Manufacturer Field
+	ProductName  Field
+	SerialNumber Field
+	PartNumber   Field
+	FileID       Field
+	Custom       []Field
+}
+
+// Product is the product info area.
+type Product struct {
+	Language byte
+
+	Manufacturer Field
+	Name         Field
+	PartNumber   Field
+	Version      Field
+	SerialNumber Field
+	AssetTag     Field
+	FileID       Field
+	Custom       []Field
+}
+
+// Parse parses FRU information from data, which should hold the whole of
+// the FRU storage (or at least everything up to the end of the last area).
+func Parse(data []byte) (*FRU, error) {
+	if len(data) < headerSize {
+		return nil, fmt.Errorf("FRU data of %d bytes is too short for the common header", len(data))
+	}
+	header := data[:headerSize]
+	if header[0]&0x0F != formatVersion {
+		return nil, fmt.Errorf("unsupported FRU format version 0x%02x", header[0])
+	}
+	if checksum(header) != 0 {
+		return nil, &ChecksumError{Area: "common header"}
+	}
+
+	f := &FRU{}
+	offsets := make([]int, 5)
+	for i := range offsets {
+		offsets[i] = int(header[1+i]) * areaUnit
+		if offsets[i] > len(data) {
+			return nil, fmt.Errorf("FRU area offset 0x%x is outside of the data", offsets[i])
+		}
+	}
+
+	if off := offsets[0]; off != 0 {
+		// The internal use area has no length, so it runs up to the next
+		// area.
+		end := len(data)
+		for _, o := range offsets[1:] {
+			if o > off && o < end {
+				end = o
+			}
+		}
+		f.InternalUse = append([]byte(nil), data[off:end]...)
+	}
+
+	if off := offsets[1]; off != 0 {
+		area, err := parseArea(data, off, "chassis info")
+		if err != nil {
+			return nil, err
+		}
+		if f.Chassis, err = parseChassis(area); err != nil {
+			return nil, err
+		}
+	}
+
+	if off := offsets[2]; off != 0 {
+		area, err := parseArea(data, off, "board info")
+		if err != nil {
+			return nil, err
+		}
+		if f.Board, err = parseBoard(area); err != nil {
+			return nil, err
+		}
+	}
+
+	if off := offsets[3]; off != 0 {
+		area, err := parseArea(data, off, "product info")
+		if err != nil {
+			return nil, err
+		}
+		if f.Product, err = parseProduct(area); err != nil {
+			return nil, err
+		}
+	}
+
+	if off := offsets[4]; off != 0 {
+		records, err := parseMultiRecord(data[off:])
+		if err != nil {
+			return nil, err
+		}
+		f.MultiRecord = records
+	}
+
+	return f, nil
+}
+
+// MarshalBinary serializes the FRU information, with the areas in the
+// order of the common header and each area padded to a multiple of 8
+// bytes. It implements encoding.BinaryMarshaler.
+func (f *FRU) MarshalBinary() ([]byte, error) {
+	var areas [5][]byte
+	var err error
+
+	if f.InternalUse != nil {
+		areas[0] = pad(append([]byte(nil), f.InternalUse...), 0)
+	}
+	if f.Chassis != nil {
+		if areas[1], err = f.Chassis.marshal(); err != nil {
+			return nil, err
+		}
+	}
+	if f.Board != nil {
+		if areas[2], err = f.Board.marshal(); err != nil {
+			return nil, err
+		}
+	}
+	if f.Product != nil {
+		if areas[3], err = f.Product.marshal(); err != nil {
+			return nil, err
+		}
+	}
+	if f.MultiRecord != nil {
+		// The multirecord area is the last area, so it needs no padding.
+		areas[4] = f.MultiRecord
+	}
+
+	buf := make([]byte, headerSize)
+	buf[0] = formatVersion
+	for i, area := range areas {
+		if area == nil {
+			continue
+		}
+		if len(buf)/areaUnit > 0xFF {
+			return nil, fmt.Errorf("FRU area offset 0x%x is too large", len(buf))
+		}
+		buf[1+i] = byte(len(buf) / areaUnit)
+		buf = append(buf, area...)
+	}
+	buf[headerSize-1] = -checksum(buf[:headerSize-1])
+
+	return buf, nil
+}
+
+// parseArea returns the chassis, board or product area at off in data,
+// after checking its version, length and checksum.
+func parseArea(data []byte, off int, name string) ([]byte, error) {
+	if off+2 > len(data) {
+		return nil, fmt.Errorf("FRU %s area is outside of the data", name)
+	}
+	if data[off]&0x0F != formatVersion {
+		return nil, fmt.Errorf("unsupported FRU %s area version 0x%02x", name, data[off])
+	}
+
+	n := int(data[off+1]) * areaUnit
+	if n == 0 || off+n > len(data) {
+		return nil, fmt.Errorf("FRU %s area length %d is invalid", name, n)
+	}
+
+	area := data[off : off+n]
+	if checksum(area) != 0 {
+		return nil, &ChecksumError{Area: name + " area"}
+	}
+	return area, nil
+}
+
+// parseChassis parses the chassis info area.
+func parseChassis(area []byte) (*Chassis, error) {
+	fields, err := areaFields(area, 3, 2, "chassis info")
+	if err != nil {
+		return nil, err
+	}
+
+	return &Chassis{
+		Type:         area[2],
+		PartNumber:   fields[0],
+		SerialNumber: fields[1],
+		Custom:       fields[2:],
+	}, nil
+}
+
+// parseBoard parses the board info area.
+func parseBoard(area []byte) (*Board, error) {
+	fields, err := areaFields(area, 6, 5, "board info")
+	if err != nil {
+		return nil, err
+	}
+
+	b := &Board{
+		Language:     area[2],
+		Manufacturer: fields[0],
+		ProductName:  fields[1],
+		SerialNumber: fields[2],
+		PartNumber:   fields[3],
+		FileID:       fields[4],
+		Custom:       fields[5:],
+	}
+	if minutes := int(area[3]) | int(area[4])<<8 | int(area[5])<<16; minutes != 0 {
+		b.MfgDate = mfgEpoch.Add(time.Duration(minutes) * time.Minute)
+	}
+	return b, nil
+}
+
+// parseProduct parses the product info area.
+func parseProduct(area []byte) (*Product, error) {
+	fields, err := areaFields(area, 3, 7, "product info")
+	if err != nil {
+		return nil, err
+	}
+
+	return &Product{
+		Language:     area[2],
+		Manufacturer: fields[0],
+		Name:         fields[1],
+		PartNumber:   fields[2],
+		Version:      fields[3],
+		SerialNumber: fields[4],
+		AssetTag:     fields[5],
+		FileID:       fields[6],
+		Custom:       fields[7:],
+	}, nil
+}
+
+// areaFields parses the fields of an area, which start at off, and checks
+// that at least the required fields are present.
+func areaFields(area []byte, off, required int, name string) ([]Field, error) {
+	if off > len(area) {
+		return nil, fmt.Errorf("FRU %s area is too short", name)
+	}
+
+	fields, _, err := parseFields(area[off:])
+	if err != nil {
+		return nil, err
+	}
+	if len(fields) < required {
+		return nil, fmt.Errorf("FRU %s area has %d fields, expected at least %d", name, len(fields), required)
+	}
+	return fields, nil
+}
+
+// parseMultiRecord returns the records at the start of data, up to and
+// including the one marked as the end of the list, after checking their
+// checksums.
+func parseMultiRecord(data []byte) ([]byte, error) {
+	off := 0
+	for {
+		if off+recordHeaderSize > len(data) {
+			return nil, fmt.Errorf("FRU multirecord area has no end of list record")
+		}
+
+		header := data[off : off+recordHeaderSize]
+		if checksum(header) != 0 {
+			return nil, &ChecksumError{Area: "multirecord header"}
+		}
+
+		n := int(header[2])
+		if off+recordHeaderSize+n > len(data) {
+			return nil, fmt.Errorf("FRU multirecord of %d bytes overruns the data", n)
+		}
+		if checksum(data[off+recordHeaderSize:off+recordHeaderSize+n])+header[3] != 0 {
+			return nil, &ChecksumError{Area: "multirecord"}
+		}
+
+		off += recordHeaderSize + n
+		if header[1]&endOfList != 0 {
+			return append([]byte(nil), data[:off]...), nil
+		}
+	}
+}
+
+// marshal serializes the chassis info area.
+func (c *Chassis) marshal() ([]byte, error) {
+	fields := append([]Field{c.PartNumber, c.SerialNumber}, c.Custom...)
+	return marshalArea([]byte{c.Type}, fields)
+}
+
+// marshal serializes the board info area.
+func (b *Board) marshal() ([]byte, error) {
+	var minutes int64
+	if !b.MfgDate.IsZero() {
+		minutes = int64(b.MfgDate.Sub(mfgEpoch) / time.Minute)
+		if minutes <= 0 || minutes > 0xFFFFFF {
+			return nil, fmt.Errorf("FRU manufacturing date %v is out of range", b.MfgDate)
+		}
+	}
+
+	fixed := []byte{b.Language, byte(minutes), byte(minutes >> 8), byte(minutes >> 16)}
+	fields := append([]Field{b.Manufacturer, b.ProductName, b.SerialNumber, b.PartNumber, b.FileID}, b.Custom...)
+	return marshalArea(fixed, fields)
+}
+
+// marshal serializes the product info area.
+func (p *Product) marshal() ([]byte, error) {
+	fields := append([]Field{p.Manufacturer, p.Name, p.PartNumber, p.Version, p.SerialNumber, p.AssetTag, p.FileID},
+		p.Custom...)
+	return marshalArea([]byte{p.Language}, fields)
+}
+
+// marshalArea serializes an area with the fixed bytes that follow its
+// length, and fields.
+func marshalArea(fixed []byte, fields []Field) ([]byte, error) {
+	buf := append([]byte{formatVersion, 0}, fixed...)
+
+	var err error
+	for _, field := range fields {
+		if buf, err = appendField(buf, field); err != nil {
+			return nil, err
+		}
+	}
+	buf = append(buf, endOfFields)
+
+	// Leave room for the checksum at the end of the padding.
+	buf = pad(buf, 1)
+	if len(buf)/areaUnit > 0xFF {
+		return nil, fmt.Errorf("FRU area of %d bytes is too large", len(buf))
+	}
+	buf[1] = byte(len(buf) / areaUnit)
+	buf[len(buf)-1] = -checksum(buf[:len(buf)-1])
+
+	return buf, nil
+}
+
+// pad pads buf with zeros to a multiple of 8 bytes, leaving at least
+// reserve bytes of padding.
+func pad(buf []byte, reserve int) []byte {
+	n := (len(buf) + reserve + areaUnit - 1) / areaUnit * areaUnit
+	return append(buf, make([]byte, n-len(buf))...)
+}
+
+// checksum returns the sum of data, modulo 256. Areas are checksummed so
+// that the sum of all of their bytes is zero.
+func checksum(data []byte) byte {
+	var sum byte
+	for _, v := range data {
+		sum += v
+	}
+	return sum
+}
@@ -0,0 +1,216 @@
+package fru
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// reference is FRU information with every area, built by hand from the
+// FRU Information Storage Definition.
+var reference = []byte{
+	// Common header.
+	0x01, 0x01, 0x02, 0x05, 0x09, 0x0D, 0x00, 0xE1,
+
+	// Internal use area.
+	0x01, 0xDE, 0xAD, 0x00, 0x00, 0x00, 0x00, 0x00,
+
+	// Chassis info area: rack mount chassis, "CH-100", "C0001".
+	0x01, 0x03, 0x17, 0xC6, 0x43, 0x48, 0x2D, 0x31,
+	0x30, 0x30, 0xC5, 0x43, 0x30, 0x30, 0x30, 0x31,
+	0xC1, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x4C,
+
+	// Board info area: English, made one minute after the epoch, "Acme",
+	// "Widget", "IPMI" in 6-bit ASCII, "12-3. " in BCD plus and an empty
+	// file ID.
+	0x01, 0x04, 0x19, 0x01, 0x00, 0x00, 0xC4, 0x41,
+	0x63, 0x6D, 0x65, 0xC6, 0x57, 0x69, 0x64, 0x67,
+	0x65, 0x74, 0x83, 0x29, 0xDC, 0xA6, 0x43, 0x12,
+	0xB3, 0xCA, 0x00, 0xC1, 0x00, 0x00, 0x00, 0xBC,
+
+	// Product info area: English, "Acme", "Widget", "W-100", "1.0" and
+	// empty serial number, asset tag and file ID.
+	0x01, 0x04, 0x19, 0xC4, 0x41, 0x63, 0x6D, 0x65,
+	0xC6, 0x57, 0x69, 0x64, 0x67, 0x65, 0x74, 0xC5,
+	0x57, 0x2D, 0x31, 0x30, 0x30, 0xC3, 0x31, 0x2E,
+	0x30, 0xC0, 0xC0, 0x00, 0xC1, 0x00, 0x00, 0x11,
+
+	// Multirecord area: a single OEM record of 2 bytes.
+	0xC0, 0x82, 0x02, 0xFD, 0xBF, 0x01, 0x02,
+}
+
+// referenceFRU is the contents of reference, as Parse returns it.
+func referenceFRU(t *testing.T) *FRU {
+	serial, err := SixBitASCIIField("IPMI")
+	if err != nil {
+		t.Fatal(err)
+	}
+	part, err := BCDPlusField("12-3.")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &FRU{
+		InternalUse: []byte{0x01, 0xDE, 0xAD, 0x00, 0x00, 0x00, 0x00, 0x00},
+		Chassis: &Chassis{
+			Type:         0x17,
+			PartNumber:   TextField("CH-100"),
+			SerialNumber: TextField("C0001"),
+			Custom:       []Field{},
+		},
+		Board: &Board{
+			Language:     0x19,
+			MfgDate:      mfgEpoch.Add(time.Minute),
+			Manufacturer: TextField("Acme"),
+			ProductName:  TextField("Widget"),
+			SerialNumber: serial,
+			PartNumber:   part,
+			FileID:       Field{Type: Binary},
+			Custom:       []Field{},
+		},
+		Product: &Product{
+			Language:     0x19,
+			Manufacturer: TextField("Acme"),
+			Name:         TextField("Widget"),
+			PartNumber:   TextField("W-100"),
+			Version:      TextField("1.0"),
+			SerialNumber: Field{Type: Text},
+			AssetTag:     Field{Type: Text},
+			FileID:       Field{Type: Binary},
+			Custom:       []Field{},
+		},
+		MultiRecord: []byte{0xC0, 0x82, 0x02, 0xFD, 0xBF, 0x01, 0x02},
+	}
+}
+
+func TestParse(t *testing.T) {
+	f, err := Parse(reference)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := referenceFRU(t); !reflect.DeepEqual(f, want) {
+		t.Errorf("got %+v, want %+v", f, want)
+	}
+
+	if s := f.Board.SerialNumber.String(); s != "IPMI" {
+		t.Errorf("board serial number: got %q, want %q", s, "IPMI")
+	}
+	if s := f.Board.PartNumber.String(); s != "12-3. " {
+		t.Errorf("board part number: got %q, want %q", s, "12-3. ")
+	}
+}
+
+func TestMarshalBinary(t *testing.T) {
+	data, err := referenceFRU(t).MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, reference) {
+		t.Errorf("got % X, want % X", data, reference)
+	}
+
+	// Areas that are not present are left out of the header.
+	f := &FRU{Product: referenceFRU(t).Product}
+	data, err = f.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := Parse(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, f) {
+		t.Errorf("round trip: got %+v, want %+v", got, f)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		offset int
+		value  byte
+		area   string
+	}{
+		{"header checksum", 7, 0xE2, "common header"},
+		{"chassis checksum", 39, 0x4D, "chassis info area"},
+		{"board checksum", 71, 0xBD, "board info area"},
+		{"board data", 48, 0x62, "board info area"},
+		{"product checksum", 103, 0x12, "product info area"},
+		{"record header checksum", 108, 0xC0, "multirecord header"},
+		{"record checksum", 110, 0x03, "multirecord"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := append([]byte(nil), reference...)
+			data[tt.offset] = tt.value
+
+			_, err := Parse(data)
+			ce, ok := err.(*ChecksumError)
+			if !ok {
+				t.Fatalf("got error %v, want *ChecksumError", err)
+			}
+			if ce.Area != tt.area {
+				t.Errorf("got area %q, want %q", ce.Area, tt.area)
+			}
+		})
+	}
+
+	for _, data := range [][]byte{
+		nil,
+		reference[:7],
+		append([]byte{0x02}, reference[1:]...), // header version
+		reference[:64],                         // board area truncated
+		reference[:len(reference)-1],           // record truncated
+	} {
+		if _, err := Parse(data); err == nil {
+			t.Errorf("% X: got no error", data)
+		}
+	}
+}
+
+func TestFieldEncoding(t *testing.T) {
+	tests := []struct {
+		name   string
+		encode func(string) (Field, error)
+		s      string
+		data   []byte
+		decode string
+	}{
+		// The 6-bit ASCII example from the IPMI specification.
+		{"6-bit", SixBitASCIIField, "IPMI", []byte{0x29, 0xDC, 0xA6}, "IPMI"},
+		{"6-bit padded", SixBitASCIIField, "AB", []byte{0xA1, 0x08}, "AB"},
+		{"6-bit range", SixBitASCIIField, " _", []byte{0xC0, 0x0F}, " _"},
+		{"BCD plus", BCDPlusField, "0123456789 -.", []byte{0x01, 0x23, 0x45, 0x67, 0x89, 0xAB, 0xCA}, "0123456789 -. "},
+		{"BCD plus empty", BCDPlusField, "", []byte{}, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := tt.encode(tt.s)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(f.Data, tt.data) {
+				t.Errorf("got % X, want % X", f.Data, tt.data)
+			}
+			if s := f.String(); s != tt.decode {
+				t.Errorf("decoded %q, want %q", s, tt.decode)
+			}
+		})
+	}
+
+	if _, err := SixBitASCIIField("ipmi"); err == nil {
+		t.Error("6-bit ASCII lower case: got no error")
+	}
+	if _, err := BCDPlusField("12A"); err == nil {
+		t.Error("BCD plus letter: got no error")
+	}
+	if _, err := appendField(nil, TextField("x")); err == nil {
+		t.Error("single byte text field: got no error")
+	}
+	if _, err := appendField(nil, BinaryField(make([]byte, maxFieldLen+1))); err == nil {
+		t.Error("long field: got no error")
+	}
+}
@@ -0,0 +1,130 @@
+package libmpsse
+
+// ProbeMode selects how ScanI2C checks whether a device is present at an
+// address, following the probe modes of i2cdetect.
+type ProbeMode int
+
+// Supported probe modes.
+const (
+	// ProbeAuto uses a read probe for the address ranges where write-only
+	// probes are known to corrupt EEPROMs (0x30-0x37 and 0x50-0x5F) and a
+	// quick write probe everywhere else.
+	ProbeAuto ProbeMode = iota
+
+	// ProbeQuickWrite sends the address with the write bit set and no
+	// data (an SMBus quick write).
+	ProbeQuickWrite
+
+	// ProbeRead sends the address with the read bit set and reads one
+	// byte, which is NACKed.
+	ProbeRead
+)
+
+// Limits of the 7-bit address space that is not reserved by the I2C
+// specification.
+const (
+	i2cFirstAddress = 0x08
+	i2cLastAddress  = 0x77
+	i2cMaxAddress   = 0x7F
+	i2cMaxAddress10 = 0x3FF
+
+	// The first byte of a 10-bit address is 11110 followed by the two most
+	// significant address bits and the read/write bit.
+	i2cTenBitPrefix = 0xF0
+)
+
+// ScanOptions configures ScanI2C.
+type ScanOptions struct {
+	// Probe selects how each address is probed.
+	Probe ProbeMode
+
+	// IncludeReserved includes the reserved addresses 0x00-0x07 and
+	// 0x78-0x7F in the scan.
+	IncludeReserved bool
+
+	// TenBit additionally scans the 10-bit address space.
+	TenBit bool
+}
+
+// I2CAddrTenBit is set in the addresses returned by ScanI2C for devices
+// that ACKed a 10-bit address, so that a 10-bit device at 0x050 is told
+// apart from a 7-bit device at 0x50. It matches I2C_ADDR_OFFSET_TEN_BIT in
+// the Linux I2C subsystem.
+const I2CAddrTenBit = 0xA000
+
+// ScanI2C probes the I2C bus and returns the addresses that were ACKed, in
+// ascending order. 7-bit addresses are returned first, followed by any
+// 10-bit addresses, which have I2CAddrTenBit set. If opts is nil, a
+// ProbeAuto scan of the non-reserved 7-bit addresses is done.
+//
+// For use in I2C mode only.
+func (m *Mpsse) ScanI2C(opts *ScanOptions) ([]uint16, error) {
+	return scanI2C(m, opts)
+}
+
+// scanI2C is ScanI2C on any I2C bus.
+func scanI2C(bus I2CBus, opts *ScanOptions) ([]uint16, error) {
+	if opts == nil {
+		opts = &ScanOptions{}
+	}
+
+	first, last := uint16(i2cFirstAddress), uint16(i2cLastAddress)
+	if opts.IncludeReserved {
+		first, last = 0, i2cMaxAddress
+	}
+
+	var found []uint16
+	for addr := first; addr <= last; addr++ {
+		mode := opts.Probe
+		if mode == ProbeAuto {
+			mode = ProbeQuickWrite
+			if (addr >= 0x30 && addr <= 0x37) || (addr >= 0x50 && addr <= 0x5F) {
+				mode = ProbeRead
+			}
+		}
+
+		present, err := probe(bus, addr, 0, mode)
+		if err != nil {
+			return nil, err
+		}
+		if present {
+			found = append(found, addr)
+		}
+	}
+
+	if opts.TenBit {
+		for addr := uint16(0); addr <= i2cMaxAddress10; addr++ {
+			// A 10-bit read has to be preceded by a write of the full
+			// address, so only quick writes are used here.
+			present, err := probe(bus, addr, I2CMsgTen, ProbeQuickWrite)
+			if err != nil {
+				return nil, err
+			}
+			if present {
+				found = append(found, addr|I2CAddrTenBit)
+			}
+		}
+	}
+
+	return found, nil
+}
+
+// probe addresses the device at addr in a transaction of its own and
+// checks that it is ACKed. A read probe reads a single byte, which is
+// NACKed.
+func probe(bus I2CBus, addr uint16, flags I2CFlag, mode ProbeMode) (bool, error) {
+	msg := I2CMsg{Addr: addr, Flags: flags}
+	if mode == ProbeRead {
+		msg.Flags |= I2CMsgRead
+		msg.Buf = make([]byte, 1)
+	}
+
+	err := bus.I2CTransfer([]I2CMsg{msg})
+	if _, nack := err.(*I2CNackError); nack {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
@@ -0,0 +1,65 @@
+package libmpsse_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/vapor-ware/libmpsse"
+	"github.com/vapor-ware/libmpsse/i2csim"
+)
+
+// probeTarget records how each of its addresses was probed.
+type probeTarget struct {
+	reads map[uint16]bool
+}
+
+func (p *probeTarget) Start(addr uint16, read bool) bool {
+	p.reads[addr] = read
+	return true
+}
+
+func (p *probeTarget) Write(v byte) bool { return true }
+func (p *probeTarget) Read() byte        { return 0 }
+func (p *probeTarget) Stop()             {}
+
+func TestScanI2C(t *testing.T) {
+	bus := i2csim.NewBus()
+	target := &probeTarget{reads: make(map[uint16]bool)}
+	for _, addr := range []uint16{0x03, 0x20, 0x30, 0x37, 0x38, 0x50, 0x5F, 0x60, 0x78} {
+		bus.Attach(addr, target)
+	}
+	bus.AttachTenBit(0x050, target)
+	bus.AttachTenBit(0x3FF, target)
+
+	// The reserved addresses are skipped, and ProbeAuto reads from the
+	// EEPROM ranges and quick writes everywhere else.
+	found, err := libmpsse.ScanI2CBus(bus, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []uint16{0x20, 0x30, 0x37, 0x38, 0x50, 0x5F, 0x60}; !reflect.DeepEqual(found, want) {
+		t.Errorf("got addresses %#x, want %#x", found, want)
+	}
+	wantReads := map[uint16]bool{0x20: false, 0x30: true, 0x37: true, 0x38: false, 0x50: true, 0x5F: true, 0x60: false}
+	if !reflect.DeepEqual(target.reads, wantReads) {
+		t.Errorf("got read probes %v, want %v", target.reads, wantReads)
+	}
+
+	target.reads = make(map[uint16]bool)
+	found, err = libmpsse.ScanI2CBus(bus, &libmpsse.ScanOptions{Probe: libmpsse.ProbeRead, IncludeReserved: true, TenBit: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []uint16{
+		0x03, 0x20, 0x30, 0x37, 0x38, 0x50, 0x5F, 0x60, 0x78,
+		0x050 | libmpsse.I2CAddrTenBit, 0x3FF | libmpsse.I2CAddrTenBit,
+	}
+	if !reflect.DeepEqual(found, want) {
+		t.Errorf("got addresses %#x, want %#x", found, want)
+	}
+
+	// 10-bit addresses are always probed with quick writes.
+	if target.reads[0x20] != true || target.reads[0x3FF] != false {
+		t.Errorf("got read probes %v", target.reads)
+	}
+}
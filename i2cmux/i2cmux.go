@@ -0,0 +1,165 @@
+/*
+Package i2cmux is a driver for PCA954x and TCA954x I2C multiplexers and
+switches attached to an FTDI chip through libmpsse.
+
+Each downstream channel of a mux is exposed as an I2C bus of its own,
+which can be used with anything that takes a libmpsse.I2CBus. The channel
+is selected automatically before each transaction on it. The selected
+channel is cached, so the mux is only written to when a transaction is on
+a different channel than the last one, and transactions on the channels of
+a mux are serialized so that concurrent users can not switch the channel
+out from under each other.
+
+The Mpsse must be opened in I2C mode. Transactions that are made directly
+on the upstream bus, rather than through a channel, are not serialized
+with those on the mux's channels.
+*/
+package i2cmux
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/vapor-ware/libmpsse"
+)
+
+// Model describes a mux chip.
+type Model struct {
+	// Channels is the number of downstream channels.
+	Channels int
+
+	// Enable is the channel enable bit of muxes that select a single
+	// channel by number (such as the PCA9544), or 0 for switches that are
+	// written a bitmask of the channels to enable (such as the PCA9548).
+	Enable byte
+}
+
+// Models of common multiplexers and switches.
+var (
+	PCA9548  = Model{Channels: 8}
+	TCA9548A = Model{Channels: 8}
+	PCA9546  = Model{Channels: 4}
+	PCA9545  = Model{Channels: 4}
+	PCA9543  = Model{Channels: 2}
+	PCA9544  = Model{Channels: 4, Enable: 0x04}
+	PCA9542  = Model{Channels: 2, Enable: 0x04}
+)
+
+// noChannel is the cached channel when none is selected, or the selection
+// is not known.
+const noChannel = -1
+
+// Mux is an I2C multiplexer.
+type Mux struct {
+	bus   libmpsse.I2CBus
+	addr  uint16
+	model Model
+
+	lock     sync.Mutex
+	selected int
+}
+
+// New returns the mux at the 7-bit address addr on bus.
+func New(bus libmpsse.I2CBus, addr uint16, model Model) (*Mux, error) {
+	if model.Channels <= 0 {
+		return nil, fmt.Errorf("invalid I2C mux channel count %d", model.Channels)
+	}
+
+	return &Mux{
+		bus:      bus,
+		addr:     addr,
+		model:    model,
+		selected: noChannel,
+	}, nil
+}
+
+// Channels returns the number of downstream channels.
+func (x *Mux) Channels() int {
+	return x.model.Channels
+}
+
+// Channel returns the I2C bus for downstream channel n, numbered from 0.
+func (x *Mux) Channel(n int) (*Channel, error) {
+	if n < 0 || n >= x.model.Channels {
+		return nil, fmt.Errorf("I2C mux channel %d out of range [0, %d)", n, x.model.Channels)
+	}
+	return &Channel{mux: x, n: n}, nil
+}
+
+// Deselect disconnects all of the downstream channels.
+func (x *Mux) Deselect() error {
+	x.lock.Lock()
+	defer x.lock.Unlock()
+
+	return x.write(0, noChannel)
+}
+
+// Invalidate forgets the cached channel selection, so that the channel is
+// written to the mux before the next transaction. It should be called if
+// the mux may have been reset or written to by something else.
+func (x *Mux) Invalidate() {
+	x.lock.Lock()
+	defer x.lock.Unlock()
+
+	x.selected = noChannel
+}
+
+// transfer performs a transaction on channel n. The caller must hold the
+// lock.
+func (x *Mux) transfer(n int, msgs []libmpsse.I2CMsg) error {
+	if x.selected != n {
+		var control byte
+		if x.model.Enable != 0 {
+			control = x.model.Enable | byte(n)
+		} else {
+			control = 1 << uint(n)
+		}
+		if err := x.write(control, n); err != nil {
+			return err
+		}
+	}
+
+	err := x.bus.I2CTransfer(msgs)
+	if err != nil {
+		// A failed transaction may have been caused by (or caused) a
+		// reset of the mux, so the selection is written again next time.
+		x.selected = noChannel
+	}
+	return err
+}
+
+// write writes the mux's control register and records the channel that
+// it selects. The caller must hold the lock.
+func (x *Mux) write(control byte, n int) error {
+	err := x.bus.I2CTransfer([]libmpsse.I2CMsg{
+		{Addr: x.addr, Buf: []byte{control}},
+	})
+	if err != nil {
+		x.selected = noChannel
+		return err
+	}
+
+	x.selected = n
+	return nil
+}
+
+// Channel is a downstream channel of a Mux. It implements libmpsse.I2CBus.
+type Channel struct {
+	mux *Mux
+	n   int
+}
+
+// Index returns the number of the channel on its mux.
+func (c *Channel) Index() int {
+	return c.n
+}
+
+// I2CTransfer selects the channel, if it is not already selected, and
+// performs a transaction on it. The mux is locked for the duration of the
+// transaction.
+func (c *Channel) I2CTransfer(msgs []libmpsse.I2CMsg) error {
+	c.mux.lock.Lock()
+	defer c.mux.lock.Unlock()
+
+	return c.mux.transfer(c.n, msgs)
+}
@@ -0,0 +1,183 @@
+package i2cmux
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/vapor-ware/libmpsse"
+	"github.com/vapor-ware/libmpsse/i2csim"
+)
+
+// muxTarget simulates the control register of a mux.
+type muxTarget struct {
+	control byte
+	writes  int
+}
+
+func (m *muxTarget) Start(addr uint16, read bool) bool { return true }
+func (m *muxTarget) Read() byte                        { return m.control }
+func (m *muxTarget) Stop()                             {}
+
+func (m *muxTarget) Write(v byte) bool {
+	m.control = v
+	m.writes++
+	return true
+}
+
+// downstream is a target behind a mux, which is only reachable while the
+// mux's control register has one of the bits of enable set, and equals
+// enable in its low bits if single is set.
+type downstream struct {
+	i2csim.Target
+	mux    *muxTarget
+	enable byte
+	single bool
+}
+
+func (d *downstream) Start(addr uint16, read bool) bool {
+	if d.single && d.mux.control&0x07 != d.enable {
+		return false
+	}
+	if !d.single && d.mux.control&d.enable == 0 {
+		return false
+	}
+	return d.Target.Start(addr, read)
+}
+
+// newMux returns a switch at 0x70 with a register device at 0x48 on
+// channel 2.
+func newMux(t *testing.T) (*Mux, *i2csim.Bus, *muxTarget, *i2csim.Registers) {
+	bus := i2csim.NewBus()
+	mt := &muxTarget{}
+	regs := &i2csim.Registers{}
+	bus.Attach(0x70, mt)
+	bus.Attach(0x48, &downstream{Target: regs, mux: mt, enable: 1 << 2})
+
+	x, err := New(bus, 0x70, PCA9548)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return x, bus, mt, regs
+}
+
+func TestChannel(t *testing.T) {
+	x, _, mt, regs := newMux(t)
+
+	ch2, err := x.Channel(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := libmpsse.NewI2CRegs(ch2)
+	if err := r.WriteReg8(0x48, 0x01, 0xAB); err != nil {
+		t.Fatal(err)
+	}
+	if regs.Regs[1] != 0xAB {
+		t.Errorf("got register 0x%02X, want 0xAB", regs.Regs[1])
+	}
+	if mt.control != 0x04 {
+		t.Errorf("got control 0x%02X, want 0x04", mt.control)
+	}
+
+	// The selection is cached.
+	if v, err := r.ReadReg8(0x48, 0x01); err != nil || v != 0xAB {
+		t.Errorf("ReadReg8: got 0x%02X, %v, want 0xAB", v, err)
+	}
+	if mt.writes != 1 {
+		t.Errorf("mux written %d times, want 1", mt.writes)
+	}
+
+	// The device is not on channel 3.
+	ch3, _ := x.Channel(3)
+	_, err = libmpsse.NewI2CRegs(ch3).ReadReg8(0x48, 0x01)
+	want := &libmpsse.I2CNackError{Addr: 0x48, Msg: 0, Byte: 0}
+	if !reflect.DeepEqual(err, want) {
+		t.Errorf("channel 3: got error %v, want %v", err, want)
+	}
+	if mt.control != 0x08 {
+		t.Errorf("got control 0x%02X, want 0x08", mt.control)
+	}
+
+	if err := x.Deselect(); err != nil {
+		t.Fatal(err)
+	}
+	if mt.control != 0 {
+		t.Errorf("deselected: got control 0x%02X, want 0", mt.control)
+	}
+
+	if _, err := x.Channel(8); err == nil {
+		t.Error("channel 8: got no error")
+	}
+	if _, err := New(i2csim.NewBus(), 0x70, Model{}); err == nil {
+		t.Error("no channels: got no error")
+	}
+}
+
+func TestSingleChannelMux(t *testing.T) {
+	bus := i2csim.NewBus()
+	mt := &muxTarget{}
+	regs := &i2csim.Registers{}
+	bus.Attach(0x70, mt)
+	bus.Attach(0x48, &downstream{Target: regs, mux: mt, enable: 0x04 | 1, single: true})
+
+	x, err := New(bus, 0x70, PCA9544)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ch1, _ := x.Channel(1)
+	if err := libmpsse.NewI2CRegs(ch1).WriteReg8(0x48, 0x00, 0x01); err != nil {
+		t.Fatal(err)
+	}
+	if mt.control != 0x05 {
+		t.Errorf("got control 0x%02X, want 0x05", mt.control)
+	}
+}
+
+func TestReselect(t *testing.T) {
+	x, bus, mt, _ := newMux(t)
+	ch2, _ := x.Channel(2)
+	r := libmpsse.NewI2CRegs(ch2)
+
+	if err := r.WriteReg8(0x48, 0x00, 0x01); err != nil {
+		t.Fatal(err)
+	}
+
+	// After Invalidate, the selection is written again.
+	x.Invalidate()
+	if err := r.WriteReg8(0x48, 0x00, 0x01); err != nil {
+		t.Fatal(err)
+	}
+	if mt.writes != 2 {
+		t.Errorf("mux written %d times, want 2", mt.writes)
+	}
+
+	// A failed transaction, for instance because the mux was reset,
+	// invalidates the selection.
+	mt.control = 0
+	if err := r.WriteReg8(0x48, 0x00, 0x01); err == nil {
+		t.Fatal("mux reset: got no error")
+	}
+	if err := r.WriteReg8(0x48, 0x00, 0x01); err != nil {
+		t.Errorf("after a failure: got error %v", err)
+	}
+	if mt.control != 0x04 {
+		t.Errorf("got control 0x%02X, want 0x04", mt.control)
+	}
+
+	// A failure to write the selection is returned, and the transaction
+	// is not attempted.
+	x.Invalidate()
+	failed := errors.New("USB transfer failed")
+	bus.FailNext(failed)
+	if err := r.WriteReg8(0x48, 0x00, 0x02); err != failed {
+		t.Errorf("got error %v, want %v", err, failed)
+	}
+
+	bus.Detach(0x70)
+	x.Invalidate()
+	err := r.WriteReg8(0x48, 0x00, 0x02)
+	want := &libmpsse.I2CNackError{Addr: 0x70, Msg: 0, Byte: 0}
+	if !reflect.DeepEqual(err, want) {
+		t.Errorf("missing mux: got error %v, want %v", err, want)
+	}
+}
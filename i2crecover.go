@@ -0,0 +1,110 @@
+package libmpsse
+
+import (
+	"errors"
+	"fmt"
+)
+
+// i2cRecoveryPulses is the number of clock pulses that are sent to free a
+// stuck bus. A slave that is holding the data line low is part way through
+// sending a byte, so at most eight data bits and the ACK bit remain.
+const i2cRecoveryPulses = 9
+
+// ErrI2CBusStuck is returned by RecoverI2CBus if the data line is still
+// held low after the clock has been pulsed and a stop condition sent.
+var ErrI2CBusStuck = errors.New("I2C data line is still held low after bus recovery")
+
+// RecoverI2CBus frees an I2C bus that a slave is holding the data line low
+// on, as happens when the slave is reset (or the master aborts) in the
+// middle of a transaction.
+//
+// If the data line is stuck low, the clock is pulsed at the configured I2C
+// clock rate, up to 9 times, until the slave releases it. A stop condition
+// is then sent to return the slave to its idle state, and true is returned
+// if the data line is then high. If the data line is still low,
+// ErrI2CBusStuck is returned.
+//
+// If the data line was not stuck to begin with, the bus is left idle and
+// false is returned with a nil error: nothing needed recovering. A failed
+// recovery is always reported by an error.
+//
+// For use in I2C mode only.
+func (m *Mpsse) RecoverI2CBus() (bool, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	state := m.state()
+	if state.mode != I2C {
+		return false, fmt.Errorf("I2C bus recovery requires I2C mode")
+	}
+
+	// The pins are returned to their idle states whether or not the bus
+	// was stuck, which ends any transaction that was started.
+	recovered, err := recoverI2CBus(m, state)
+	m.setStarted(false)
+	return recovered, err
+}
+
+// recoverI2CBus sends the commands for RecoverI2CBus to port.
+func recoverI2CBus(port rawPort, state mpsseState) (bool, error) {
+	// Stop driving the data line so that it can be read, leaving the clock
+	// at its idle level.
+	tris := state.tris &^ pinDO
+	if err := port.rawWrite([]byte{cmdSetBitsLow, state.pidle, tris}); err != nil {
+		return false, err
+	}
+
+	free, err := readSDA(port)
+	if err != nil {
+		return false, err
+	}
+	if free {
+		return false, port.rawWrite([]byte{cmdSetBitsLow, state.pidle, state.tris})
+	}
+
+	// Pulse the clock, starting from low, until the data line is released.
+	if err := port.rawWrite([]byte{cmdSetBitsLow, state.pidle &^ pinSK, tris}); err != nil {
+		return false, err
+	}
+	for i := 0; i < i2cRecoveryPulses && !free; i++ {
+		if err := port.rawWrite([]byte{cmdClockNCycles, 0}); err != nil {
+			return false, err
+		}
+		if free, err = readSDA(port); err != nil {
+			return false, err
+		}
+	}
+
+	b := &i2cBuilder{
+		port:  port,
+		state: state,
+	}
+	b.stop()
+	if err := b.flush(); err != nil {
+		return false, err
+	}
+
+	// Check that the data line was left high after the stop condition.
+	free, err = readSDA(port)
+	if err != nil {
+		return false, err
+	}
+	if !free {
+		return false, ErrI2CBusStuck
+	}
+	return true, nil
+}
+
+// readSDA reads the level of the I2C data line. If the port is an Mpsse,
+// the caller must hold its lock.
+func readSDA(port rawPort) (bool, error) {
+	if err := port.rawWrite([]byte{cmdGetBitsLow, cmdSendImmediate}); err != nil {
+		return false, err
+	}
+
+	data := make([]byte, 1)
+	if err := port.rawRead(data); err != nil {
+		return false, err
+	}
+	return data[0]&pinDI != 0, nil
+}
@@ -0,0 +1,70 @@
+package libmpsse
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestRecoverI2CBus(t *testing.T) {
+	var (
+		release = []byte{0x80, 0x0F, 0xF9}
+		idle    = []byte{0x80, 0x0F, 0xFB}
+		clockLo = []byte{0x80, 0x0E, 0xF9}
+		readSDA = []byte{0x81, 0x87}
+		pulse   = []byte{0x8E, 0x00}
+		stop    = cmds(cStop, []byte{cmdSendImmediate})
+		low     = byte(0x00)
+		high    = byte(pinDI)
+	)
+
+	// The commands when the data line is never released.
+	stuck := [][]byte{release, readSDA, clockLo}
+	for i := 0; i < i2cRecoveryPulses; i++ {
+		stuck = append(stuck, pulse, readSDA)
+	}
+	stuck = append(stuck, stop, readSDA)
+
+	tests := []struct {
+		name      string
+		sda       []byte
+		recovered bool
+		err       error
+		writes    [][]byte
+	}{
+		{
+			"not stuck",
+			[]byte{high},
+			false, nil,
+			[][]byte{release, readSDA, idle},
+		},
+		{
+			"released after 2 pulses",
+			[]byte{low, low, high, high},
+			true, nil,
+			[][]byte{release, readSDA, clockLo, pulse, readSDA, pulse, readSDA, stop, readSDA},
+		},
+		{
+			"stuck after the stop",
+			[]byte{low, high, low},
+			false, ErrI2CBusStuck,
+			[][]byte{release, readSDA, clockLo, pulse, readSDA, stop, readSDA},
+		},
+		{
+			"never released",
+			make([]byte, 1+i2cRecoveryPulses+1),
+			false, ErrI2CBusStuck,
+			stuck,
+		},
+	}
+
+	for _, tt := range tests {
+		port := &fakeRawPort{in: tt.sda}
+		recovered, err := recoverI2CBus(port, i2cState)
+		if recovered != tt.recovered || err != tt.err {
+			t.Errorf("%s: got %v, %v, want %v, %v", tt.name, recovered, err, tt.recovered, tt.err)
+		}
+		if !reflect.DeepEqual(port.writes, tt.writes) {
+			t.Errorf("%s: got commands % X\nwant % X", tt.name, port.writes, tt.writes)
+		}
+	}
+}
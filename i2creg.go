@@ -0,0 +1,134 @@
+package libmpsse
+
+import (
+	"fmt"
+)
+
+// I2CRegs accesses the registers of devices on an I2C bus that use a
+// register pointer: the first byte written to the device selects a
+// register, and following bytes are written to or read from consecutive
+// registers. All of the methods take the 7-bit address of the device.
+//
+// The register methods of Mpsse are shorthand for an I2CRegs on the
+// Mpsse; an I2CRegs can also be used with other buses, such as the
+// channels of an I2C mux.
+type I2CRegs struct {
+	bus I2CBus
+}
+
+// NewI2CRegs returns an I2CRegs that carries its transactions over bus.
+func NewI2CRegs(bus I2CBus) *I2CRegs {
+	return &I2CRegs{bus: bus}
+}
+
+// ReadReg8 reads an 8-bit register from the device at addr.
+func (r *I2CRegs) ReadReg8(addr uint16, reg byte) (byte, error) {
+	data, err := r.ReadRegs(addr, reg, 1)
+	if err != nil {
+		return 0, err
+	}
+	return data[0], nil
+}
+
+// ReadReg16 reads a 16-bit register, sent most significant byte first,
+// from the device at addr.
+func (r *I2CRegs) ReadReg16(addr uint16, reg byte) (uint16, error) {
+	data, err := r.ReadRegs(addr, reg, 2)
+	if err != nil {
+		return 0, err
+	}
+	return uint16(data[0])<<8 | uint16(data[1]), nil
+}
+
+// WriteReg8 writes an 8-bit register on the device at addr.
+func (r *I2CRegs) WriteReg8(addr uint16, reg byte, value byte) error {
+	return r.WriteRegs(addr, reg, []byte{value})
+}
+
+// WriteReg16 writes a 16-bit register, most significant byte first, on the
+// device at addr.
+func (r *I2CRegs) WriteReg16(addr uint16, reg byte, value uint16) error {
+	return r.WriteRegs(addr, reg, []byte{byte(value >> 8), byte(value)})
+}
+
+// ReadRegs reads n consecutive bytes starting at register reg from the
+// device at addr. The register is written, followed by a repeated start
+// and the read. Every byte read is ACKed except the last, which is NACKed
+// to end the read.
+//
+// If the device does not acknowledge a byte, an *I2CNackError is returned
+// identifying it; message 0 is the register write and message 1 the read.
+func (r *I2CRegs) ReadRegs(addr uint16, reg byte, n int) ([]byte, error) {
+	if n <= 0 {
+		return nil, fmt.Errorf("invalid register read length %d", n)
+	}
+
+	data := make([]byte, n)
+	err := r.bus.I2CTransfer([]I2CMsg{
+		{Addr: addr, Buf: []byte{reg}},
+		{Addr: addr, Flags: I2CMsgRead, Buf: data},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// WriteRegs writes data to consecutive registers starting at reg on the
+// device at addr.
+//
+// If the device does not acknowledge a byte, an *I2CNackError is returned
+// identifying it; byte 1 is the register and data starts at byte 2.
+func (r *I2CRegs) WriteRegs(addr uint16, reg byte, data []byte) error {
+	return r.bus.I2CTransfer([]I2CMsg{
+		{Addr: addr, Buf: append([]byte{reg}, data...)},
+	})
+}
+
+// ReadReg8 reads an 8-bit register from the I2C device at the 7-bit
+// address addr.
+//
+// For use in I2C mode only.
+func (m *Mpsse) ReadReg8(addr uint16, reg byte) (byte, error) {
+	return NewI2CRegs(m).ReadReg8(addr, reg)
+}
+
+// ReadReg16 reads a 16-bit register, sent most significant byte first,
+// from the I2C device at the 7-bit address addr.
+//
+// For use in I2C mode only.
+func (m *Mpsse) ReadReg16(addr uint16, reg byte) (uint16, error) {
+	return NewI2CRegs(m).ReadReg16(addr, reg)
+}
+
+// WriteReg8 writes an 8-bit register on the I2C device at the 7-bit
+// address addr.
+//
+// For use in I2C mode only.
+func (m *Mpsse) WriteReg8(addr uint16, reg byte, value byte) error {
+	return NewI2CRegs(m).WriteReg8(addr, reg, value)
+}
+
+// WriteReg16 writes a 16-bit register, most significant byte first, on the
+// I2C device at the 7-bit address addr.
+//
+// For use in I2C mode only.
+func (m *Mpsse) WriteReg16(addr uint16, reg byte, value uint16) error {
+	return NewI2CRegs(m).WriteReg16(addr, reg, value)
+}
+
+// ReadRegs reads n consecutive bytes starting at register reg from the I2C
+// device at the 7-bit address addr, as I2CRegs.ReadRegs does.
+//
+// For use in I2C mode only.
+func (m *Mpsse) ReadRegs(addr uint16, reg byte, n int) ([]byte, error) {
+	return NewI2CRegs(m).ReadRegs(addr, reg, n)
+}
+
+// WriteRegs writes data to consecutive registers starting at reg on the
+// I2C device at the 7-bit address addr, as I2CRegs.WriteRegs does.
+//
+// For use in I2C mode only.
+func (m *Mpsse) WriteRegs(addr uint16, reg byte, data []byte) error {
+	return NewI2CRegs(m).WriteRegs(addr, reg, data)
+}
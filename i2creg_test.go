@@ -0,0 +1,93 @@
+package libmpsse_test
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/vapor-ware/libmpsse"
+	"github.com/vapor-ware/libmpsse/i2csim"
+)
+
+func TestI2CRegs(t *testing.T) {
+	bus := i2csim.NewBus()
+	dev := &i2csim.Registers{}
+	bus.Attach(0x48, dev)
+	regs := libmpsse.NewI2CRegs(bus)
+
+	if err := regs.WriteReg16(0x48, 0x02, 0x1234); err != nil {
+		t.Fatal(err)
+	}
+	if got := dev.Regs[0x02:0x04]; !bytes.Equal(got, []byte{0x12, 0x34}) {
+		t.Errorf("WriteReg16 wrote % X, want 12 34", got)
+	}
+	if v, err := regs.ReadReg16(0x48, 0x02); err != nil || v != 0x1234 {
+		t.Errorf("ReadReg16: got 0x%04X, %v, want 0x1234", v, err)
+	}
+
+	if err := regs.WriteReg8(0x48, 0x10, 0xAB); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := regs.ReadReg8(0x48, 0x10); err != nil || v != 0xAB {
+		t.Errorf("ReadReg8: got 0x%02X, %v, want 0xAB", v, err)
+	}
+
+	if err := regs.WriteRegs(0x48, 0x20, []byte{1, 2, 3}); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := regs.ReadRegs(0x48, 0x20, 3); err != nil || !bytes.Equal(data, []byte{1, 2, 3}) {
+		t.Errorf("ReadRegs: got % X, %v, want 01 02 03", data, err)
+	}
+	if _, err := regs.ReadRegs(0x48, 0x20, 0); err == nil {
+		t.Error("ReadRegs of 0 bytes: got no error")
+	}
+}
+
+func TestI2CRegsErrors(t *testing.T) {
+	bus := i2csim.NewBus()
+	dev := &i2csim.Registers{ReadOnly: map[byte]bool{0x21: true}}
+	bus.Attach(0x48, dev)
+	bus.Attach(0x49, &i2csim.NackAt{Target: &i2csim.Registers{}, Bytes: []int{1}})
+	regs := libmpsse.NewI2CRegs(bus)
+
+	tests := []struct {
+		name string
+		err  error
+		want error
+	}{
+		{
+			"missing device",
+			regs.WriteReg8(0x4A, 0x00, 0x00),
+			&libmpsse.I2CNackError{Addr: 0x4A, Msg: 0, Byte: 0},
+		},
+		{
+			"register NACKed",
+			regs.WriteReg8(0x49, 0x00, 0x00),
+			&libmpsse.I2CNackError{Addr: 0x49, Msg: 0, Byte: 1},
+		},
+		{
+			"read-only register",
+			regs.WriteRegs(0x48, 0x20, []byte{1, 2, 3}),
+			&libmpsse.I2CNackError{Addr: 0x48, Msg: 0, Byte: 3},
+		},
+	}
+	for _, tt := range tests {
+		if !reflect.DeepEqual(tt.err, tt.want) {
+			t.Errorf("%s: got error %v, want %v", tt.name, tt.err, tt.want)
+		}
+	}
+
+	// The register write is NACKed, so the read is never reached.
+	_, err := regs.ReadRegs(0x49, 0x00, 2)
+	want := &libmpsse.I2CNackError{Addr: 0x49, Msg: 0, Byte: 1}
+	if !reflect.DeepEqual(err, want) {
+		t.Errorf("ReadRegs: got error %v, want %v", err, want)
+	}
+
+	failed := errors.New("USB transfer failed")
+	bus.FailNext(failed)
+	if _, err := regs.ReadReg8(0x48, 0x00); err != failed {
+		t.Errorf("got error %v, want %v", err, failed)
+	}
+}
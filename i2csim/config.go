@@ -0,0 +1,174 @@
+package i2csim
+
+import (
+	"fmt"
+	"io/ioutil"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// busConfig is the YAML description of a bus; see ParseBus.
+type busConfig struct {
+	ClockStretching bool           `yaml:"clock_stretching"`
+	Targets         []targetConfig `yaml:"targets"`
+}
+
+// targetConfig describes a target. Type selects Registers or EEPROM, and
+// NackAt and Stretch wrap the target in a NackAt or Stretch.
+type targetConfig struct {
+	Type string `yaml:"type"`
+	Addr uint16 `yaml:"addr"`
+
+	// Registers.
+	Regs     map[uint8]uint8 `yaml:"regs"`
+	ReadOnly []uint8         `yaml:"read_only"`
+
+	// EEPROM.
+	Size       int           `yaml:"size"`
+	PageSize   int           `yaml:"page_size"`
+	AddrWidth  int           `yaml:"addr_width"`
+	WriteCycle time.Duration `yaml:"write_cycle"`
+	Fill       uint8         `yaml:"fill"`
+
+	NackAt  []int          `yaml:"nack_at"`
+	Stretch *stretchConfig `yaml:"stretch"`
+}
+
+// stretchConfig describes the clock stretching of a target.
+type stretchConfig struct {
+	Delay time.Duration `yaml:"delay"`
+	Bytes []int         `yaml:"bytes"`
+}
+
+// ParseBus returns a bus with the targets described by data, in YAML. Each
+// target has a type, registers or eeprom, with the fields of Registers or
+// EEPROM, and can be wrapped in a NackAt or Stretch:
+//
+//	clock_stretching: true
+//	targets:
+//	  - type: registers
+//	    addr: 0x48
+//	    regs: {0x00: 0x12, 0x01: 0x34}
+//	    read_only: [0x00]
+//	  - type: eeprom
+//	    addr: 0x50
+//	    size: 512
+//	    page_size: 16
+//	    addr_width: 1
+//	    write_cycle: 5ms
+//	    fill: 0xFF
+//	  - type: registers
+//	    addr: 0x20
+//	    nack_at: [2]
+//	    stretch: {delay: 1ms, bytes: [0]}
+//
+// Unknown fields are rejected. An EEPROM that uses block select bits is
+// attached at the address of each of its blocks. The targets can be
+// retrieved with Bus.Target.
+func ParseBus(data []byte) (*Bus, error) {
+	var config busConfig
+	if err := yaml.UnmarshalStrict(data, &config); err != nil {
+		return nil, fmt.Errorf("invalid I2C bus description: %v", err)
+	}
+
+	bus := NewBus()
+	bus.SetClockStretching(config.ClockStretching)
+	for i, tc := range config.Targets {
+		target, addrs, err := tc.build()
+		if err != nil {
+			return nil, fmt.Errorf("invalid I2C target %d: %v", i, err)
+		}
+
+		for _, addr := range addrs {
+			if bus.Target(addr) != nil {
+				return nil, fmt.Errorf("invalid I2C target %d: address 0x%02x is already in use", i, addr)
+			}
+			if err := bus.Attach(addr, target); err != nil {
+				return nil, fmt.Errorf("invalid I2C target %d: %v", i, err)
+			}
+		}
+	}
+	return bus, nil
+}
+
+// LoadBus reads the description of a bus from a YAML file.
+func LoadBus(path string) (*Bus, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseBus(data)
+}
+
+// build returns the target described by tc and the addresses to attach it
+// at.
+func (tc targetConfig) build() (Target, []uint16, error) {
+	if tc.Addr > maxAddress {
+		return nil, nil, fmt.Errorf("invalid 7-bit I2C address 0x%x", tc.Addr)
+	}
+
+	var target Target
+	addrs := []uint16{tc.Addr}
+
+	switch tc.Type {
+	case "registers":
+		regs := &Registers{}
+		for reg, v := range tc.Regs {
+			regs.Regs[reg] = v
+		}
+		if len(tc.ReadOnly) > 0 {
+			regs.ReadOnly = make(map[byte]bool)
+			for _, reg := range tc.ReadOnly {
+				regs.ReadOnly[reg] = true
+			}
+		}
+		target = regs
+
+	case "eeprom":
+		if tc.Size <= 0 || tc.Size&(tc.Size-1) != 0 {
+			return nil, nil, fmt.Errorf("EEPROM size %d is not a power of two", tc.Size)
+		}
+		if tc.PageSize <= 0 || tc.PageSize&(tc.PageSize-1) != 0 || tc.PageSize > tc.Size {
+			return nil, nil, fmt.Errorf("invalid EEPROM page size %d", tc.PageSize)
+		}
+		if tc.AddrWidth < 1 || tc.AddrWidth > 2 {
+			return nil, nil, fmt.Errorf("invalid EEPROM address width %d", tc.AddrWidth)
+		}
+
+		e := &EEPROM{
+			Data:       make([]byte, tc.Size),
+			PageSize:   tc.PageSize,
+			AddrWidth:  tc.AddrWidth,
+			WriteCycle: tc.WriteCycle,
+		}
+		for i := range e.Data {
+			e.Data[i] = tc.Fill
+		}
+
+		blocks := tc.Size / e.blockSize()
+		if blocks > 1 {
+			if int(tc.Addr)&(blocks-1) != 0 || int(tc.Addr)+blocks-1 > maxAddress {
+				return nil, nil, fmt.Errorf("EEPROM with %d blocks can not be attached at 0x%02x", blocks, tc.Addr)
+			}
+			for b := 1; b < blocks; b++ {
+				addrs = append(addrs, tc.Addr+uint16(b))
+			}
+		}
+		target = e
+
+	default:
+		return nil, nil, fmt.Errorf("unknown target type %q", tc.Type)
+	}
+
+	if len(tc.NackAt) > 0 {
+		target = &NackAt{Target: target, Bytes: tc.NackAt}
+	}
+	if tc.Stretch != nil {
+		if tc.Stretch.Delay <= 0 {
+			return nil, nil, fmt.Errorf("invalid clock stretch delay %v", tc.Stretch.Delay)
+		}
+		target = &Stretch{Target: target, Delay: tc.Stretch.Delay, Bytes: tc.Stretch.Bytes}
+	}
+	return target, addrs, nil
+}
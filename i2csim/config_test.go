@@ -0,0 +1,96 @@
+package i2csim
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/vapor-ware/libmpsse"
+)
+
+func TestParseBus(t *testing.T) {
+	bus, err := ParseBus([]byte(`
+clock_stretching: true
+targets:
+  - type: registers
+    addr: 0x48
+    regs: {0x00: 0x12, 0x01: 0x34}
+    read_only: [0x00]
+  - type: eeprom
+    addr: 0x50
+    size: 512
+    page_size: 16
+    addr_width: 1
+    write_cycle: 5ms
+    fill: 0xFF
+  - type: registers
+    addr: 0x20
+    nack_at: [2]
+    stretch: {delay: 1ms, bytes: [0]}
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	regs, ok := bus.Target(0x48).(*Registers)
+	if !ok {
+		t.Fatalf("got target %T at 0x48, want *Registers", bus.Target(0x48))
+	}
+	if regs.Regs[0x00] != 0x12 || regs.Regs[0x01] != 0x34 || !regs.ReadOnly[0x00] {
+		t.Errorf("got registers % X, read only %v", regs.Regs[:2], regs.ReadOnly)
+	}
+
+	e, ok := bus.Target(0x50).(*EEPROM)
+	if !ok || bus.Target(0x51) != Target(e) {
+		t.Fatalf("got targets %T, %T at 0x50, 0x51, want one *EEPROM", bus.Target(0x50), bus.Target(0x51))
+	}
+	if len(e.Data) != 512 || e.Data[0x1FF] != 0xFF || e.PageSize != 16 || e.WriteCycle != 5*time.Millisecond {
+		t.Errorf("got EEPROM %+v", e)
+	}
+
+	s, ok := bus.Target(0x20).(*Stretch)
+	if !ok {
+		t.Fatalf("got target %T at 0x20, want *Stretch", bus.Target(0x20))
+	}
+	if n, ok := s.Target.(*NackAt); !ok || s.Delay != time.Millisecond || len(n.Bytes) != 1 {
+		t.Errorf("got stretch %+v", s)
+	}
+
+	// The bus waits for the stretching target, which NACKs byte 2.
+	err = bus.I2CTransfer([]libmpsse.I2CMsg{{Addr: 0x20, Buf: []byte{0x00, 0x01, 0x02}}})
+	checkNack(t, err, 0x20, 0, 2)
+
+	buf := make([]byte, 2)
+	err = bus.I2CTransfer([]libmpsse.I2CMsg{
+		{Addr: 0x48, Buf: []byte{0x00}},
+		{Addr: 0x48, Flags: libmpsse.I2CMsgRead, Buf: buf},
+	})
+	if err != nil || !bytes.Equal(buf, []byte{0x12, 0x34}) {
+		t.Errorf("read % X, %v, want 12 34", buf, err)
+	}
+}
+
+func TestParseBusErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+	}{
+		{"syntax", "targets: [}"},
+		{"unknown field", "targets: [{type: registers, addr: 0x20, color: red}]"},
+		{"unknown type", "targets: [{type: sensor, addr: 0x20}]"},
+		{"invalid address", "targets: [{type: registers, addr: 0x80}]"},
+		{"duplicate address", "targets: [{type: registers, addr: 0x20}, {type: registers, addr: 0x20}]"},
+		{"register out of range", "targets: [{type: registers, addr: 0x20, regs: {0x100: 1}}]"},
+		{"EEPROM size", "targets: [{type: eeprom, addr: 0x50, size: 300, page_size: 16, addr_width: 1}]"},
+		{"EEPROM page size", "targets: [{type: eeprom, addr: 0x50, size: 256, page_size: 12, addr_width: 1}]"},
+		{"EEPROM address width", "targets: [{type: eeprom, addr: 0x50, size: 256, page_size: 16, addr_width: 3}]"},
+		{"EEPROM blocks", "targets: [{type: eeprom, addr: 0x51, size: 512, page_size: 16, addr_width: 1}]"},
+		{"stretch delay", "targets: [{type: registers, addr: 0x20, stretch: {bytes: [1]}}]"},
+	}
+
+	for _, tt := range tests {
+		if _, err := ParseBus([]byte(tt.data)); err == nil {
+			t.Errorf("%s: got no error", tt.name)
+		}
+	}
+}
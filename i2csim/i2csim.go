@@ -0,0 +1,416 @@
+/*
+Package i2csim simulates an I2C bus with scriptable target devices, for
+testing I2C drivers without hardware.
+
+A Bus implements libmpsse.I2CBus, so it can be passed to anything that
+takes one in place of an Mpsse opened in I2C mode:
+
+	bus := i2csim.NewBus()
+	regs := &i2csim.Registers{}
+	bus.Attach(0x48, regs)
+	value, err := libmpsse.NewI2CRegs(bus).ReadReg16(0x48, 0x00)
+
+A Bus is a Go-side fake of the I2CBus interface, not a simulator backend
+for an Mpsse: nothing is compiled into MPSSE commands, so code that uses
+it does not exercise the command buffers that Mpsse.I2CTransfer builds.
+Those are tested against the C implementation's command sequence in
+package libmpsse itself.
+
+Transactions are carried out like Mpsse.I2CTransfer does: the whole
+transaction runs even if a byte is NACKed, and an *libmpsse.I2CNackError
+is returned for the first NACKed byte of a message without
+I2CMsgIgnoreNak set. Bytes read from an address that no target has
+acknowledged read as 0xFF, as the bus is pulled up.
+
+Targets that implement Stretcher can stretch the clock. Like an Mpsse,
+the bus only waits for them if clock stretching is enabled with
+SetClockStretching; otherwise the target misses the rest of the message.
+
+Targets are attached at 7-bit addresses with Attach, or at 10-bit
+addresses with AttachTenBit; the two address spaces are separate, as
+messages with I2CMsgTen set only reach 10-bit targets. A bus and its 7-bit
+targets can also be described in YAML and loaded with ParseBus or LoadBus.
+*/
+package i2csim
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/vapor-ware/libmpsse"
+)
+
+// maxAddress is the largest 7-bit I2C address.
+const maxAddress = 0x7F
+
+// maxAddress10 is the largest 10-bit I2C address.
+const maxAddress10 = 0x3FF
+
+// Target is a device on a simulated bus.
+type Target interface {
+	// Start is called when a start or repeated start condition is
+	// followed by one of the target's addresses. It returns false to NACK
+	// the address.
+	Start(addr uint16, read bool) bool
+
+	// Write is called for each byte written to the target after it has
+	// acknowledged its address. It returns false to NACK the byte.
+	Write(v byte) bool
+
+	// Read returns the next byte read from the target after it has
+	// acknowledged its address.
+	Read() byte
+
+	// Stop is called when a transaction that addressed the target ends,
+	// even if the target NACKed its address.
+	Stop()
+}
+
+// Stretcher is implemented by targets that stretch the clock.
+type Stretcher interface {
+	// ClockStretch is called after the target has acknowledged its
+	// address, and after each byte written to or read from it, and
+	// returns how long the target holds the clock low before the next
+	// byte.
+	ClockStretch() time.Duration
+}
+
+// Bus is a simulated I2C bus. It implements libmpsse.I2CBus, and can be
+// used from several goroutines at once.
+type Bus struct {
+	lock       sync.Mutex
+	targets    map[uint16]Target
+	tenBit     map[uint16]Target
+	fail       error
+	stretching bool
+}
+
+// NewBus returns a bus with no targets.
+func NewBus() *Bus {
+	return &Bus{
+		targets: make(map[uint16]Target),
+		tenBit:  make(map[uint16]Target),
+	}
+}
+
+// Attach attaches t to the bus at the 7-bit address addr, replacing the
+// target at that address, if any. A target can be attached at several
+// addresses, such as an EEPROM that uses block select bits.
+func (b *Bus) Attach(addr uint16, t Target) error {
+	if addr > maxAddress {
+		return fmt.Errorf("invalid 7-bit I2C address 0x%x", addr)
+	}
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	b.targets[addr] = t
+	return nil
+}
+
+// AttachTenBit attaches t to the bus at the 10-bit address addr, replacing
+// the target at that address, if any. Only messages with I2CMsgTen set
+// reach it.
+func (b *Bus) AttachTenBit(addr uint16, t Target) error {
+	if addr > maxAddress10 {
+		return fmt.Errorf("invalid 10-bit I2C address 0x%x", addr)
+	}
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	b.tenBit[addr] = t
+	return nil
+}
+
+// Target returns the target attached at addr, or nil if there is none.
+func (b *Bus) Target(addr uint16) Target {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	return b.targets[addr]
+}
+
+// Detach removes the target at addr from the bus.
+func (b *Bus) Detach(addr uint16) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	delete(b.targets, addr)
+}
+
+// FailNext makes the next transaction return err without reaching the
+// bus, as if the USB transfer to the FTDI chip had failed.
+func (b *Bus) FailNext(err error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	b.fail = err
+}
+
+// SetClockStretching enables or disables waiting for targets that stretch
+// the clock, like Mpsse.SetClockStretching. It is disabled by default. A
+// target that stretches the clock while it is disabled misses the rest of
+// the message: the bytes written to it are NACKed, and the bytes read from
+// it read as 0xFF.
+func (b *Bus) SetClockStretching(enable bool) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	b.stretching = enable
+}
+
+// I2CTransfer performs a combined transaction made up of msgs, with a
+// repeated start between messages and a stop condition at the end.
+func (b *Bus) I2CTransfer(msgs []libmpsse.I2CMsg) error {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if err := b.fail; err != nil {
+		b.fail = nil
+		return err
+	}
+	for _, msg := range msgs {
+		if msg.Flags&libmpsse.I2CMsgTen != 0 {
+			if msg.Addr > maxAddress10 {
+				return fmt.Errorf("invalid 10-bit I2C address 0x%x", msg.Addr)
+			}
+		} else if msg.Addr > maxAddress {
+			return fmt.Errorf("invalid 7-bit I2C address 0x%x", msg.Addr)
+		}
+	}
+
+	t := &transaction{bus: b}
+	err := t.run(msgs)
+	for _, target := range t.started {
+		target.Stop()
+	}
+	if t.nack != nil {
+		return t.nack
+	}
+	return err
+}
+
+// transaction is the state of a transaction on a bus.
+type transaction struct {
+	bus *Bus
+
+	// current is the target that acknowledged the last address, or nil
+	// if none did.
+	current Target
+
+	// started holds the targets that have been addressed, which are
+	// stopped at the end of the transaction.
+	started []Target
+
+	// nack is the error for the first NACKed byte.
+	nack error
+}
+
+// run carries out the messages of the transaction, up to the stop
+// condition.
+func (t *transaction) run(msgs []libmpsse.I2CMsg) error {
+	for i, msg := range msgs {
+		read := msg.Flags&libmpsse.I2CMsgRead != 0
+
+		if i == 0 || msg.Flags&libmpsse.I2CMsgNoStart == 0 {
+			t.address(msg, read)
+			if t.current == nil {
+				t.nacked(msgs, i, 0)
+			}
+			t.stretch()
+		}
+
+		if !read {
+			for j, v := range msg.Buf {
+				if t.current == nil || !t.current.Write(v) {
+					t.nacked(msgs, i, j+1)
+				}
+				t.stretch()
+			}
+			continue
+		}
+
+		first := 0
+		if msg.Flags&libmpsse.I2CMsgRecvLen != 0 {
+			if len(msg.Buf) == 0 {
+				return fmt.Errorf("I2C block read buffer has no room for the length byte")
+			}
+
+			msg.Buf[0] = t.read()
+			count := int(msg.Buf[0])
+			n := len(msg.Buf) + count
+			if count == 0 || count > libmpsse.SMBusBlockMax || n > cap(msg.Buf) {
+				return fmt.Errorf("invalid I2C block length %d", count)
+			}
+			msgs[i].Buf = msg.Buf[:n]
+			msg = msgs[i]
+			first = 1
+		}
+		for j := first; j < len(msg.Buf); j++ {
+			msg.Buf[j] = t.read()
+		}
+	}
+	return nil
+}
+
+// address sends a start condition and the address of msg.
+func (t *transaction) address(msg libmpsse.I2CMsg, read bool) {
+	t.current = nil
+
+	targets := t.bus.targets
+	if msg.Flags&libmpsse.I2CMsgTen != 0 {
+		targets = t.bus.tenBit
+	}
+	target, ok := targets[msg.Addr]
+	if !ok {
+		return
+	}
+
+	found := false
+	for _, s := range t.started {
+		if s == target {
+			found = true
+			break
+		}
+	}
+	if !found {
+		t.started = append(t.started, target)
+	}
+
+	if target.Start(msg.Addr, read) {
+		t.current = target
+	}
+}
+
+// read reads a byte from the current target.
+func (t *transaction) read() byte {
+	if t.current == nil {
+		return 0xFF
+	}
+	v := t.current.Read()
+	t.stretch()
+	return v
+}
+
+// stretch waits for the current target if it stretches the clock after
+// the byte just transferred. If clock stretching is disabled, the master
+// carries on clocking, so the target misses the rest of the message.
+func (t *transaction) stretch() {
+	s, ok := t.current.(Stretcher)
+	if !ok {
+		return
+	}
+
+	d := s.ClockStretch()
+	if d <= 0 {
+		return
+	}
+	if !t.bus.stretching {
+		t.current = nil
+		return
+	}
+	time.Sleep(d)
+}
+
+// nacked records a NACK of byte index of message i.
+func (t *transaction) nacked(msgs []libmpsse.I2CMsg, i, index int) {
+	if t.nack != nil || msgs[i].Flags&libmpsse.I2CMsgIgnoreNak != 0 {
+		return
+	}
+	t.nack = &libmpsse.I2CNackError{Addr: msgs[i].Addr, Msg: i, Byte: index}
+}
+
+// NackAt wraps a target so that it NACKs chosen bytes of each message
+// addressed to it.
+type NackAt struct {
+	Target
+
+	// Bytes are the indexes of the bytes to NACK within each message,
+	// counting the address as byte 0 and the first byte written as
+	// byte 1.
+	Bytes []int
+
+	index int
+}
+
+// Start NACKs the address if byte 0 is in Bytes, and otherwise starts the
+// wrapped target.
+func (n *NackAt) Start(addr uint16, read bool) bool {
+	n.index = 0
+	if n.nacks(0) {
+		return false
+	}
+	return n.Target.Start(addr, read)
+}
+
+// Write NACKs the byte if its index is in Bytes, and otherwise writes it
+// to the wrapped target.
+func (n *NackAt) Write(v byte) bool {
+	n.index++
+	if n.nacks(n.index) {
+		return false
+	}
+	return n.Target.Write(v)
+}
+
+// nacks reports whether byte index is to be NACKed.
+func (n *NackAt) nacks(index int) bool {
+	return contains(n.Bytes, index)
+}
+
+// Stretch wraps a target so that it stretches the clock after chosen bytes
+// of each message addressed to it. It should be the outermost wrapper of a
+// target, as other wrappers hide its ClockStretch method.
+type Stretch struct {
+	Target
+
+	// Delay is how long the clock is held low.
+	Delay time.Duration
+
+	// Bytes are the indexes of the bytes after which the clock is
+	// stretched, counting the address as byte 0 and the bytes written or
+	// read after it from byte 1. If Bytes is empty, the clock is
+	// stretched after every byte.
+	Bytes []int
+
+	index int
+}
+
+// Start starts the wrapped target.
+func (s *Stretch) Start(addr uint16, read bool) bool {
+	s.index = 0
+	return s.Target.Start(addr, read)
+}
+
+// Write writes a byte to the wrapped target.
+func (s *Stretch) Write(v byte) bool {
+	s.index++
+	return s.Target.Write(v)
+}
+
+// Read reads a byte from the wrapped target.
+func (s *Stretch) Read() byte {
+	s.index++
+	return s.Target.Read()
+}
+
+// ClockStretch returns Delay if the clock is stretched after the current
+// byte.
+func (s *Stretch) ClockStretch() time.Duration {
+	if len(s.Bytes) == 0 || contains(s.Bytes, s.index) {
+		return s.Delay
+	}
+	return 0
+}
+
+// contains reports whether index is in indexes.
+func contains(indexes []int, index int) bool {
+	for _, i := range indexes {
+		if i == index {
+			return true
+		}
+	}
+	return false
+}
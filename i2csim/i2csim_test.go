@@ -0,0 +1,263 @@
+package i2csim
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/vapor-ware/libmpsse"
+)
+
+// checkNack checks that err is an *libmpsse.I2CNackError for the given
+// message and byte.
+func checkNack(t *testing.T, err error, addr uint16, msg, index int) {
+	t.Helper()
+
+	want := &libmpsse.I2CNackError{Addr: addr, Msg: msg, Byte: index}
+	if !reflect.DeepEqual(err, want) {
+		t.Errorf("got error %v, want %v", err, want)
+	}
+}
+
+func TestRegisters(t *testing.T) {
+	bus := NewBus()
+	regs := &Registers{ReadOnly: map[byte]bool{0x12: true}}
+	regs.Regs[0xFF] = 0xAA
+	regs.Regs[0x00] = 0xBB
+	if err := bus.Attach(0x48, regs); err != nil {
+		t.Fatal(err)
+	}
+
+	err := bus.I2CTransfer([]libmpsse.I2CMsg{{Addr: 0x48, Buf: []byte{0x10, 0x01, 0x02}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if regs.Regs[0x10] != 0x01 || regs.Regs[0x11] != 0x02 {
+		t.Errorf("got registers % X, want 01 02", regs.Regs[0x10:0x12])
+	}
+
+	// The read wraps around to register 0.
+	buf := make([]byte, 2)
+	err = bus.I2CTransfer([]libmpsse.I2CMsg{
+		{Addr: 0x48, Buf: []byte{0xFF}},
+		{Addr: 0x48, Flags: libmpsse.I2CMsgRead, Buf: buf},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf, []byte{0xAA, 0xBB}) {
+		t.Errorf("read % X, want AA BB", buf)
+	}
+
+	err = bus.I2CTransfer([]libmpsse.I2CMsg{{Addr: 0x48, Buf: []byte{0x11, 0x03, 0x04, 0x05}}})
+	checkNack(t, err, 0x48, 0, 3)
+	if regs.Regs[0x11] != 0x03 {
+		t.Errorf("got register 0x11 = 0x%02X, want 0x03", regs.Regs[0x11])
+	}
+}
+
+func TestNack(t *testing.T) {
+	bus := NewBus()
+	regs := &Registers{}
+	bus.Attach(0x20, &NackAt{Target: regs, Bytes: []int{2}})
+
+	// No device at the address: the data reads as 0xFF.
+	buf := []byte{0, 0}
+	err := bus.I2CTransfer([]libmpsse.I2CMsg{{Addr: 0x21, Flags: libmpsse.I2CMsgRead, Buf: buf}})
+	checkNack(t, err, 0x21, 0, 0)
+	if !bytes.Equal(buf, []byte{0xFF, 0xFF}) {
+		t.Errorf("read % X, want FF FF", buf)
+	}
+
+	// The first NACK is reported, and the transaction carries on.
+	err = bus.I2CTransfer([]libmpsse.I2CMsg{
+		{Addr: 0x20, Buf: []byte{0x00, 0x01, 0x02, 0x03}},
+		{Addr: 0x21},
+	})
+	checkNack(t, err, 0x20, 0, 2)
+	if regs.Regs[1] != 0x03 {
+		t.Errorf("got register 1 = 0x%02X, want 0x03", regs.Regs[1])
+	}
+
+	err = bus.I2CTransfer([]libmpsse.I2CMsg{
+		{Addr: 0x20, Flags: libmpsse.I2CMsgIgnoreNak, Buf: []byte{0x00, 0x01, 0x02}},
+		{Addr: 0x21, Flags: libmpsse.I2CMsgIgnoreNak},
+	})
+	if err != nil {
+		t.Errorf("ignored NACKs: got error %v", err)
+	}
+
+	// 10-bit addresses are never acknowledged.
+	err = bus.I2CTransfer([]libmpsse.I2CMsg{{Addr: 0x20, Flags: libmpsse.I2CMsgTen}})
+	checkNack(t, err, 0x20, 0, 0)
+}
+
+func TestNoStart(t *testing.T) {
+	bus := NewBus()
+	regs := &Registers{}
+	bus.Attach(0x20, regs)
+
+	err := bus.I2CTransfer([]libmpsse.I2CMsg{
+		{Addr: 0x20, Buf: []byte{0x05}},
+		{Addr: 0x20, Flags: libmpsse.I2CMsgNoStart, Buf: []byte{0x01, 0x02}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if regs.Regs[5] != 0x01 || regs.Regs[6] != 0x02 {
+		t.Errorf("got registers % X, want 01 02", regs.Regs[5:7])
+	}
+}
+
+func TestRecvLen(t *testing.T) {
+	bus := NewBus()
+	regs := &Registers{}
+	copy(regs.Regs[0x10:], []byte{3, 'a', 'b', 'c'})
+	bus.Attach(0x20, regs)
+
+	buf := make([]byte, 1, 1+libmpsse.SMBusBlockMax)
+	msgs := []libmpsse.I2CMsg{
+		{Addr: 0x20, Buf: []byte{0x10}},
+		{Addr: 0x20, Flags: libmpsse.I2CMsgRead | libmpsse.I2CMsgRecvLen, Buf: buf},
+	}
+	if err := bus.I2CTransfer(msgs); err != nil {
+		t.Fatal(err)
+	}
+	if got := msgs[1].Buf; !bytes.Equal(got, []byte{3, 'a', 'b', 'c'}) {
+		t.Errorf("read % X, want 03 61 62 63", got)
+	}
+
+	regs.Regs[0x10] = 0
+	msgs[1].Buf = buf[:1]
+	if err := bus.I2CTransfer(msgs); err == nil {
+		t.Error("zero length: got no error")
+	}
+}
+
+func TestFailNext(t *testing.T) {
+	bus := NewBus()
+	regs := &Registers{}
+	bus.Attach(0x20, regs)
+
+	failed := errors.New("USB transfer failed")
+	bus.FailNext(failed)
+	if err := bus.I2CTransfer([]libmpsse.I2CMsg{{Addr: 0x20, Buf: []byte{0, 1}}}); err != failed {
+		t.Errorf("got error %v, want %v", err, failed)
+	}
+	if regs.Regs[0] != 0 {
+		t.Error("failed transaction reached the target")
+	}
+	if err := bus.I2CTransfer([]libmpsse.I2CMsg{{Addr: 0x20}}); err != nil {
+		t.Errorf("next transaction: got error %v", err)
+	}
+
+	if err := bus.I2CTransfer([]libmpsse.I2CMsg{{Addr: 0x80}}); err == nil {
+		t.Error("invalid address: got no error")
+	}
+	if err := bus.Attach(0x80, regs); err == nil {
+		t.Error("attach at invalid address: got no error")
+	}
+}
+
+func TestTenBit(t *testing.T) {
+	bus := NewBus()
+	regs, regs10 := &Registers{}, &Registers{}
+	bus.Attach(0x50, regs)
+	if err := bus.AttachTenBit(0x050, regs10); err != nil {
+		t.Fatal(err)
+	}
+
+	// The 7-bit and 10-bit address spaces are separate.
+	ten := libmpsse.I2CMsgTen
+	if err := bus.I2CTransfer([]libmpsse.I2CMsg{{Addr: 0x050, Flags: ten, Buf: []byte{0x01, 0xAA}}}); err != nil {
+		t.Fatal(err)
+	}
+	if regs10.Regs[1] != 0xAA || regs.Regs[1] != 0 {
+		t.Errorf("10-bit write reached the wrong target")
+	}
+	if err := bus.I2CTransfer([]libmpsse.I2CMsg{{Addr: 0x051, Flags: ten}}); err == nil {
+		t.Error("missing 10-bit target: got no error")
+	}
+
+	if err := bus.I2CTransfer([]libmpsse.I2CMsg{{Addr: 0x400, Flags: ten}}); err == nil {
+		t.Error("invalid 10-bit address: got no error")
+	}
+	if err := bus.AttachTenBit(0x400, regs10); err == nil {
+		t.Error("attach at invalid 10-bit address: got no error")
+	}
+}
+
+func TestEEPROM(t *testing.T) {
+	bus := NewBus()
+	e := &EEPROM{Data: make([]byte, 512), PageSize: 16, AddrWidth: 1, WriteCycle: time.Hour}
+	bus.Attach(0x50, e)
+	bus.Attach(0x51, e)
+
+	// The write wraps around within the page of the second block.
+	err := bus.I2CTransfer([]libmpsse.I2CMsg{{Addr: 0x51, Buf: []byte{0x0E, 1, 2, 3}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := e.Data[0x100:0x110]; !bytes.Equal(got, []byte{3, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 1, 2}) {
+		t.Errorf("got page % X", got)
+	}
+
+	// The write cycle is in progress.
+	checkNack(t, bus.I2CTransfer([]libmpsse.I2CMsg{{Addr: 0x50}}), 0x50, 0, 0)
+
+	e.WriteCycle = 0
+	e.busy = time.Time{}
+	buf := make([]byte, 3)
+	err = bus.I2CTransfer([]libmpsse.I2CMsg{
+		{Addr: 0x51, Buf: []byte{0x0E}},
+		{Addr: 0x51, Flags: libmpsse.I2CMsgRead, Buf: buf},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf, []byte{1, 2, 0}) {
+		t.Errorf("read % X, want 01 02 00", buf)
+	}
+}
+
+func TestStretch(t *testing.T) {
+	bus := NewBus()
+	regs := &Registers{}
+	regs.Regs[0x01] = 0xAB
+	bus.Attach(0x20, &Stretch{Target: regs, Delay: 10 * time.Millisecond, Bytes: []int{0}})
+
+	// Without clock stretching, the target misses the bytes after its
+	// address.
+	err := bus.I2CTransfer([]libmpsse.I2CMsg{{Addr: 0x20, Buf: []byte{0x00, 0x01}}})
+	checkNack(t, err, 0x20, 0, 1)
+	if regs.Regs[0x00] != 0 {
+		t.Errorf("got register 0 = 0x%02X, want 0x00", regs.Regs[0x00])
+	}
+
+	buf := []byte{0}
+	msgs := []libmpsse.I2CMsg{
+		{Addr: 0x20, Flags: libmpsse.I2CMsgIgnoreNak, Buf: []byte{0x01}},
+		{Addr: 0x20, Flags: libmpsse.I2CMsgRead, Buf: buf},
+	}
+	if err := bus.I2CTransfer(msgs); err != nil {
+		t.Fatal(err)
+	}
+	if buf[0] != 0xFF {
+		t.Errorf("read 0x%02X, want 0xFF", buf[0])
+	}
+
+	// With clock stretching, the bus waits for the target.
+	bus.SetClockStretching(true)
+	start := time.Now()
+	if err := bus.I2CTransfer(msgs); err != nil {
+		t.Fatal(err)
+	}
+	if buf[0] != 0xAB {
+		t.Errorf("read 0x%02X, want 0xAB", buf[0])
+	}
+	if d := time.Since(start); d < 20*time.Millisecond {
+		t.Errorf("transfer took %v, want at least 20ms", d)
+	}
+}
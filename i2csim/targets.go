@@ -0,0 +1,148 @@
+package i2csim
+
+import (
+	"time"
+)
+
+// Registers is a target with 256 8-bit registers and a register pointer,
+// like many sensors and I/O expanders. The first byte written in each
+// message sets the pointer, and the bytes after it are written to
+// consecutive registers; reads start at the pointer. The pointer wraps
+// from 0xFF to 0x00.
+type Registers struct {
+	Regs [256]byte
+
+	// ReadOnly marks registers whose writes are NACKed.
+	ReadOnly map[byte]bool
+
+	ptr     byte
+	pointed bool
+}
+
+// Start starts a message to the device.
+func (r *Registers) Start(addr uint16, read bool) bool {
+	r.pointed = read
+	return true
+}
+
+// Write sets the register pointer, or writes a register.
+func (r *Registers) Write(v byte) bool {
+	if !r.pointed {
+		r.ptr = v
+		r.pointed = true
+		return true
+	}
+
+	if r.ReadOnly[r.ptr] {
+		return false
+	}
+	r.Regs[r.ptr] = v
+	r.ptr++
+	return true
+}
+
+// Read reads the register at the pointer.
+func (r *Registers) Read() byte {
+	v := r.Regs[r.ptr]
+	r.ptr++
+	return v
+}
+
+// Stop ends a transaction.
+func (r *Registers) Stop() {}
+
+// EEPROM is a 24xx series I2C EEPROM. Parts whose size is more than the
+// address bytes can cover use the low bits of the device address as
+// block select bits, and must be attached at the address of each block.
+//
+// Bytes written are buffered until the stop condition, and wrap around
+// within their page. The EEPROM then starts a write cycle, during which
+// it does not acknowledge its address.
+type EEPROM struct {
+	// Data is the contents of the EEPROM. Its length is the size of the
+	// EEPROM, which must be a power of two.
+	Data []byte
+
+	// PageSize is the size of a write page, which must be a power of
+	// two.
+	PageSize int
+
+	// AddrWidth is the number of address bytes, 1 or 2.
+	AddrWidth int
+
+	// WriteCycle is the time that a write cycle takes.
+	WriteCycle time.Duration
+
+	busy    time.Time
+	ptr     int
+	block   int
+	naddr   int
+	pending map[int]byte
+}
+
+// blockSize returns the number of bytes that the address bytes cover.
+func (e *EEPROM) blockSize() int {
+	return 1 << (uint(e.AddrWidth) * 8)
+}
+
+// Start starts a message to the block at addr, unless a write cycle is in
+// progress.
+func (e *EEPROM) Start(addr uint16, read bool) bool {
+	if time.Now().Before(e.busy) {
+		return false
+	}
+
+	blocks := len(e.Data) / e.blockSize()
+	if blocks < 1 {
+		blocks = 1
+	}
+	e.block = int(addr) & (blocks - 1)
+	e.naddr = 0
+	if read {
+		// Reads carry on from the last address.
+		e.naddr = e.AddrWidth
+	}
+	return true
+}
+
+// Write adds a byte to the address, or writes a byte to the current page.
+func (e *EEPROM) Write(v byte) bool {
+	if e.naddr < e.AddrWidth {
+		if e.naddr == 0 {
+			e.ptr = e.block * e.blockSize()
+		}
+		e.ptr |= int(v) << (uint(e.AddrWidth-1-e.naddr) * 8)
+		e.ptr &= len(e.Data) - 1
+		e.naddr++
+		return true
+	}
+
+	if e.pending == nil {
+		e.pending = make(map[int]byte)
+	}
+	e.pending[e.ptr] = v
+	page := e.ptr &^ (e.PageSize - 1)
+	e.ptr = page | (e.ptr+1)&(e.PageSize-1)
+	return true
+}
+
+// Read reads the byte at the current address.
+func (e *EEPROM) Read() byte {
+	v := e.Data[e.ptr]
+	e.ptr = (e.ptr + 1) & (len(e.Data) - 1)
+	return v
+}
+
+// Stop writes the bytes written during the transaction, if any, and
+// starts a write cycle.
+func (e *EEPROM) Stop() {
+	if len(e.pending) == 0 {
+		return
+	}
+
+	for addr, v := range e.pending {
+		e.Data[addr] = v
+	}
+	e.pending = nil
+	e.busy = time.Now().Add(e.WriteCycle)
+}
@@ -0,0 +1,468 @@
+package libmpsse
+
+import (
+	"fmt"
+)
+
+// I2CFlag is a set of flags that modify how an I2CMsg is sent. The values
+// match the flags of struct i2c_msg in the Linux i2c-dev interface.
+type I2CFlag uint16
+
+// Supported I2C message flags.
+const (
+	// I2CMsgRead reads into the message buffer instead of writing it.
+	I2CMsgRead I2CFlag = 0x0001
+
+	// I2CMsgTen addresses the device with a 10-bit address.
+	I2CMsgTen I2CFlag = 0x0010
+
+	// I2CMsgRecvLen treats the first byte read as the length of an SMBus
+	// block. len(Buf) is the number of bytes to read besides the block
+	// itself (the length byte, plus a PEC byte if one is expected), and
+	// Buf must have the capacity for up to SMBusBlockMax more bytes. Buf
+	// is resliced to the bytes actually read.
+	I2CMsgRecvLen I2CFlag = 0x0400
+
+	// I2CMsgIgnoreNak treats NACKs for the message as ACKs.
+	I2CMsgIgnoreNak I2CFlag = 0x1000
+
+	// I2CMsgNoStart continues the previous message without a repeated
+	// start or address byte. It is ignored for the first message.
+	I2CMsgNoStart I2CFlag = 0x4000
+)
+
+// i2cTransferSize is the maximum number of bytes that are read back from
+// the FTDI chip in one USB round trip, matching I2C_TRANSFER_SIZE in the C
+// implementation. Longer transfers are split into several round trips.
+const i2cTransferSize = 64
+
+// I2CBus is implemented by anything that can carry I2C transactions, such
+// as an Mpsse opened in I2C mode. Drivers that take an I2CBus rather than
+// an Mpsse can be used with any such bus.
+type I2CBus interface {
+	I2CTransfer(msgs []I2CMsg) error
+}
+
+// I2CMsg is a single message of an I2C transaction: a start (or repeated
+// start) condition, the address byte and the data written or read.
+type I2CMsg struct {
+	// Addr is the 7-bit (or 10-bit, with I2CMsgTen) slave address.
+	Addr uint16
+
+	// Flags modify how the message is sent.
+	Flags I2CFlag
+
+	// Buf holds the data to write, or for reads, is filled with len(Buf)
+	// bytes read from the slave.
+	Buf []byte
+}
+
+// I2CTransfer performs a combined I2C transaction made up of msgs, with a
+// repeated start between messages and a stop condition at the end. Every
+// byte read is ACKed except the last byte of each read message, which is
+// NACKed.
+//
+// All of the messages are compiled into a single buffer of MPSSE commands,
+// so the transaction takes one USB round trip (or one per 64 bytes of
+// data read and written, for longer transactions) rather than one per
+// byte.
+//
+// Since the whole transaction is sent before any ACK is checked, it runs
+// to completion even if the slave NACKs a byte. In that case an
+// *I2CNackError is returned for the first NACKed byte of a message without
+// I2CMsgIgnoreNak set. GetAck reports the ACK of the last byte written.
+// If a USB transfer fails part way through, a stop condition is sent if
+// possible, so that the bus is not left in the middle of a transaction.
+//
+// For use in I2C mode only.
+func (m *Mpsse) I2CTransfer(msgs []I2CMsg) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	b := &i2cBuilder{
+		port:  m,
+		state: m.state(),
+	}
+	b.started = b.state.started
+
+	if b.state.mode != I2C {
+		return fmt.Errorf("I2C transfer requires I2C mode")
+	}
+	for _, msg := range msgs {
+		if err := checkI2CMsg(msg); err != nil {
+			return err
+		}
+	}
+
+	err := b.transfer(msgs)
+	m.setStarted(false)
+	if err != nil {
+		b.abort()
+		return err
+	}
+
+	ack, err := b.finish(msgs)
+	m.setAck(ack)
+	if err != nil {
+		return err
+	}
+	return b.lenErr
+}
+
+// transfer builds and sends the commands for msgs, ending with a stop
+// condition. If an I2CMsgRecvLen message reads an invalid length, the
+// transaction is stopped there and b.lenErr is set.
+func (b *i2cBuilder) transfer(msgs []I2CMsg) error {
+	for i, msg := range msgs {
+		if i == 0 || msg.Flags&I2CMsgNoStart == 0 {
+			if err := b.address(i, msg); err != nil {
+				return err
+			}
+		}
+
+		if msg.Flags&I2CMsgRead != 0 {
+			first := 0
+			if msg.Flags&I2CMsgRecvLen != 0 {
+				n, err := b.readLen(i, msg)
+				if err != nil {
+					return err
+				}
+				if n == 0 {
+					// End the transaction. A NACK of the address is
+					// reported in preference to the length, which is
+					// meaningless if no device answered.
+					break
+				}
+				msgs[i].Buf = msg.Buf[:n]
+				msg = msgs[i]
+				first = 1
+			}
+
+			for j := first; j < len(msg.Buf); j++ {
+				ack := ACK
+				if j == len(msg.Buf)-1 {
+					ack = NACK
+				}
+				if err := b.read(i, j, ack); err != nil {
+					return err
+				}
+			}
+		} else {
+			for j, v := range msg.Buf {
+				if err := b.write(i, j+1, v); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	b.stop()
+	return b.flush()
+}
+
+// checkI2CMsg checks that the address of msg is valid for its address
+// width.
+func checkI2CMsg(msg I2CMsg) error {
+	if msg.Flags&I2CMsgTen != 0 {
+		if msg.Addr > i2cMaxAddress10 {
+			return fmt.Errorf("invalid 10-bit I2C address 0x%x", msg.Addr)
+		}
+	} else if msg.Addr > i2cMaxAddress {
+		return fmt.Errorf("invalid 7-bit I2C address 0x%x", msg.Addr)
+	}
+	return nil
+}
+
+// i2cResult describes a byte that the FTDI chip returns while running a
+// compiled I2C transaction: either the ACK bit for a byte written, or a
+// byte of data read.
+type i2cResult struct {
+	// msg and index identify the byte within the transaction; see
+	// I2CNackError.
+	msg   int
+	index int
+
+	// For reads, pos is the offset into the message buffer that the data
+	// is stored at.
+	read bool
+	pos  int
+}
+
+// i2cBuilder compiles I2C transactions into MPSSE command buffers. The
+// commands mirror those generated by the C implementation's Start, Write,
+// Read and Stop functions.
+type i2cBuilder struct {
+	port    rawPort
+	state   mpsseState
+	started bool
+
+	cmds    []byte
+	pending int
+
+	results []i2cResult
+	data    []byte
+
+	// lenErr is set if an I2CMsgRecvLen message read an invalid length. It
+	// reports the length byte that was read.
+	lenErr error
+}
+
+// setBits sets the low byte pins.
+func (b *i2cBuilder) setBits(port, tris byte) {
+	b.cmds = append(b.cmds, cmdSetBitsLow, port, tris)
+}
+
+// start adds a start condition, or a repeated start condition if a start
+// has already been sent.
+func (b *i2cBuilder) start() {
+	if b.started {
+		// Return to the idle pin states while the clock is low.
+		b.setBits(b.state.pidle&^pinSK, b.state.tris)
+		b.setBits(b.state.pidle, b.state.tris)
+	}
+	b.setBits(b.state.pstart, b.state.tris)
+	b.started = true
+}
+
+// stop adds a stop condition and returns the pins to their idle states.
+func (b *i2cBuilder) stop() {
+	// The data line must go low while the clock is low, to avoid sending
+	// an inadvertent start condition.
+	b.setBits(b.state.pidle&^pinDO&^pinSK, b.state.tris)
+	b.setBits(b.state.pstop, b.state.tris)
+	b.setBits(b.state.pidle, b.state.tris)
+	b.started = false
+}
+
+// abort makes a best effort to end a transaction that failed part way
+// through, by discarding the commands not yet sent and sending a stop
+// condition. Errors are ignored, as the transaction has already failed.
+func (b *i2cBuilder) abort() {
+	b.cmds = b.cmds[:0]
+	b.pending = 0
+	b.stop()
+	b.port.rawWrite(b.cmds)
+}
+
+// address adds a start condition and the address byte(s) for msg. 10-bit
+// reads are addressed with a write of the full address, followed by a
+// repeated start and the first address byte with the read bit set.
+func (b *i2cBuilder) address(msg int, m I2CMsg) error {
+	read := m.Flags&I2CMsgRead != 0
+
+	b.start()
+
+	if m.Flags&I2CMsgTen == 0 {
+		addr := byte(m.Addr << 1)
+		if read {
+			addr |= 0x01
+		}
+		return b.write(msg, 0, addr)
+	}
+
+	header := byte(i2cTenBitPrefix | (m.Addr>>8)<<1)
+	if err := b.write(msg, 0, header); err != nil {
+		return err
+	}
+	if err := b.write(msg, 0, byte(m.Addr)); err != nil {
+		return err
+	}
+	if read {
+		b.start()
+		return b.write(msg, 0, header|0x01)
+	}
+	return nil
+}
+
+// write adds a byte to write, and reads back the slave's ACK bit.
+func (b *i2cBuilder) write(msg, index int, v byte) error {
+	if err := b.reserve(); err != nil {
+		return err
+	}
+
+	// Clock out the byte with the clock starting low.
+	b.setBits(b.state.pstart&^pinSK, b.state.tris)
+	b.cmds = append(b.cmds, b.state.tx, 0, 0, v)
+
+	// Make data out an input while the slave drives the ACK bit.
+	b.setBits(b.state.pstart&^pinSK, b.state.tris&^pinDO)
+	b.cmds = append(b.cmds, b.state.rx|mpsseBitmode, 0)
+
+	b.results = append(b.results, i2cResult{msg: msg, index: index})
+	return nil
+}
+
+// read adds a byte to read, followed by the given ACK bit.
+func (b *i2cBuilder) read(msg, pos int, ack I2CAck) error {
+	if err := b.reserve(); err != nil {
+		return err
+	}
+
+	// Make data out an input while the slave drives the data.
+	b.setBits(b.state.pstart&^pinSK, b.state.tris&^pinDO)
+	b.cmds = append(b.cmds, b.state.rx, 0, 0)
+
+	tack := byte(0x00)
+	if ack == NACK {
+		tack = 0xFF
+	}
+	b.setBits(b.state.pstart&^pinSK, b.state.tris)
+	b.cmds = append(b.cmds, b.state.tx|mpsseBitmode, 0, tack)
+
+	b.results = append(b.results, i2cResult{msg: msg, index: pos + 1, read: true, pos: pos})
+	return nil
+}
+
+// readLen reads the length byte of an SMBus block for an I2CMsgRecvLen
+// message, and returns the total number of bytes the message will read. If
+// the length is invalid, b.lenErr is set and 0 is returned. The length byte
+// has to be known before the rest of the message can be built, so the
+// commands built so far are flushed to read it.
+func (b *i2cBuilder) readLen(msg int, m I2CMsg) (int, error) {
+	if len(m.Buf) == 0 {
+		return 0, fmt.Errorf("I2C block read buffer has no room for the length byte")
+	}
+
+	if err := b.read(msg, 0, ACK); err != nil {
+		return 0, err
+	}
+	if err := b.flush(); err != nil {
+		return 0, err
+	}
+
+	n, err := blockLen(m, b.data[len(b.data)-1])
+	if err != nil {
+		b.lenErr = err
+		return 0, nil
+	}
+	return n, nil
+}
+
+// blockLen returns the total number of bytes an I2CMsgRecvLen message
+// reads when the slave sends count as the length of the block, or an error
+// if count is invalid.
+func blockLen(m I2CMsg, count byte) (int, error) {
+	n := len(m.Buf) + int(count)
+	if count == 0 || count > SMBusBlockMax || n > cap(m.Buf) {
+		return 0, fmt.Errorf("invalid I2C block length %d", count)
+	}
+	return n, nil
+}
+
+// reserve makes room for one more byte of data to be returned, flushing
+// the commands built so far if the limit for a round trip is reached.
+func (b *i2cBuilder) reserve() error {
+	if b.pending >= i2cTransferSize {
+		if err := b.flush(); err != nil {
+			return err
+		}
+	}
+	b.pending++
+	return nil
+}
+
+// flush sends the commands built so far and reads back the data they
+// return. If the port is an Mpsse, the caller must hold its lock.
+func (b *i2cBuilder) flush() error {
+	if len(b.cmds) == 0 {
+		return nil
+	}
+
+	b.cmds = append(b.cmds, cmdSendImmediate)
+	if err := b.port.rawWrite(b.cmds); err != nil {
+		return err
+	}
+
+	data := make([]byte, b.pending)
+	if err := b.port.rawRead(data); err != nil {
+		return err
+	}
+	b.data = append(b.data, data...)
+
+	b.cmds = b.cmds[:0]
+	b.pending = 0
+	return nil
+}
+
+// finish copies the data read into the message buffers and checks the
+// ACK bits of the bytes written. It returns the ACK bit of the last byte
+// written, for GetAck.
+func (b *i2cBuilder) finish(msgs []I2CMsg) (I2CAck, error) {
+	var nack error
+	lastAck := ACK
+
+	for i, r := range b.results {
+		if r.read {
+			msgs[r.msg].Buf[r.pos] = b.data[i]
+			continue
+		}
+
+		lastAck = I2CAck(b.data[i] & 0x01)
+		if lastAck == NACK && nack == nil && msgs[r.msg].Flags&I2CMsgIgnoreNak == 0 {
+			nack = &I2CNackError{Addr: msgs[r.msg].Addr, Msg: r.msg, Byte: r.index}
+		}
+	}
+
+	return lastAck, nack
+}
+
+// i2cWritePort is the part of an Mpsse that writeI2C uses.
+type i2cWritePort interface {
+	rawPort
+	state() mpsseState
+	setAck(ack I2CAck)
+}
+
+// writeI2C writes data in I2C mode, checking the ACK bit of every byte.
+// Unless aborting on NACKs is enabled, all of the bytes are sent in one
+// batch. The caller must hold the lock.
+func (m *Mpsse) writeI2C(data []byte) error {
+	return writeI2C(m, data, m.abortOnNack)
+}
+
+// writeI2C writes data to port; see Mpsse.writeI2C. If abortOnNack is set,
+// each byte is sent in a batch of its own, and no more are sent after the
+// first NACK.
+func writeI2C(port i2cWritePort, data []byte, abortOnNack bool) error {
+	if !abortOnNack {
+		return writeI2CBatch(port, data, 0)
+	}
+
+	for i := range data {
+		if err := writeI2CBatch(port, data[i:i+1], i); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeI2CBatch writes data without start or stop conditions, and returns
+// an *I2CWriteNackError for the first byte that was not acknowledged. The
+// offset of data within the caller's data is added to the byte index.
+func writeI2CBatch(port i2cWritePort, data []byte, offset int) error {
+	b := &i2cBuilder{
+		port:  port,
+		state: port.state(),
+	}
+
+	for i, v := range data {
+		if err := b.write(0, offset+i, v); err != nil {
+			return err
+		}
+	}
+	if err := b.flush(); err != nil {
+		return err
+	}
+
+	var nack error
+	lastAck := ACK
+	for i, r := range b.results {
+		lastAck = I2CAck(b.data[i] & 0x01)
+		if lastAck == NACK && nack == nil {
+			nack = &I2CWriteNackError{Byte: r.index}
+		}
+	}
+
+	port.setAck(lastAck)
+	return nack
+}
@@ -0,0 +1,281 @@
+package libmpsse
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+// fakeRawPort records the command buffers written to it, and answers reads
+// with the bytes in in.
+type fakeRawPort struct {
+	writes [][]byte
+	reads  []int
+	in     []byte
+
+	// failWrite makes the write with this index (counting from 1) fail.
+	failWrite int
+}
+
+func (p *fakeRawPort) rawWrite(buf []byte) error {
+	p.writes = append(p.writes, append([]byte(nil), buf...))
+	if len(p.writes) == p.failWrite {
+		return errors.New("USB transfer failed")
+	}
+	return nil
+}
+
+func (p *fakeRawPort) rawRead(buf []byte) error {
+	if len(buf) > len(p.in) {
+		return errors.New("read past the data returned")
+	}
+	p.reads = append(p.reads, len(buf))
+	copy(buf, p.in)
+	p.in = p.in[len(buf):]
+	return nil
+}
+
+// fakeI2CWritePort is a fakeRawPort in I2C mode that records the last ACK
+// bit set.
+type fakeI2CWritePort struct {
+	fakeRawPort
+	ack I2CAck
+}
+
+func (p *fakeI2CWritePort) state() mpsseState { return i2cState }
+func (p *fakeI2CWritePort) setAck(ack I2CAck) { p.ack = ack }
+
+// i2cState is the state the C implementation sets up for I2C mode, MSB
+// first.
+var i2cState = mpsseState{
+	mode:   I2C,
+	pidle:  0x0F,
+	pstart: 0x09,
+	pstop:  0x09,
+	tris:   0xFB,
+	tx:     0x11,
+	rx:     0x20,
+	txrx:   0x31,
+}
+
+// The command sequences of the C implementation for I2C mode, from Start,
+// Stop and build_block_buffer. A start after another start first returns
+// the pins to idle, and each byte written or read sets the pins for the
+// clock to start low. The C implementation sends each byte in a buffer of
+// its own, ending with SEND_IMMEDIATE; I2CTransfer sends a single
+// SEND_IMMEDIATE at the end of each round trip instead.
+var (
+	cStart    = []byte{0x80, 0x09, 0xFB}
+	cRestart  = []byte{0x80, 0x0E, 0xFB, 0x80, 0x0F, 0xFB, 0x80, 0x09, 0xFB}
+	cStop     = []byte{0x80, 0x0C, 0xFB, 0x80, 0x09, 0xFB, 0x80, 0x0F, 0xFB}
+	cReadAck  = []byte{0x80, 0x08, 0xF9, 0x20, 0x00, 0x00, 0x80, 0x08, 0xFB, 0x13, 0x00, 0x00}
+	cReadNack = []byte{0x80, 0x08, 0xF9, 0x20, 0x00, 0x00, 0x80, 0x08, 0xFB, 0x13, 0x00, 0xFF}
+)
+
+// cWrite returns the C implementation's command sequence to write v and
+// read back the ACK bit.
+func cWrite(v byte) []byte {
+	return []byte{0x80, 0x08, 0xFB, 0x11, 0x00, 0x00, v, 0x80, 0x08, 0xF9, 0x22, 0x00}
+}
+
+// cmds joins command sequences.
+func cmds(seqs ...[]byte) []byte {
+	return bytes.Join(seqs, nil)
+}
+
+// runI2C runs msgs through an i2cBuilder on port, as I2CTransfer does.
+func runI2C(port rawPort, msgs []I2CMsg) (I2CAck, error) {
+	b := &i2cBuilder{port: port, state: i2cState}
+	if err := b.transfer(msgs); err != nil {
+		b.abort()
+		return ACK, err
+	}
+	ack, err := b.finish(msgs)
+	if err != nil {
+		return ack, err
+	}
+	return ack, b.lenErr
+}
+
+func TestBlockLen(t *testing.T) {
+	// A block read with room for the length byte, a PEC byte and up to
+	// SMBusBlockMax bytes of data.
+	msg := I2CMsg{Flags: I2CMsgRead | I2CMsgRecvLen, Buf: make([]byte, 2, 2+SMBusBlockMax)}
+
+	if n, err := blockLen(msg, 4); err != nil || n != 6 {
+		t.Errorf("length 4: got %d, %v, want 6", n, err)
+	}
+
+	// The error reports the length byte that was read, not the stale
+	// contents of the message buffer.
+	msg.Buf[0] = 0xAA
+	for _, count := range []byte{0, SMBusBlockMax + 1, 0xFF} {
+		_, err := blockLen(msg, count)
+		if err == nil {
+			t.Errorf("length %d: got no error", count)
+			continue
+		}
+		if want := fmt.Sprintf("invalid I2C block length %d", count); err.Error() != want {
+			t.Errorf("length %d: got error %q, want %q", count, err, want)
+		}
+	}
+
+	// The block must also fit in the buffer.
+	short := I2CMsg{Flags: I2CMsgRead | I2CMsgRecvLen, Buf: make([]byte, 1, 8)}
+	if _, err := blockLen(short, 8); err == nil {
+		t.Error("block larger than the buffer: got no error")
+	}
+}
+
+func TestI2CBuilderCommands(t *testing.T) {
+	// A register write followed by a 2 byte read, and a message without a
+	// start condition that continues the read.
+	port := &fakeRawPort{in: []byte{0, 0, 0, 0x12, 0x34, 0x56}}
+	buf, more := make([]byte, 2), make([]byte, 1)
+	msgs := []I2CMsg{
+		{Addr: 0x50, Buf: []byte{0x10}},
+		{Addr: 0x50, Flags: I2CMsgRead, Buf: buf},
+		{Addr: 0x50, Flags: I2CMsgRead | I2CMsgNoStart, Buf: more},
+	}
+	if _, err := runI2C(port, msgs); err != nil {
+		t.Fatal(err)
+	}
+
+	want := cmds(
+		cStart, cWrite(0xA0), cWrite(0x10),
+		cRestart, cWrite(0xA1), cReadAck, cReadNack,
+		cReadNack,
+		cStop, []byte{cmdSendImmediate},
+	)
+	if len(port.writes) != 1 || !bytes.Equal(port.writes[0], want) {
+		t.Errorf("got commands % X\nwant % X", port.writes, want)
+	}
+	if !bytes.Equal(buf, []byte{0x12, 0x34}) || more[0] != 0x56 {
+		t.Errorf("got data % X % X, want 12 34 56", buf, more)
+	}
+}
+
+func TestI2CBuilderRecvLen(t *testing.T) {
+	// The length byte is read in a round trip of its own, before the rest
+	// of the block is built.
+	port := &fakeRawPort{in: []byte{0, 2, 0xAA, 0xBB}}
+	msgs := []I2CMsg{{Addr: 0x50, Flags: I2CMsgRead | I2CMsgRecvLen, Buf: make([]byte, 1, 1+SMBusBlockMax)}}
+	if _, err := runI2C(port, msgs); err != nil {
+		t.Fatal(err)
+	}
+
+	want := [][]byte{
+		cmds(cStart, cWrite(0xA1), cReadAck, []byte{cmdSendImmediate}),
+		cmds(cReadAck, cReadNack, cStop, []byte{cmdSendImmediate}),
+	}
+	if !reflect.DeepEqual(port.writes, want) {
+		t.Errorf("got commands % X\nwant % X", port.writes, want)
+	}
+	if got := msgs[0].Buf; !bytes.Equal(got, []byte{2, 0xAA, 0xBB}) {
+		t.Errorf("got block % X, want 02 AA BB", got)
+	}
+}
+
+func TestI2CBuilderFlush(t *testing.T) {
+	// The address and 63 bytes fill a round trip; one more byte needs a
+	// second one.
+	for _, n := range []int{63, 64} {
+		port := &fakeRawPort{in: make([]byte, n+1)}
+		if _, err := runI2C(port, []I2CMsg{{Addr: 0x50, Buf: make([]byte, n)}}); err != nil {
+			t.Fatal(err)
+		}
+
+		want := []int{n + 1}
+		if n+1 > i2cTransferSize {
+			want = []int{i2cTransferSize, n + 1 - i2cTransferSize}
+		}
+		if !reflect.DeepEqual(port.reads, want) {
+			t.Errorf("%d bytes: got reads of %v bytes, want %v", n, port.reads, want)
+		}
+		for _, w := range port.writes {
+			if w[len(w)-1] != cmdSendImmediate {
+				t.Errorf("%d bytes: round trip does not end with SEND_IMMEDIATE", n)
+			}
+		}
+	}
+}
+
+func TestI2CBuilderNack(t *testing.T) {
+	// The second data byte of the second message is NACKed. The whole
+	// transaction still runs, and the NACK is reported unless the message
+	// ignores NACKs.
+	in := []byte{0, 0, 0, 0, 1}
+	msgs := []I2CMsg{
+		{Addr: 0x20, Buf: []byte{1}},
+		{Addr: 0x21, Buf: []byte{2, 3}},
+	}
+
+	port := &fakeRawPort{in: in}
+	ack, err := runI2C(port, msgs)
+	want := &I2CNackError{Addr: 0x21, Msg: 1, Byte: 2}
+	if !reflect.DeepEqual(err, want) {
+		t.Errorf("got error %v, want %v", err, want)
+	}
+	if ack != NACK {
+		t.Errorf("got last ACK %d, want NACK", ack)
+	}
+	if got := port.writes[0]; !bytes.HasSuffix(got, cmds(cStop, []byte{cmdSendImmediate})) {
+		t.Errorf("transaction was not completed: % X", got)
+	}
+
+	msgs[1].Flags |= I2CMsgIgnoreNak
+	if _, err := runI2C(&fakeRawPort{in: in}, msgs); err != nil {
+		t.Errorf("I2CMsgIgnoreNak: got error %v", err)
+	}
+}
+
+func TestI2CBuilderAbort(t *testing.T) {
+	// The first round trip of a long write fails; a stop condition is sent
+	// on its own.
+	port := &fakeRawPort{in: make([]byte, 128), failWrite: 1}
+	if _, err := runI2C(port, []I2CMsg{{Addr: 0x50, Buf: make([]byte, 100)}}); err == nil {
+		t.Fatal("got no error")
+	}
+	if len(port.writes) != 2 || !bytes.Equal(port.writes[1], cStop) {
+		t.Errorf("got commands after the failure % X, want a stop % X", port.writes[1:], cStop)
+	}
+}
+
+func TestWriteI2C(t *testing.T) {
+	// The third and fourth bytes are NACKed. All of the bytes are sent in
+	// one batch, and the first NACK is reported.
+	port := &fakeI2CWritePort{fakeRawPort: fakeRawPort{in: []byte{0, 0, 1, 1, 0}}}
+	err := writeI2C(port, []byte{1, 2, 3, 4, 5}, false)
+	if want := (&I2CWriteNackError{Byte: 2}); !reflect.DeepEqual(err, want) {
+		t.Errorf("got error %v, want %v", err, want)
+	}
+	want := cmds(cWrite(1), cWrite(2), cWrite(3), cWrite(4), cWrite(5), []byte{cmdSendImmediate})
+	if len(port.writes) != 1 || !bytes.Equal(port.writes[0], want) {
+		t.Errorf("got commands % X\nwant % X", port.writes, want)
+	}
+	if port.ack != ACK {
+		t.Errorf("got last ACK %d, want the ACK of the last byte", port.ack)
+	}
+}
+
+func TestWriteI2CAbortOnNack(t *testing.T) {
+	// Each byte is sent on its own, and the bytes after the first NACK are
+	// not sent.
+	port := &fakeI2CWritePort{fakeRawPort: fakeRawPort{in: []byte{0, 1, 0}}}
+	err := writeI2C(port, []byte{1, 2, 3}, true)
+	if want := (&I2CWriteNackError{Byte: 1}); !reflect.DeepEqual(err, want) {
+		t.Errorf("got error %v, want %v", err, want)
+	}
+	want := [][]byte{
+		cmds(cWrite(1), []byte{cmdSendImmediate}),
+		cmds(cWrite(2), []byte{cmdSendImmediate}),
+	}
+	if !reflect.DeepEqual(port.writes, want) {
+		t.Errorf("got commands % X\nwant % X", port.writes, want)
+	}
+	if port.ack != NACK {
+		t.Errorf("got last ACK %d, want NACK", port.ack)
+	}
+}
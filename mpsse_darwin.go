@@ -97,6 +97,16 @@ const (
 	GPIOH7 GPIOPin = 11
 )
 
+// Direction is the input/output direction of a GPIO pin. These values
+// match up with the values defined in the C implementation.
+type Direction int
+
+// Supported pin directions.
+const (
+	Input  Direction = 0
+	Output Direction = 1
+)
+
 // Iface is the FTDI interface that should be used. These values match up with
 // the values defined in the C implementation.
 type Iface int
@@ -116,7 +126,24 @@ const (
 type Mpsse struct {
 	ctx  *C.struct_mpsse_context
 	open bool
+
+	// lock serializes access to the C context and the device. The
+	// wrappers take it around each call into the C library, and
+	// operations made up of several USB round trips, such as
+	// I2CTransfer, hold it throughout.
 	lock sync.Mutex
+
+	// abortOnNack stops I2C writes at the first NACKed byte.
+	abortOnNack bool
+
+	// watcher polls the pins watched with WatchPin.
+	watcher pinWatcher
+
+	// trace is the trace started by StartTrace, if any.
+	trace *Trace
+
+	// pins is the pin map set by SetPinMap, if any.
+	pins *PinMap
 }
 
 // ok is a helper function to check if the response status of an MPSSE command
@@ -132,7 +159,7 @@ func ok(status int) bool {
 func MPSSE(mode Mode, frequency Frequency, endianess Endianess) (*Mpsse, error) {
 
 	ctx := C.MPSSE(C.enum_modes(mode), C.int(frequency), C.int(endianess))
-	d := &Mpsse{ctx, true, sync.Mutex{}}
+	d := &Mpsse{ctx: ctx, open: true}
 
 	// on success, mpsse->open will be set to 1. on failure, mpsse-open will be
 	// set to 0.
@@ -189,7 +216,7 @@ func OpenIndex(vid int, pid int, mode Mode, frequency Frequency, endianess Endia
 		C.int(index),
 	)
 
-	d := &Mpsse{ctx, true, sync.Mutex{}}
+	d := &Mpsse{ctx: ctx, open: true}
 
 	// on success, mpsse->open will be set to 1. on failure, mpsse-open will be
 	// set to 0.
@@ -201,11 +228,16 @@ func OpenIndex(vid int, pid int, mode Mode, frequency Frequency, endianess Endia
 }
 
 // Close closes the device, deinitializes libftdi, and frees the MPSSE
-// context pointer.
+// context pointer. Any pins being watched stop being polled first.
 //
 // It is a wrapper for the mpsse C function:
 //     void Close(struct mpsse_context *mpsse);
 func (m *Mpsse) Close() {
+	m.stopWatching()
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
 	C.Close(m.ctx)
 	m = nil // Force error / panic if a client tries to use a deallocated / closed Mpsse.
 }
@@ -219,11 +251,25 @@ func (m *Mpsse) ErrorString() string {
 }
 
 // SetMode sets the appropriate transmit and receive commands based on the
-// requested mode and byte order.
+// requested mode and byte order. All of the pins are reset, after which
+// the default pin states of the pin map set by SetPinMap, if any, are
+// applied.
 //
 // It is a wrapper for the mpsse C function:
 //     int SetMode(struct mpsse_context *mpsse, int endianess);
 func (m *Mpsse) SetMode(endianess Endianess) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if err := m.setMode(endianess); err != nil {
+		return err
+	}
+	return m.applyPinMap()
+}
+
+// setMode sets the transmit and receive commands and resets the pins,
+// without applying the pin map. The caller must hold the lock.
+func (m *Mpsse) setMode(endianess Endianess) error {
 	status := int(C.SetMode(m.ctx, C.int(endianess)))
 
 	if !ok(status) {
@@ -238,6 +284,9 @@ func (m *Mpsse) SetMode(endianess Endianess) error {
 // It is a wrapper for the mpsse C function:
 //     void EnableBitmode(struct mpsse_context *mpsse, int tf);
 func (m *Mpsse) EnableBitmode(tf int) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
 	C.EnableBitmode(m.ctx, C.int(tf))
 }
 
@@ -246,6 +295,9 @@ func (m *Mpsse) EnableBitmode(tf int) {
 // It is a wrapper for the mpsse C function:
 //     int SetClock(struct mpsse_context *mpsse, uint32_t freq);
 func (m *Mpsse) SetClock(freq uint32) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
 	status := int(C.SetClock(m.ctx, (C.uint32_t)(freq)))
 
 	if !ok(status) {
@@ -259,6 +311,14 @@ func (m *Mpsse) SetClock(freq uint32) error {
 // It is a wrapper for the mpsse C function:
 //     int GetClock(struct mpsse_context *mpsse);
 func (m *Mpsse) GetClock() int {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	return m.getClock()
+}
+
+// getClock is GetClock for callers that hold the lock.
+func (m *Mpsse) getClock() int {
 	return int(C.GetClock(m.ctx))
 }
 
@@ -291,6 +351,9 @@ func (m *Mpsse) GetDescription() string {
 // It is a wrapper for the mpsse C function:
 //     int SetLoopback(struct mpsse_context *mpsse, int enable);
 func (m *Mpsse) SetLoopback(enable int) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
 	status := int(C.SetLoopback(m.ctx, C.int(enable)))
 
 	if !ok(status) {
@@ -299,12 +362,38 @@ func (m *Mpsse) SetLoopback(enable int) error {
 	return nil
 }
 
+// SetClockStretching enables or disables I2C clock stretching. When
+// enabled, the FTDI chip's adaptive clocking waits for slaves that hold
+// SCL low. GPIOL3 must be wired to SCL, and is made an input while clock
+// stretching is enabled; disabling it restores the direction GPIOL3 had
+// before. Clock stretching is only supported by the FT232H, FT2232H and
+// FT4232H, and the setting persists across calls to SetMode.
+//
+// For use in I2C mode only.
+//
+// It is a wrapper for the mpsse C function:
+//     int SetClockStretching(struct mpsse_context *mpsse, int enable);
+func (m *Mpsse) SetClockStretching(enable int) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	status := int(C.SetClockStretching(m.ctx, C.int(enable)))
+
+	if !ok(status) {
+		return &MpsseError{m.ErrorString()}
+	}
+	return nil
+}
+
 // SetCSIdle sets the idle state of the chip select pin. CS idles high
 // by default.
 //
 // It is a wrapper for the mpsse C function:
 //     void SetCSIdle(struct mpsse_context *mpsse, int idle);
 func (m *Mpsse) SetCSIdle(idle int) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
 	C.SetCSIdle(m.ctx, C.int(idle))
 }
 
@@ -313,6 +402,14 @@ func (m *Mpsse) SetCSIdle(idle int) {
 // It is a wrapper for the mpsse C function:
 //     int Start(struct mpsse_context *mpsse);
 func (m *Mpsse) Start() error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	return m.start()
+}
+
+// start is Start for callers that hold the lock.
+func (m *Mpsse) start() error {
 	status := int(C.Start(m.ctx))
 
 	if !ok(status) {
@@ -323,9 +420,26 @@ func (m *Mpsse) Start() error {
 
 // Write sends data out via the selected serial protocol.
 //
+// In I2C mode, the ACK bit of every byte is read back and an
+// *I2CWriteNackError is returned for the first byte that was not
+// acknowledged; see AbortOnNack.
+//
 // It is a wrapper for the mpsse C function:
 //     int Write(struct mpsse_context *mpsse, char *data, int size);
 func (m *Mpsse) Write(data string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	return m.write(data)
+}
+
+// write is Write for callers that hold the lock.
+func (m *Mpsse) write(data string) error {
+	// The C implementation writes I2C data one byte at a time and only
+	// keeps the last ACK bit, so I2C writes are batched here instead.
+	if Mode(m.ctx.mode) == I2C {
+		return m.writeI2C([]byte(data))
+	}
 	dataP := C.CString(data)
 	status := int(C.Write(m.ctx, dataP, C.int(len(data))))
 
@@ -346,6 +460,14 @@ func (m *Mpsse) Write(data string) error {
 // It is a wrapper for the mpsse C function:
 //     int Stop(struct mpsse_context *mpsse);
 func (m *Mpsse) Stop() error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	return m.stop()
+}
+
+// stop is Stop for callers that hold the lock.
+func (m *Mpsse) stop() error {
 	status := int(C.Stop(m.ctx))
 
 	if !ok(status) {
@@ -359,6 +481,9 @@ func (m *Mpsse) Stop() error {
 // It is a wrapper for the mpsse C function:
 //     int GetAck(struct mpsse_context *mpsse);
 func (m *Mpsse) GetAck() int {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
 	return int(C.GetAck(m.ctx))
 }
 
@@ -367,6 +492,9 @@ func (m *Mpsse) GetAck() int {
 // It is a wrapper for the mpsse C function:
 //     void SetAck(struct mpsse_context *mpsse, int ack);
 func (m *Mpsse) SetAck(ack I2CAck) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
 	C.SetAck(m.ctx, C.int(ack))
 }
 
@@ -376,6 +504,9 @@ func (m *Mpsse) SetAck(ack I2CAck) {
 // It is a wrapper for the mpsse C function:
 //     void SendAcks(struct mpsse_context *mpsse);
 func (m *Mpsse) SendAcks() {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
 	C.SendAcks(m.ctx)
 }
 
@@ -385,15 +516,34 @@ func (m *Mpsse) SendAcks() {
 // It is a wrapper for the mpsse C function:
 //     void SendNacks(struct mpsse_context *mpsse);
 func (m *Mpsse) SendNacks() {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
 	C.SendNacks(m.ctx)
 }
 
+// AbortOnNack enables or disables aborting I2C writes at the first byte
+// that is not acknowledged. By default, all of the bytes passed to Write
+// are sent in a single batch and their ACK bits checked afterwards. With
+// aborting enabled, each byte is sent and its ACK bit checked before the
+// next byte is sent, so no bytes are sent after a NACK; the transaction
+// should then be ended with Stop.
+func (m *Mpsse) AbortOnNack(tf int) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	m.abortOnNack = tf != 0
+}
+
 // FlushAfterRead enables or disables flushing of the FTDI chip's RX
 // buffers after each read operation. Flushing is disabled by default.
 //
 // It is a wrapper for the mpsse C function:
 //     void FlushAfterRead(struct mpsse_context *mpsse, int tf);
 func (m *Mpsse) FlushAfterRead(tf int) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
 	C.FlushAfterRead(m.ctx, C.int(tf))
 }
 
@@ -402,6 +552,14 @@ func (m *Mpsse) FlushAfterRead(tf int) {
 // It is a wrapper for the mpsse C function:
 //     int PinHigh(struct mpsse_context *mpsse, int pin);
 func (m *Mpsse) PinHigh(pin GPIOPin) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	return m.pinHigh(pin)
+}
+
+// pinHigh is PinHigh for callers that hold the lock.
+func (m *Mpsse) pinHigh(pin GPIOPin) error {
 	status := int(C.PinHigh(m.ctx, C.int(pin)))
 
 	if !ok(status) {
@@ -415,6 +573,14 @@ func (m *Mpsse) PinHigh(pin GPIOPin) error {
 // It is a wrapper for the mpsse C function:
 //     int PinLow(struct mpsse_context *mpsse, int pin);
 func (m *Mpsse) PinLow(pin GPIOPin) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	return m.pinLow(pin)
+}
+
+// pinLow is PinLow for callers that hold the lock.
+func (m *Mpsse) pinLow(pin GPIOPin) error {
 	status := int(C.PinLow(m.ctx, C.int(pin)))
 
 	if !ok(status) {
@@ -423,12 +589,104 @@ func (m *Mpsse) PinLow(pin GPIOPin) error {
 	return nil
 }
 
+// SetPinDirection sets the input/output direction of a GPIO pin. Not for
+// use in BITBANG mode. The direction of the GPIOL pins can only be changed
+// while no transaction is in progress. SetMode makes all of the GPIO pins
+// outputs again.
+//
+// It is a wrapper for the mpsse C function:
+//     int SetPinDirection(struct mpsse_context *mpsse, int pin, int direction);
+func (m *Mpsse) SetPinDirection(pin GPIOPin, dir Direction) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	return m.setPinDirection(pin, dir)
+}
+
+// setPinDirection is SetPinDirection for callers that hold the lock.
+func (m *Mpsse) setPinDirection(pin GPIOPin, dir Direction) error {
+	status := int(C.SetPinDirection(m.ctx, C.int(pin), C.int(dir)))
+
+	if !ok(status) {
+		return &MpsseError{m.ErrorString()}
+	}
+	return nil
+}
+
+// ReadPin reads the level of a GPIO pin, whether it is an input or an
+// output. Not for use in BITBANG mode.
+//
+// It is a wrapper for the mpsse C function:
+//     int ReadPin(struct mpsse_context *mpsse, int pin);
+func (m *Mpsse) ReadPin(pin GPIOPin) (bool, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	return m.readPin(pin)
+}
+
+// readPin is ReadPin for callers that hold the lock.
+func (m *Mpsse) readPin(pin GPIOPin) (bool, error) {
+	state := int(C.ReadPin(m.ctx, C.int(pin)))
+
+	if state == MpsseFail {
+		return false, &MpsseError{m.ErrorString()}
+	}
+	return state == 1, nil
+}
+
+// WriteGPIO sets the GPIO pins in mask to the corresponding bits of value,
+// where bit n is GPIO pin n (GPIOL0 - GPIOH7). The low and high pins are
+// updated by a single command pair in one USB write, so the pins change
+// together. Not for use in BITBANG mode. As with PinHigh and PinLow, the
+// GPIOL pins can only be changed while no transaction is in progress. Bits
+// of mask above GPIOH7 are ignored.
+//
+// It is a wrapper for the mpsse C function:
+//     int WriteGPIO(struct mpsse_context *mpsse, uint16_t mask, uint16_t value);
+func (m *Mpsse) WriteGPIO(mask, value uint16) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	return m.writeGPIO(mask, value)
+}
+
+// writeGPIO is WriteGPIO for callers that hold the lock.
+func (m *Mpsse) writeGPIO(mask, value uint16) error {
+	status := int(C.WriteGPIO(m.ctx, C.uint16_t(mask), C.uint16_t(value)))
+
+	if !ok(status) {
+		return &MpsseError{m.ErrorString()}
+	}
+	return nil
+}
+
+// ReadGPIO reads the levels of all of the GPIO pins, where bit n is GPIO
+// pin n (GPIOL0 - GPIOH7). Not for use in BITBANG mode.
+//
+// It is a wrapper for the mpsse C function:
+//     int ReadGPIO(struct mpsse_context *mpsse);
+func (m *Mpsse) ReadGPIO() (uint16, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	state := int(C.ReadGPIO(m.ctx))
+
+	if state == MpsseFail {
+		return 0, &MpsseError{m.ErrorString()}
+	}
+	return uint16(state), nil
+}
+
 // SetDirection sets ths input/output direction of all pins. For use in
 // BITBANG mode only.
 //
 // It is a wrapper for the mpsse C function:
 //     int SetDirection(struct mpsse_context *mpsse, uint8_t direction);
 func (m *Mpsse) SetDirection(direction uint8) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
 	status := int(C.SetDirection(m.ctx, (C.uint8_t)(direction)))
 
 	if !ok(status) {
@@ -450,11 +708,16 @@ func (m *Mpsse) WriteBits() {}
 func (m *Mpsse) ReadBits() {}
 
 // WritePins sets the input/output value of all pins. For use in BITBANG
-// mode only.
+// mode only. In synchronous bitbang the pin state sampled by the write is
+// read back and discarded, so that it is not returned by the next
+// TransferPattern.
 //
 // It is a wrapper for the mpsse C function:
 //     int WritePins(struct mpsse_context *mpsse, uint8_t data);
 func (m *Mpsse) WritePins(data uint8) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
 	status := int(C.WritePins(m.ctx, (C.uint8_t)(data)))
 
 	if !ok(status) {
@@ -469,6 +732,14 @@ func (m *Mpsse) WritePins(data uint8) error {
 // It is a wrapper for the mpsse C function:
 //     int ReadPins(struct mpsse_context *mpsse);
 func (m *Mpsse) ReadPins() int {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	return m.readPins()
+}
+
+// readPins is ReadPins for callers that hold the lock.
+func (m *Mpsse) readPins() int {
 	return int(C.ReadPins(m.ctx))
 }
 
@@ -478,14 +749,116 @@ func (m *Mpsse) ReadPins() int {
 // It is a wrapper for the mpsse C function:
 //     int PinState(struct mpsse_context *mpsse, int pin, int state);
 func (m *Mpsse) PinState(pin, state int) int {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
 	return int(C.PinState(m.ctx, C.int(pin), C.int(state)))
 }
 
+// SetBitbangRate sets the rate at which bytes written by WritePattern are
+// clocked out to the pins. For use in BITBANG mode only. The rate is set
+// through the chip's baud rate generator, so the actual rate is
+// approximate and depends on the chip.
+//
+// It is a wrapper for the mpsse C function:
+//     int SetBitbangRate(struct mpsse_context *mpsse, int rate);
+func (m *Mpsse) SetBitbangRate(rate int) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	return m.setBitbangRate(rate)
+}
+
+// setBitbangRate is SetBitbangRate for callers that hold the lock.
+func (m *Mpsse) setBitbangRate(rate int) error {
+	status := int(C.SetBitbangRate(m.ctx, C.int(rate)))
+
+	if !ok(status) {
+		return &MpsseError{m.ErrorString()}
+	}
+	return nil
+}
+
+// SetSyncBitbang enables or disables synchronous bitbang. In synchronous
+// bitbang, the pins are sampled each time a byte is written to them, and
+// the samples are returned by TransferPattern. For use in BITBANG mode
+// only.
+//
+// It is a wrapper for the mpsse C function:
+//     int SetSyncBitbang(struct mpsse_context *mpsse, int enable);
+func (m *Mpsse) SetSyncBitbang(enable int) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	return m.setSyncBitbang(enable)
+}
+
+// setSyncBitbang is SetSyncBitbang for callers that hold the lock.
+func (m *Mpsse) setSyncBitbang(enable int) error {
+	status := int(C.SetSyncBitbang(m.ctx, C.int(enable)))
+
+	if !ok(status) {
+		return &MpsseError{m.ErrorString()}
+	}
+	return nil
+}
+
+// WritePattern writes a pattern of pin states, one byte per step, at the
+// rate set by SetBitbangRate. For use in BITBANG mode only. In synchronous
+// bitbang the sampled pin states are discarded; use TransferPattern to
+// keep them.
+//
+// It is a wrapper for the mpsse C function:
+//     int WritePattern(struct mpsse_context *mpsse, unsigned char *data, int size);
+func (m *Mpsse) WritePattern(data []byte) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if len(data) == 0 {
+		return nil
+	}
+
+	status := int(C.WritePattern(m.ctx, (*C.uchar)(unsafe.Pointer(&data[0])), C.int(len(data))))
+
+	if !ok(status) {
+		return &MpsseError{m.ErrorString()}
+	}
+	return nil
+}
+
+// TransferPattern writes a pattern of pin states and returns the pin
+// states sampled at each step. Each byte returned is sampled just before
+// the corresponding byte is written to the pins. For use in synchronous
+// BITBANG mode only.
+//
+// It is a wrapper for the mpsse C function:
+//     int TransferPattern(struct mpsse_context *mpsse, unsigned char *wdata, unsigned char *rdata, int size);
+func (m *Mpsse) TransferPattern(data []byte) ([]byte, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	buf := make([]byte, len(data))
+	if len(data) == 0 {
+		return buf, nil
+	}
+
+	status := int(C.TransferPattern(m.ctx, (*C.uchar)(unsafe.Pointer(&data[0])),
+		(*C.uchar)(unsafe.Pointer(&buf[0])), C.int(len(data))))
+
+	if !ok(status) {
+		return nil, &MpsseError{m.ErrorString()}
+	}
+	return buf, nil
+}
+
 // Tristate places all I/O pins into a tristate mode.
 //
 // It is a wrapper for the mpsse C function:
 //     int Tristate(struct mpsse_context *mpsse);
 func (m *Mpsse) Tristate() error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
 	status := int(C.Tristate(m.ctx))
 
 	if !ok(status) {
@@ -494,6 +867,182 @@ func (m *Mpsse) Tristate() error {
 	return nil
 }
 
+// RawWrite writes a buffer of raw MPSSE commands to the FTDI chip.
+//
+// It is a wrapper for the mpsse C function:
+//     int RawWrite(struct mpsse_context *mpsse, unsigned char *buf, int size);
+func (m *Mpsse) RawWrite(buf []byte) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	return m.rawWrite(buf)
+}
+
+// rawWrite is RawWrite for callers that hold the lock.
+//
+// It is a wrapper for the mpsse C function:
+//     int RawWrite(struct mpsse_context *mpsse, unsigned char *buf, int size);
+func (m *Mpsse) rawWrite(buf []byte) error {
+	if len(buf) == 0 {
+		return nil
+	}
+
+	status := int(C.RawWrite(m.ctx, (*C.uchar)(unsafe.Pointer(&buf[0])), C.int(len(buf))))
+
+	if !ok(status) {
+		return &MpsseError{m.ErrorString()}
+	}
+	return nil
+}
+
+// RawRead reads size bytes of data returned by previously written raw
+// MPSSE commands.
+//
+// It is a wrapper for the mpsse C function:
+//     int RawRead(struct mpsse_context *mpsse, unsigned char *buf, int size);
+func (m *Mpsse) RawRead(size int) ([]byte, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	buf := make([]byte, size)
+	if err := m.rawRead(buf); err != nil {
+		return nil, err
+	}
+	return buf, nil
+}
+
+// rawRead fills buf with data returned by previously written raw MPSSE
+// commands. The caller must hold the lock.
+//
+// It is a wrapper for the mpsse C function:
+//     int RawRead(struct mpsse_context *mpsse, unsigned char *buf, int size);
+func (m *Mpsse) rawRead(buf []byte) error {
+	if len(buf) == 0 {
+		return nil
+	}
+
+	status := int(C.RawRead(m.ctx, (*C.uchar)(unsafe.Pointer(&buf[0])), C.int(len(buf))))
+
+	if !ok(status) {
+		return &MpsseError{m.ErrorString()}
+	}
+	return nil
+}
+
+// streamStart starts keeping the given number of USB read requests, each
+// able to hold size bytes, in flight for streamRead. The caller must hold
+// the lock.
+//
+// It is a wrapper for the mpsse C function:
+//     int StreamStart(struct mpsse_context *mpsse, int transfers, int size);
+func (m *Mpsse) streamStart(transfers, size int) error {
+	status := int(C.StreamStart(m.ctx, C.int(transfers), C.int(size)))
+
+	if !ok(status) {
+		return &MpsseError{m.ErrorString()}
+	}
+	return nil
+}
+
+// streamRead fills buf with data returned by previously written raw MPSSE
+// commands, from the read requests started by streamStart. The caller must
+// hold the lock.
+//
+// It is a wrapper for the mpsse C function:
+//     int StreamRead(struct mpsse_context *mpsse, unsigned char *buf, int size);
+func (m *Mpsse) streamRead(buf []byte) error {
+	if len(buf) == 0 {
+		return nil
+	}
+
+	status := int(C.StreamRead(m.ctx, (*C.uchar)(unsafe.Pointer(&buf[0])), C.int(len(buf))))
+
+	if !ok(status) {
+		return &MpsseError{m.ErrorString()}
+	}
+	return nil
+}
+
+// streamStop cancels the read requests started by streamStart. The caller
+// must hold the lock.
+//
+// It is a wrapper for the mpsse C function:
+//     void StreamStop(struct mpsse_context *mpsse);
+func (m *Mpsse) streamStop() {
+	C.StreamStop(m.ctx)
+}
+
+// startTrace starts recording the commands written to the FTDI chip and
+// the data read back in the C context, discarding any previous trace. The
+// caller must hold the lock.
+//
+// It is a wrapper for the mpsse C function:
+//     void StartTrace(struct mpsse_context *mpsse);
+func (m *Mpsse) startTrace() {
+	C.StartTrace(m.ctx)
+}
+
+// stopTrace stops recording and returns the records of the trace. An
+// error is returned if memory ran out while recording, leaving the trace
+// truncated. The caller must hold the lock.
+//
+// It is a wrapper for the mpsse C function:
+//     int StopTrace(struct mpsse_context *mpsse);
+func (m *Mpsse) stopTrace() ([]byte, error) {
+	status := int(C.StopTrace(m.ctx))
+
+	if !ok(status) {
+		return nil, &MpsseError{m.ErrorString()}
+	}
+	return C.GoBytes(unsafe.Pointer(m.ctx.trace_buf), m.ctx.trace_size), nil
+}
+
+// locker returns the lock that serializes access to the C context, for
+// helpers that drive the Mpsse through an interface.
+func (m *Mpsse) locker() sync.Locker {
+	return &m.lock
+}
+
+// state returns a snapshot of the pin states and commands configured in
+// the C context, for use when building raw command buffers. The caller
+// must hold the lock.
+func (m *Mpsse) state() mpsseState {
+	return mpsseState{
+		mode:    Mode(m.ctx.mode),
+		started: m.ctx.status == C.STARTED,
+		pidle:   byte(m.ctx.pidle),
+		pstart:  byte(m.ctx.pstart),
+		pstop:   byte(m.ctx.pstop),
+		tris:    byte(m.ctx.tris),
+		gpioh:   byte(m.ctx.gpioh),
+		trish:   byte(m.ctx.trish),
+		tx:      byte(m.ctx.tx),
+		rx:      byte(m.ctx.rx),
+		txrx:    byte(m.ctx.txrx),
+		tack:    byte(m.ctx.tack),
+
+		syncBitbang:    m.ctx.sync_bitbang != 0,
+		bitbangRate:    int(m.ctx.ftdi.baudrate),
+		flushAfterRead: m.ctx.flush_after_read != 0,
+	}
+}
+
+// setStarted records in the C context whether a start condition has been
+// sent by a raw command buffer, so that Start and Stop behave accordingly.
+func (m *Mpsse) setStarted(started bool) {
+	if started {
+		m.ctx.status = C.STARTED
+	} else {
+		m.ctx.status = C.STOPPED
+	}
+}
+
+// setAck records the last received ACK bit in the C context, so that
+// GetAck reports it after a raw command buffer.
+func (m *Mpsse) setAck(ack I2CAck) {
+	m.ctx.rack = C.uint8_t(ack)
+}
+
 // Version returns the libmpsse version number.
 //
 // It is a wrapper for the mpsse C function:
@@ -505,6 +1054,8 @@ func Version() {}
 // It is a wrapper for the mpsse C function:
 //     char *Read(struct mpsse_context *mpsse, int size);
 func (m *Mpsse) Read(size int) string {
+	m.lock.Lock()
+	defer m.lock.Unlock()
 
 	resp := ""
 
@@ -545,11 +1096,32 @@ func (m *Mpsse) Transfer() {}
 //     int FastWrite(struct mpsse_context *mpsse, char *data, int size);
 func (m *Mpsse) FastWrite() {}
 
-// FastRead is a function for performing fast reads in MPSSE.
+// FastRead is a function for performing fast reads in MPSSE. It fills
+// data with len(data) bytes read directly into the buffer, without the
+// allocation and copy done by Read.
 //
 // It is a wrapper for the mpsse C function:
 //     int FastRead(struct mpsse_context *mpsse, char *data, int size);
-func (m *Mpsse) FastRead() {}
+func (m *Mpsse) FastRead(data []byte) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	return m.fastRead(data)
+}
+
+// fastRead is FastRead for callers that hold the lock.
+func (m *Mpsse) fastRead(data []byte) error {
+	if len(data) == 0 {
+		return nil
+	}
+
+	status := int(C.FastRead(m.ctx, (*C.char)(unsafe.Pointer(&data[0])), C.int(len(data))))
+
+	if !ok(status) {
+		return &MpsseError{m.ErrorString()}
+	}
+	return nil
+}
 
 // FastTransfer is a function to perform fast transfers in MPSSE.
 //
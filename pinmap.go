@@ -0,0 +1,311 @@
+package libmpsse
+
+import (
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// gpioPinNames are the names of the GPIO pins, indexed by GPIOPin.
+var gpioPinNames = []string{
+	"GPIOL0", "GPIOL1", "GPIOL2", "GPIOL3",
+	"GPIOH0", "GPIOH1", "GPIOH2", "GPIOH3", "GPIOH4", "GPIOH5", "GPIOH6", "GPIOH7",
+}
+
+func (p GPIOPin) String() string {
+	if p < GPIOL0 || p > GPIOH7 {
+		return fmt.Sprintf("GPIOPin(%d)", int(p))
+	}
+	return gpioPinNames[p]
+}
+
+// MarshalText encodes the pin as its name, such as "GPIOL0".
+func (p GPIOPin) MarshalText() ([]byte, error) {
+	if p < GPIOL0 || p > GPIOH7 {
+		return nil, fmt.Errorf("invalid GPIO pin %d", p)
+	}
+	return []byte(gpioPinNames[p]), nil
+}
+
+// UnmarshalText decodes a pin name, such as "GPIOL0". Case is ignored.
+func (p *GPIOPin) UnmarshalText(text []byte) error {
+	for i, name := range gpioPinNames {
+		if strings.EqualFold(string(text), name) {
+			*p = GPIOPin(i)
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown GPIO pin %q", text)
+}
+
+// PinDefault is the state that a named pin is put in when its pin map is
+// applied.
+type PinDefault int
+
+// Supported default pin states.
+const (
+	// DefaultNone leaves the pin as it is.
+	DefaultNone PinDefault = iota
+
+	// DefaultDeasserted makes the pin an output and deasserts it.
+	DefaultDeasserted
+
+	// DefaultAsserted makes the pin an output and asserts it.
+	DefaultAsserted
+
+	// DefaultInput makes the pin an input.
+	DefaultInput
+)
+
+// pinDefaultNames are the names of the default pin states in pin map
+// files, indexed by PinDefault.
+var pinDefaultNames = []string{"", "deasserted", "asserted", "input"}
+
+// MarshalText encodes the default state as its name in pin map files.
+func (d PinDefault) MarshalText() ([]byte, error) {
+	if d < DefaultNone || d > DefaultInput {
+		return nil, fmt.Errorf("invalid default pin state %d", d)
+	}
+	return []byte(pinDefaultNames[d]), nil
+}
+
+// UnmarshalText decodes "asserted", "deasserted" or "input", or an empty
+// string for DefaultNone.
+func (d *PinDefault) UnmarshalText(text []byte) error {
+	for i, name := range pinDefaultNames {
+		if strings.EqualFold(string(text), name) {
+			*d = PinDefault(i)
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown default pin state %q", text)
+}
+
+// NamedPin is the GPIO pin that a named signal is wired to.
+type NamedPin struct {
+	Pin GPIOPin `json:"pin" yaml:"pin"`
+
+	// ActiveLow is set if the signal is asserted by driving the pin low.
+	ActiveLow bool `json:"active_low,omitempty" yaml:"active_low,omitempty"`
+
+	// Default is the state the pin is put in when the pin map is applied.
+	Default PinDefault `json:"default,omitempty" yaml:"default,omitempty"`
+}
+
+// level returns the pin level that asserts or deasserts the signal.
+func (p NamedPin) level(asserted bool) bool {
+	return asserted != p.ActiveLow
+}
+
+// PinMap maps the names of the signals on a board, such as "RESET" or
+// "WP", to the GPIO pins they are wired to, so that code can be shared
+// between boards that wire them differently.
+//
+// Pin maps are usually loaded from a board profile in YAML or JSON:
+//
+//	board: example
+//	pins:
+//	  RESET: {pin: GPIOL0, active_low: true, default: deasserted}
+//	  WP:    {pin: GPIOH2, active_low: true, default: asserted}
+//	  READY: {pin: GPIOH3, default: input}
+type PinMap struct {
+	// Board optionally identifies the board that the map describes.
+	Board string `json:"board,omitempty" yaml:"board,omitempty"`
+
+	Pins map[string]NamedPin `json:"pins" yaml:"pins"`
+}
+
+// ParsePinMap parses a pin map in YAML or JSON, which is a subset of YAML.
+// Unknown fields are rejected.
+func ParsePinMap(data []byte) (*PinMap, error) {
+	var pm PinMap
+	if err := yaml.UnmarshalStrict(data, &pm); err != nil {
+		return nil, fmt.Errorf("invalid pin map: %v", err)
+	}
+	if err := pm.validate(); err != nil {
+		return nil, err
+	}
+	return &pm, nil
+}
+
+// LoadPinMap reads a pin map from a YAML or JSON file.
+func LoadPinMap(path string) (*PinMap, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParsePinMap(data)
+}
+
+// Lookup returns the pin that the signal name is wired to.
+func (pm *PinMap) Lookup(name string) (NamedPin, bool) {
+	p, ok := pm.Pins[name]
+	return p, ok
+}
+
+// names returns the names of the signals in the map, sorted.
+func (pm *PinMap) names() []string {
+	names := make([]string, 0, len(pm.Pins))
+	for name := range pm.Pins {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// validate checks that the map's pins and default states are valid, and
+// that no two signals are wired to the same pin.
+func (pm *PinMap) validate() error {
+	used := make(map[GPIOPin]string)
+	for _, name := range pm.names() {
+		p := pm.Pins[name]
+		if name == "" {
+			return fmt.Errorf("pin map has a pin with no name")
+		}
+		if p.Pin < GPIOL0 || p.Pin > GPIOH7 {
+			return fmt.Errorf("invalid GPIO pin %d for %q", p.Pin, name)
+		}
+		if p.Default < DefaultNone || p.Default > DefaultInput {
+			return fmt.Errorf("invalid default pin state %d for %q", p.Default, name)
+		}
+		if other, ok := used[p.Pin]; ok {
+			return fmt.Errorf("%q and %q are both mapped to %s", other, name, p.Pin)
+		}
+		used[p.Pin] = name
+	}
+	return nil
+}
+
+// SetPinMap sets the pin map used by Assert, Deassert and Asserted, and
+// puts its pins in their default states. SetMode applies the default
+// states again, as it resets all of the pins. A nil map removes the pin
+// map. Pin maps are not supported in BITBANG mode.
+func (m *Mpsse) SetPinMap(pm *PinMap) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if pm != nil {
+		if m.state().mode == BITBANG {
+			return fmt.Errorf("pin maps are not supported in BITBANG mode")
+		}
+		if err := pm.validate(); err != nil {
+			return err
+		}
+	}
+
+	m.pins = pm
+	return m.applyPinMap()
+}
+
+// PinMap returns the pin map set by SetPinMap, or nil if there is none.
+func (m *Mpsse) PinMap() *PinMap {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	return m.pins
+}
+
+// Assert drives the pin of the named signal to its asserted level.
+func (m *Mpsse) Assert(name string) error {
+	return m.writeNamedPin(name, true)
+}
+
+// Deassert drives the pin of the named signal to its deasserted level.
+func (m *Mpsse) Deassert(name string) error {
+	return m.writeNamedPin(name, false)
+}
+
+// Asserted reads the pin of the named signal and reports whether it is at
+// its asserted level.
+func (m *Mpsse) Asserted(name string) (bool, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	p, err := m.namedPin(name)
+	if err != nil {
+		return false, err
+	}
+
+	level, err := m.readPin(p.Pin)
+	if err != nil {
+		return false, err
+	}
+	return level != p.ActiveLow, nil
+}
+
+// namedPin looks up the named signal in the pin map. The caller must hold
+// the lock.
+func (m *Mpsse) namedPin(name string) (NamedPin, error) {
+	if m.pins == nil {
+		return NamedPin{}, fmt.Errorf("no pin map has been set")
+	}
+	p, ok := m.pins.Lookup(name)
+	if !ok {
+		return NamedPin{}, fmt.Errorf("unknown pin name %q", name)
+	}
+	return p, nil
+}
+
+// writeNamedPin asserts or deasserts the named signal.
+func (m *Mpsse) writeNamedPin(name string, asserted bool) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	p, err := m.namedPin(name)
+	if err != nil {
+		return err
+	}
+	if p.Pin <= GPIOL3 && m.state().started {
+		return fmt.Errorf("GPIOL pins can not be changed during a transaction")
+	}
+
+	var value uint16
+	if p.level(asserted) {
+		value = 1 << uint(p.Pin)
+	}
+	return m.writeGPIO(1<<uint(p.Pin), value)
+}
+
+// applyPinMap puts the pins of the pin map, if there is one, in their
+// default states. The output levels are all written at once, before the
+// pins are made outputs, so that they do not glitch. The caller must hold
+// the lock.
+func (m *Mpsse) applyPinMap() error {
+	if m.pins == nil {
+		return nil
+	}
+
+	var mask, value uint16
+	var outputs, inputs []GPIOPin
+	for _, name := range m.pins.names() {
+		p := m.pins.Pins[name]
+		switch p.Default {
+		case DefaultAsserted, DefaultDeasserted:
+			mask |= 1 << uint(p.Pin)
+			if p.level(p.Default == DefaultAsserted) {
+				value |= 1 << uint(p.Pin)
+			}
+			outputs = append(outputs, p.Pin)
+		case DefaultInput:
+			inputs = append(inputs, p.Pin)
+		}
+	}
+
+	if err := m.writeGPIO(mask, value); err != nil {
+		return err
+	}
+	for _, pin := range outputs {
+		if err := m.setPinDirection(pin, Output); err != nil {
+			return err
+		}
+	}
+	for _, pin := range inputs {
+		if err := m.setPinDirection(pin, Input); err != nil {
+			return err
+		}
+	}
+	return nil
+}
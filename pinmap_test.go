@@ -0,0 +1,60 @@
+package libmpsse
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParsePinMap(t *testing.T) {
+	want := &PinMap{
+		Board: "example",
+		Pins: map[string]NamedPin{
+			"RESET": {Pin: GPIOL0, ActiveLow: true, Default: DefaultDeasserted},
+			"READY": {Pin: GPIOH3, Default: DefaultInput},
+		},
+	}
+
+	profiles := map[string]string{
+		"yaml": `
+board: example
+pins:
+  RESET: {pin: GPIOL0, active_low: true, default: deasserted}
+  READY: {pin: gpioh3, default: input}
+`,
+		"json": `{
+	"board": "example",
+	"pins": {
+		"RESET": {"pin": "GPIOL0", "active_low": true, "default": "deasserted"},
+		"READY": {"pin": "GPIOH3", "default": "input"}
+	}
+}`,
+	}
+	for format, data := range profiles {
+		pm, err := ParsePinMap([]byte(data))
+		if err != nil {
+			t.Errorf("%s: %v", format, err)
+			continue
+		}
+		if !reflect.DeepEqual(pm, want) {
+			t.Errorf("%s: got %+v, want %+v", format, pm, want)
+		}
+	}
+}
+
+func TestParsePinMapErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+	}{
+		{"unknown field", "pins: {RESET: {pin: GPIOL0, inverted: true}}"},
+		{"unknown pin", "pins: {RESET: {pin: GPIOL4}}"},
+		{"unknown default", "pins: {RESET: {pin: GPIOL0, default: high}}"},
+		{"shared pin", "pins: {RESET: {pin: GPIOL0}, WP: {pin: GPIOL0}}"},
+	}
+
+	for _, tt := range tests {
+		if _, err := ParsePinMap([]byte(tt.data)); err == nil {
+			t.Errorf("%s: got no error", tt.name)
+		}
+	}
+}
@@ -0,0 +1,70 @@
+package pmbus
+
+import (
+	"fmt"
+	"math"
+)
+
+// Unit is the unit of a value read from a PMBus device.
+type Unit string
+
+// Units of the standard PMBus sensor and limit commands.
+const (
+	Volts     Unit = "V"
+	Amperes   Unit = "A"
+	Watts     Unit = "W"
+	Celsius   Unit = "°C"
+	RPM       Unit = "RPM"
+	Percent   Unit = "%"
+	Kilohertz Unit = "kHz"
+)
+
+// Reading is a decoded value read from a PMBus device.
+type Reading struct {
+	Value float64
+	Unit  Unit
+}
+
+func (r Reading) String() string {
+	return fmt.Sprintf("%g %s", r.Value, r.Unit)
+}
+
+// VOUT_MODE data formats, in bits 7:5 of the VOUT_MODE byte.
+const (
+	voutModeLinear = 0x0
+	voutModeDirect = 0x2
+)
+
+// DirectCoefficients are the DIRECT format coefficients of a command. A
+// value X is sent as Y = (m*X + b) * 10^R.
+type DirectCoefficients struct {
+	M int16
+	B int16
+	R int8
+}
+
+// Decode converts the DIRECT format value v.
+func (c DirectCoefficients) Decode(v uint16) float64 {
+	return (float64(int16(v))*math.Pow10(-int(c.R)) - float64(c.B)) / float64(c.M)
+}
+
+// DecodeLinear11 converts the LINEAR11 format value v, which has a 5-bit
+// two's complement exponent in bits 15:11 and an 11-bit two's complement
+// mantissa in bits 10:0.
+func DecodeLinear11(v uint16) float64 {
+	exponent := int(int16(v) >> 11)
+	mantissa := int(int16(v<<5) >> 5)
+	return math.Ldexp(float64(mantissa), exponent)
+}
+
+// DecodeLinear16 converts the LINEAR16 format value v, which is an unsigned
+// mantissa with the exponent given by the VOUT_MODE byte mode.
+func DecodeLinear16(v uint16, mode byte) (float64, error) {
+	if mode>>5 != voutModeLinear {
+		return 0, fmt.Errorf("VOUT_MODE 0x%02x is not in LINEAR16 format", mode)
+	}
+
+	// The exponent is a 5-bit two's complement number.
+	exponent := int(int8(mode<<3) >> 3)
+	return math.Ldexp(float64(v), exponent), nil
+}
@@ -0,0 +1,77 @@
+package pmbus
+
+import (
+	"testing"
+)
+
+func TestDecodeLinear11(t *testing.T) {
+	tests := []struct {
+		v    uint16
+		want float64
+	}{
+		{0x0000, 0},
+		{0x0802, 4},          // exponent 1, mantissa 2
+		{0xF1F4, 125},        // exponent -2, mantissa 500
+		{0xD2F8, 11.875},     // exponent -6, mantissa 760
+		{0x07FF, -1},         // exponent 0, mantissa -1
+		{0xFFFD, -1.5},       // exponent -1, mantissa -3
+		{0x8400, -0.015625},  // exponent -16, mantissa -1024
+		{0x7BFF, 1023 << 15}, // exponent 15, mantissa 1023
+	}
+
+	for _, tt := range tests {
+		if got := DecodeLinear11(tt.v); got != tt.want {
+			t.Errorf("DecodeLinear11(0x%04X) = %g, want %g", tt.v, got, tt.want)
+		}
+	}
+}
+
+func TestDecodeLinear16(t *testing.T) {
+	tests := []struct {
+		v    uint16
+		mode byte
+		want float64
+	}{
+		{0x0A00, 0x17, 5},               // exponent -9
+		{0x1800, 0x14, 1.5},             // exponent -12
+		{0x0003, 0x01, 6},               // exponent 1
+		{0xFFFF, 0x10, 65535.0 / 65536}, // exponent -16, mantissa is unsigned
+	}
+
+	for _, tt := range tests {
+		got, err := DecodeLinear16(tt.v, tt.mode)
+		if err != nil {
+			t.Errorf("DecodeLinear16(0x%04X, 0x%02X): %v", tt.v, tt.mode, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("DecodeLinear16(0x%04X, 0x%02X) = %g, want %g", tt.v, tt.mode, got, tt.want)
+		}
+	}
+
+	// DIRECT and VID modes can not be decoded as LINEAR16.
+	for _, mode := range []byte{0x40, 0x22} {
+		if got, err := DecodeLinear16(0x1234, mode); err == nil {
+			t.Errorf("DecodeLinear16(0x1234, 0x%02X) = %g, want error", mode, got)
+		}
+	}
+}
+
+func TestDirectDecode(t *testing.T) {
+	tests := []struct {
+		c    DirectCoefficients
+		v    uint16
+		want float64
+	}{
+		{DirectCoefficients{M: 1, B: 0, R: 0}, 42, 42},
+		{DirectCoefficients{M: 1, B: 0, R: 2}, 1234, 12.34},
+		{DirectCoefficients{M: 2, B: 100, R: -1}, 19, 45},
+		{DirectCoefficients{M: 1, B: 0, R: 0}, 0xFFFE, -2}, // Y is signed
+	}
+
+	for _, tt := range tests {
+		if got := tt.c.Decode(tt.v); got != tt.want {
+			t.Errorf("%+v.Decode(%d) = %g, want %g", tt.c, tt.v, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,287 @@
+/*
+Package pmbus is a client for PMBus power supplies, voltage regulators and
+other power management devices attached to an FTDI chip through libmpsse.
+
+Sensor and limit commands are decoded from the PMBus data formats into
+values with units. Output voltage commands follow the format selected by
+VOUT_MODE (LINEAR16 or DIRECT); all other commands are in LINEAR11 format
+unless DIRECT coefficients have been set for them, either from the
+device's datasheet or from its COEFFICIENTS command.
+
+The Mpsse must be opened in I2C mode. Packet Error Checking is enabled
+through the SMBus that the device is accessed through.
+*/
+package pmbus
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/vapor-ware/libmpsse"
+)
+
+// Command is a PMBus command code.
+type Command byte
+
+// Standard PMBus command codes.
+const (
+	Page             Command = 0x00
+	Operation        Command = 0x01
+	OnOffConfig      Command = 0x02
+	ClearFaults      Command = 0x03
+	Phase            Command = 0x04
+	WriteProtect     Command = 0x10
+	Capability       Command = 0x19
+	VoutMode         Command = 0x20
+	VoutCommand      Command = 0x21
+	VoutMax          Command = 0x24
+	Coefficients     Command = 0x30
+	FanConfig12      Command = 0x3A
+	FanCommand1      Command = 0x3B
+	FanCommand2      Command = 0x3C
+	VoutOVFaultLimit Command = 0x40
+	VoutOVWarnLimit  Command = 0x42
+	VoutUVWarnLimit  Command = 0x43
+	VoutUVFaultLimit Command = 0x44
+	IoutOCFaultLimit Command = 0x46
+	IoutOCWarnLimit  Command = 0x4A
+	OTFaultLimit     Command = 0x4F
+	OTWarnLimit      Command = 0x51
+	VinOVFaultLimit  Command = 0x55
+	VinOVWarnLimit   Command = 0x57
+	VinUVWarnLimit   Command = 0x58
+	VinUVFaultLimit  Command = 0x59
+	IinOCWarnLimit   Command = 0x5D
+	PoutOPWarnLimit  Command = 0x6A
+	PinOPWarnLimit   Command = 0x6B
+
+	StatusByte        Command = 0x78
+	StatusWord        Command = 0x79
+	StatusVout        Command = 0x7A
+	StatusIout        Command = 0x7B
+	StatusInput       Command = 0x7C
+	StatusTemperature Command = 0x7D
+	StatusCML         Command = 0x7E
+	StatusOther       Command = 0x7F
+	StatusMfrSpecific Command = 0x80
+	StatusFans12      Command = 0x81
+
+	ReadEIN          Command = 0x86
+	ReadEOUT         Command = 0x87
+	ReadVIN          Command = 0x88
+	ReadIIN          Command = 0x89
+	ReadVCAP         Command = 0x8A
+	ReadVOUT         Command = 0x8B
+	ReadIOUT         Command = 0x8C
+	ReadTemperature1 Command = 0x8D
+	ReadTemperature2 Command = 0x8E
+	ReadTemperature3 Command = 0x8F
+	ReadFanSpeed1    Command = 0x90
+	ReadFanSpeed2    Command = 0x91
+	ReadDutyCycle    Command = 0x94
+	ReadFrequency    Command = 0x95
+	ReadPOUT         Command = 0x96
+	ReadPIN          Command = 0x97
+
+	PMBusRevision Command = 0x98
+	MfrID         Command = 0x99
+	MfrModel      Command = 0x9A
+	MfrRevision   Command = 0x9B
+	MfrLocation   Command = 0x9C
+	MfrDate       Command = 0x9D
+	MfrSerial     Command = 0x9E
+)
+
+// units holds the unit of each sensor and limit command that Read decodes.
+var units = map[Command]Unit{
+	VoutCommand:      Volts,
+	VoutMax:          Volts,
+	VoutOVFaultLimit: Volts,
+	VoutOVWarnLimit:  Volts,
+	VoutUVWarnLimit:  Volts,
+	VoutUVFaultLimit: Volts,
+	IoutOCFaultLimit: Amperes,
+	IoutOCWarnLimit:  Amperes,
+	OTFaultLimit:     Celsius,
+	OTWarnLimit:      Celsius,
+	VinOVFaultLimit:  Volts,
+	VinOVWarnLimit:   Volts,
+	VinUVWarnLimit:   Volts,
+	VinUVFaultLimit:  Volts,
+	IinOCWarnLimit:   Amperes,
+	PoutOPWarnLimit:  Watts,
+	PinOPWarnLimit:   Watts,
+	ReadVIN:          Volts,
+	ReadIIN:          Amperes,
+	ReadVCAP:         Volts,
+	ReadVOUT:         Volts,
+	ReadIOUT:         Amperes,
+	ReadTemperature1: Celsius,
+	ReadTemperature2: Celsius,
+	ReadTemperature3: Celsius,
+	ReadFanSpeed1:    RPM,
+	ReadFanSpeed2:    RPM,
+	ReadDutyCycle:    Percent,
+	ReadFrequency:    Kilohertz,
+	ReadPOUT:         Watts,
+	ReadPIN:          Watts,
+}
+
+// voutCommands are the commands whose data format is set by VOUT_MODE.
+var voutCommands = map[Command]bool{
+	VoutCommand:      true,
+	VoutMax:          true,
+	VoutOVFaultLimit: true,
+	VoutOVWarnLimit:  true,
+	VoutUVWarnLimit:  true,
+	VoutUVFaultLimit: true,
+	ReadVOUT:         true,
+}
+
+// Status is the value of the STATUS_WORD command. The low byte is the
+// value of STATUS_BYTE.
+type Status uint16
+
+// STATUS_WORD bits.
+const (
+	StatusNoneOfTheAbove Status = 0x0001
+	StatusCMLFault       Status = 0x0002
+	StatusTemperatureOT  Status = 0x0004
+	StatusVinUV          Status = 0x0008
+	StatusIoutOC         Status = 0x0010
+	StatusVoutOV         Status = 0x0020
+	StatusOff            Status = 0x0040
+	StatusBusy           Status = 0x0080
+	StatusUnknown        Status = 0x0100
+	StatusOtherFault     Status = 0x0200
+	StatusFans           Status = 0x0400
+	StatusPowerGoodN     Status = 0x0800
+	StatusMfr            Status = 0x1000
+	StatusInputFault     Status = 0x2000
+	StatusIoutPout       Status = 0x4000
+	StatusVoutFault      Status = 0x8000
+)
+
+// Device is a PMBus device.
+type Device struct {
+	bus    *libmpsse.SMBus
+	addr   uint16
+	direct map[Command]DirectCoefficients
+}
+
+// New returns the PMBus device at the 7-bit address addr on bus.
+func New(bus *libmpsse.SMBus, addr uint16) *Device {
+	return &Device{
+		bus:    bus,
+		addr:   addr,
+		direct: make(map[Command]DirectCoefficients),
+	}
+}
+
+// SetCoefficients sets the DIRECT format coefficients of cmd, so that Read
+// decodes its value in DIRECT rather than LINEAR11 format. For output
+// voltage commands, the coefficients are only used when VOUT_MODE selects
+// the DIRECT format.
+func (d *Device) SetCoefficients(cmd Command, c DirectCoefficients) {
+	d.direct[cmd] = c
+}
+
+// ReadCoefficients reads the DIRECT format coefficients that the device
+// uses for values read from cmd, using the COEFFICIENTS command.
+func (d *Device) ReadCoefficients(cmd Command) (DirectCoefficients, error) {
+	data, err := d.bus.BlockProcessCall(d.addr, byte(Coefficients), []byte{byte(cmd), 0x01})
+	if err != nil {
+		return DirectCoefficients{}, err
+	}
+	if len(data) != 5 {
+		return DirectCoefficients{}, fmt.Errorf("COEFFICIENTS returned %d bytes, expected 5", len(data))
+	}
+
+	return DirectCoefficients{
+		M: int16(uint16(data[0]) | uint16(data[1])<<8),
+		B: int16(uint16(data[2]) | uint16(data[3])<<8),
+		R: int8(data[4]),
+	}, nil
+}
+
+// Read reads the sensor or limit command cmd and decodes its value.
+func (d *Device) Read(cmd Command) (Reading, error) {
+	unit, ok := units[cmd]
+	if !ok {
+		return Reading{}, fmt.Errorf("PMBus command 0x%02x is not a sensor or limit", byte(cmd))
+	}
+
+	v, err := d.bus.ReadWordData(d.addr, byte(cmd))
+	if err != nil {
+		return Reading{}, err
+	}
+
+	value, err := d.decode(cmd, v)
+	if err != nil {
+		return Reading{}, err
+	}
+	return Reading{Value: value, Unit: unit}, nil
+}
+
+// VoutMode reads the VOUT_MODE byte, which selects the data format of the
+// output voltage commands.
+func (d *Device) VoutMode() (byte, error) {
+	return d.bus.ReadByteData(d.addr, byte(VoutMode))
+}
+
+// Status reads the STATUS_WORD command.
+func (d *Device) Status() (Status, error) {
+	v, err := d.bus.ReadWordData(d.addr, byte(StatusWord))
+	return Status(v), err
+}
+
+// ReadString reads a block command, such as MFR_ID or MFR_MODEL, as a
+// string. Trailing NUL bytes and spaces are removed.
+func (d *Device) ReadString(cmd Command) (string, error) {
+	data, err := d.bus.ReadBlockData(d.addr, byte(cmd))
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(data), "\x00 "), nil
+}
+
+// SetPage selects the page (output or channel) that subsequent commands
+// apply to.
+func (d *Device) SetPage(page byte) error {
+	return d.bus.WriteByteData(d.addr, byte(Page), page)
+}
+
+// ClearFaults clears all of the fault bits in the status registers.
+func (d *Device) ClearFaults() error {
+	return d.bus.SendByte(d.addr, byte(ClearFaults))
+}
+
+// decode converts the raw value v of cmd into its value, using the data
+// format of the command.
+func (d *Device) decode(cmd Command, v uint16) (float64, error) {
+	c, direct := d.direct[cmd]
+
+	if !voutCommands[cmd] {
+		if direct {
+			return c.Decode(v), nil
+		}
+		return DecodeLinear11(v), nil
+	}
+
+	mode, err := d.VoutMode()
+	if err != nil {
+		return 0, err
+	}
+
+	switch mode >> 5 {
+	case voutModeLinear:
+		return DecodeLinear16(v, mode)
+	case voutModeDirect:
+		if !direct {
+			return 0, fmt.Errorf("no DIRECT coefficients set for PMBus command 0x%02x", byte(cmd))
+		}
+		return c.Decode(v), nil
+	default:
+		return 0, fmt.Errorf("unsupported VOUT_MODE 0x%02x", mode)
+	}
+}
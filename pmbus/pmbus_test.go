@@ -0,0 +1,150 @@
+package pmbus
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/vapor-ware/libmpsse"
+	"github.com/vapor-ware/libmpsse/i2csim"
+)
+
+// newDevice returns a device at 0x40 on a simulated bus, and its
+// registers.
+func newDevice() (*Device, *i2csim.Bus, *i2csim.Registers) {
+	bus := i2csim.NewBus()
+	regs := &i2csim.Registers{}
+	bus.Attach(0x40, regs)
+	return New(libmpsse.NewSMBus(bus), 0x40), bus, regs
+}
+
+// setWord sets the value of a word command.
+func setWord(regs *i2csim.Registers, cmd Command, v uint16) {
+	regs.Regs[cmd] = byte(v)
+	regs.Regs[cmd+1] = byte(v >> 8)
+}
+
+func TestRead(t *testing.T) {
+	d, _, regs := newDevice()
+	setWord(regs, ReadVIN, 0xD2F8)
+	setWord(regs, ReadTemperature2, 19)
+	setWord(regs, ReadVOUT, 0x0A00)
+	regs.Regs[VoutMode] = 0x17
+	d.SetCoefficients(ReadTemperature2, DirectCoefficients{M: 2, B: 100, R: -1})
+
+	tests := []struct {
+		cmd  Command
+		want Reading
+	}{
+		{ReadVIN, Reading{11.875, Volts}},
+		{ReadTemperature2, Reading{45, Celsius}},
+		{ReadVOUT, Reading{5, Volts}},
+	}
+	for _, tt := range tests {
+		got, err := d.Read(tt.cmd)
+		if err != nil {
+			t.Errorf("Read(0x%02X): %v", byte(tt.cmd), err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("Read(0x%02X) = %v, want %v", byte(tt.cmd), got, tt.want)
+		}
+	}
+
+	if r, err := d.Read(MfrID); err == nil {
+		t.Errorf("Read(MFR_ID) = %v, want error", r)
+	}
+}
+
+func TestReadVoutDirect(t *testing.T) {
+	d, _, regs := newDevice()
+	setWord(regs, ReadVOUT, 1234)
+	regs.Regs[VoutMode] = 0x40
+
+	if r, err := d.Read(ReadVOUT); err == nil {
+		t.Errorf("no coefficients: got %v, want error", r)
+	}
+
+	// The coefficients for READ_VOUT are read back with COEFFICIENTS,
+	// which writes the command code and a read flag before reading the
+	// coefficients.
+	copy(regs.Regs[Coefficients+3:], []byte{5, 0x01, 0x00, 0x00, 0x00, 0x02})
+	c, err := d.ReadCoefficients(ReadVOUT)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (DirectCoefficients{M: 1, B: 0, R: 2}); c != want {
+		t.Errorf("ReadCoefficients = %+v, want %+v", c, want)
+	}
+	if got := regs.Regs[Coefficients : Coefficients+3]; got[0] != 2 || got[1] != byte(ReadVOUT) || got[2] != 0x01 {
+		t.Errorf("COEFFICIENTS wrote % X, want 02 8B 01", got)
+	}
+
+	d.SetCoefficients(ReadVOUT, c)
+	r, err := d.Read(ReadVOUT)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (Reading{12.34, Volts}); r != want {
+		t.Errorf("Read(READ_VOUT) = %v, want %v", r, want)
+	}
+}
+
+func TestReadString(t *testing.T) {
+	d, _, regs := newDevice()
+	copy(regs.Regs[MfrModel:], append([]byte{8}, "PSU-1\x00  "...))
+
+	s, err := d.ReadString(MfrModel)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s != "PSU-1" {
+		t.Errorf("got %q, want %q", s, "PSU-1")
+	}
+}
+
+func TestStatus(t *testing.T) {
+	d, _, regs := newDevice()
+	setWord(regs, StatusWord, uint16(StatusOff|StatusVoutFault))
+
+	s, err := d.Status()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s != StatusOff|StatusVoutFault {
+		t.Errorf("got status 0x%04X, want 0x%04X", s, StatusOff|StatusVoutFault)
+	}
+
+	if err := d.SetPage(2); err != nil {
+		t.Fatal(err)
+	}
+	if regs.Regs[Page] != 2 {
+		t.Errorf("got page %d, want 2", regs.Regs[Page])
+	}
+}
+
+func TestErrors(t *testing.T) {
+	d, bus, regs := newDevice()
+
+	// The device does not support VOUT_MODE.
+	bus.Attach(0x40, &i2csim.NackAt{Target: regs, Bytes: []int{1}})
+	_, err := d.Read(ReadVOUT)
+	want := &libmpsse.I2CNackError{Addr: 0x40, Msg: 0, Byte: 1}
+	if !reflect.DeepEqual(err, want) {
+		t.Errorf("got error %v, want %v", err, want)
+	}
+
+	bus.Detach(0x40)
+	_, err = d.Status()
+	want = &libmpsse.I2CNackError{Addr: 0x40, Msg: 0, Byte: 0}
+	if !reflect.DeepEqual(err, want) {
+		t.Errorf("missing device: got error %v, want %v", err, want)
+	}
+
+	bus.Attach(0x40, regs)
+	failed := errors.New("USB transfer failed")
+	bus.FailNext(failed)
+	if err := d.ClearFaults(); err != failed {
+		t.Errorf("got error %v, want %v", err, failed)
+	}
+}
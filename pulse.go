@@ -0,0 +1,131 @@
+package libmpsse
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+// maxN8Cycles is the largest number of clock cycles that a single
+// CLOCK_N8_CYCLES command can wait for.
+const maxN8Cycles = 0x10000 * 8
+
+// PulseStep is one step of a pulse train: the pin is set to Level, then
+// held there for Duration.
+type PulseStep struct {
+	Level    bool
+	Duration time.Duration
+}
+
+// GeneratePulses drives a GPIO pin through the steps of pattern. The whole
+// pattern is compiled into a single buffer of MPSSE commands, with the
+// delays between steps timed by clocking the MPSSE clock for the right
+// number of cycles, and sent in one bulk write. This makes the timing
+// independent of the host, to within one clock period, for pulses from a
+// few clock periods up; very short steps in long patterns may still be
+// stretched if USB can not keep up with the MPSSE.
+//
+// The clock pin (SK) toggles during the delays, so it should not be
+// connected to anything that could mistake this for data; SPI devices
+// ignore the clock while their chip select is deasserted. In I2C mode the
+// clock runs at two thirds of the configured rate, which is taken into
+// account.
+//
+// The pin is left at the level of the last step. GPIOL pins can only be
+// pulsed while no transaction is in progress. The Mpsse stays locked
+// until the pattern has been written and its final level recorded. Not for
+// use in BITBANG mode.
+func (m *Mpsse) GeneratePulses(pin GPIOPin, pattern []PulseStep) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	state := m.state()
+	if state.mode == BITBANG {
+		return fmt.Errorf("pulse generation is not supported in BITBANG mode")
+	}
+	if pin < GPIOL0 || pin > GPIOH7 {
+		return fmt.Errorf("invalid GPIO pin %d", pin)
+	}
+	if pin <= GPIOL3 && state.started {
+		return fmt.Errorf("GPIOL pins can not be pulsed during a transaction")
+	}
+	if len(pattern) == 0 {
+		return nil
+	}
+
+	clock := m.getClock()
+	rate := float64(clock)
+	if state.mode == I2C {
+		// Three phase clocking stretches each cycle to 1.5 periods.
+		rate = rate * 2 / 3
+	}
+	if rate <= 0 {
+		return fmt.Errorf("invalid clock rate %d", clock)
+	}
+
+	cmds, err := pulseCommands(state, pin, pattern, rate)
+	if err != nil {
+		return err
+	}
+	if err := m.rawWrite(cmds); err != nil {
+		return err
+	}
+
+	// Record the final level in the C context, so that later commands
+	// leave the pin where the pattern did.
+	var value uint16
+	if pattern[len(pattern)-1].Level {
+		value = 1 << uint(pin)
+	}
+	return m.writeGPIO(1<<uint(pin), value)
+}
+
+// pulseCommands returns the MPSSE commands that drive pin through the steps
+// of pattern, with the MPSSE clock running at rate cycles per second.
+func pulseCommands(state mpsseState, pin GPIOPin, pattern []PulseStep, rate float64) ([]byte, error) {
+	var cmds []byte
+	for _, step := range pattern {
+		if step.Duration < 0 {
+			return nil, fmt.Errorf("invalid pulse duration %v", step.Duration)
+		}
+
+		if pin <= GPIOL3 {
+			port := setBit(state.pidle, pinGPIO0<<uint(pin), step.Level)
+			cmds = append(cmds, cmdSetBitsLow, port, state.tris)
+		} else {
+			port := setBit(state.gpioh, 1<<uint(pin-GPIOH0), step.Level)
+			cmds = append(cmds, cmdSetBitsHigh, port, state.trish)
+		}
+
+		cycles := int(math.Round(step.Duration.Seconds() * rate))
+		cmds = appendDelay(cmds, cycles)
+	}
+	return cmds, nil
+}
+
+// setBit returns port with the bits in mask set or cleared.
+func setBit(port, mask byte, set bool) byte {
+	if set {
+		return port | mask
+	}
+	return port &^ mask
+}
+
+// appendDelay appends commands that clock the given number of cycles. The
+// cycles are clocked in multiples of 8 with CLOCK_N8_CYCLES, and the
+// remainder with CLOCK_N_CYCLES.
+func appendDelay(cmds []byte, cycles int) []byte {
+	for cycles >= 8 {
+		n := cycles
+		if n > maxN8Cycles {
+			n = maxN8Cycles
+		}
+		n8 := n/8 - 1
+		cmds = append(cmds, cmdClockN8Cycles, byte(n8), byte(n8>>8))
+		cycles -= (n8 + 1) * 8
+	}
+	if cycles > 0 {
+		cmds = append(cmds, cmdClockNCycles, byte(cycles-1))
+	}
+	return cmds
+}
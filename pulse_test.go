@@ -0,0 +1,66 @@
+package libmpsse
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestAppendDelay(t *testing.T) {
+	tests := []struct {
+		cycles int
+		want   []byte
+	}{
+		{0, nil},
+		{7, []byte{cmdClockNCycles, 0x06}},
+		{8, []byte{cmdClockN8Cycles, 0x00, 0x00}},
+		{9, []byte{cmdClockN8Cycles, 0x00, 0x00, cmdClockNCycles, 0x00}},
+		{maxN8Cycles, []byte{cmdClockN8Cycles, 0xFF, 0xFF}},
+		{maxN8Cycles + 1, []byte{cmdClockN8Cycles, 0xFF, 0xFF, cmdClockNCycles, 0x00}},
+		{maxN8Cycles + 16, []byte{cmdClockN8Cycles, 0xFF, 0xFF, cmdClockN8Cycles, 0x01, 0x00}},
+	}
+
+	for _, tt := range tests {
+		if got := appendDelay(nil, tt.cycles); !bytes.Equal(got, tt.want) {
+			t.Errorf("%d cycles: got % X, want % X", tt.cycles, got, tt.want)
+		}
+	}
+}
+
+func TestPulseCommands(t *testing.T) {
+	// SPI0 idles with CS high and the clock low; all of the high byte pins
+	// are outputs and low.
+	state := mpsseState{mode: SPI0, pidle: 0x08, tris: 0xFB, gpioh: 0x00, trish: 0xFF}
+	pattern := []PulseStep{
+		{Level: true, Duration: time.Microsecond},
+		{Level: false, Duration: 8 * time.Microsecond},
+	}
+
+	tests := []struct {
+		pin  GPIOPin
+		want []byte
+	}{
+		{GPIOL1, []byte{
+			cmdSetBitsLow, 0x28, 0xFB, cmdClockNCycles, 0x00,
+			cmdSetBitsLow, 0x08, 0xFB, cmdClockN8Cycles, 0x00, 0x00,
+		}},
+		{GPIOH2, []byte{
+			cmdSetBitsHigh, 0x04, 0xFF, cmdClockNCycles, 0x00,
+			cmdSetBitsHigh, 0x00, 0xFF, cmdClockN8Cycles, 0x00, 0x00,
+		}},
+	}
+
+	for _, tt := range tests {
+		got, err := pulseCommands(state, tt.pin, pattern, 1e6)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, tt.want) {
+			t.Errorf("%v: got % X, want % X", tt.pin, got, tt.want)
+		}
+	}
+
+	if _, err := pulseCommands(state, GPIOL1, []PulseStep{{Duration: -1}}, 1e6); err == nil {
+		t.Error("negative duration: got no error")
+	}
+}
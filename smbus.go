@@ -0,0 +1,238 @@
+package libmpsse
+
+import (
+	"fmt"
+)
+
+// SMBusBlockMax is the maximum number of data bytes in an SMBus block
+// transfer.
+const SMBusBlockMax = 32
+
+// SMBus implements the SMBus protocols on top of an I2C bus. All of the
+// methods take the 7-bit address of the slave device.
+type SMBus struct {
+	bus I2CBus
+
+	// PEC enables Packet Error Checking. A PEC byte is appended to every
+	// write, and every read is expected to end with one, which is checked
+	// against the data received. Quick commands never use PEC.
+	PEC bool
+}
+
+// NewSMBus returns an SMBus that carries its transactions over bus.
+func NewSMBus(bus I2CBus) *SMBus {
+	return &SMBus{bus: bus}
+}
+
+// QuickCommand sends the device's address with the read/write bit set
+// according to read, and no data.
+func (s *SMBus) QuickCommand(addr uint16, read bool) error {
+	msg := I2CMsg{Addr: addr}
+	if read {
+		msg.Flags = I2CMsgRead
+	}
+	return s.bus.I2CTransfer([]I2CMsg{msg})
+}
+
+// SendByte sends a single byte to the device, without a command code.
+func (s *SMBus) SendByte(addr uint16, value byte) error {
+	return s.write(addr, []byte{value})
+}
+
+// ReceiveByte reads a single byte from the device, without a command code.
+func (s *SMBus) ReceiveByte(addr uint16) (byte, error) {
+	data, err := s.read(addr, nil, 1)
+	if err != nil {
+		return 0, err
+	}
+	return data[0], nil
+}
+
+// WriteByteData writes a byte to the device's command cmd.
+func (s *SMBus) WriteByteData(addr uint16, cmd byte, value byte) error {
+	return s.write(addr, []byte{cmd, value})
+}
+
+// ReadByteData reads a byte from the device's command cmd.
+func (s *SMBus) ReadByteData(addr uint16, cmd byte) (byte, error) {
+	data, err := s.read(addr, []byte{cmd}, 1)
+	if err != nil {
+		return 0, err
+	}
+	return data[0], nil
+}
+
+// WriteWordData writes a 16-bit word, least significant byte first, to the
+// device's command cmd.
+func (s *SMBus) WriteWordData(addr uint16, cmd byte, value uint16) error {
+	return s.write(addr, []byte{cmd, byte(value), byte(value >> 8)})
+}
+
+// ReadWordData reads a 16-bit word, sent least significant byte first,
+// from the device's command cmd.
+func (s *SMBus) ReadWordData(addr uint16, cmd byte) (uint16, error) {
+	data, err := s.read(addr, []byte{cmd}, 2)
+	if err != nil {
+		return 0, err
+	}
+	return uint16(data[0]) | uint16(data[1])<<8, nil
+}
+
+// ProcessCall writes a 16-bit word to the device's command cmd and reads
+// back the word the device returns, in a single transaction.
+func (s *SMBus) ProcessCall(addr uint16, cmd byte, value uint16) (uint16, error) {
+	data, err := s.read(addr, []byte{cmd, byte(value), byte(value >> 8)}, 2)
+	if err != nil {
+		return 0, err
+	}
+	return uint16(data[0]) | uint16(data[1])<<8, nil
+}
+
+// WriteBlockData writes a block of up to SMBusBlockMax bytes to the
+// device's command cmd. The block is preceded by its length.
+func (s *SMBus) WriteBlockData(addr uint16, cmd byte, data []byte) error {
+	if len(data) > SMBusBlockMax {
+		return fmt.Errorf("SMBus block of %d bytes exceeds %d bytes", len(data), SMBusBlockMax)
+	}
+	return s.write(addr, append([]byte{cmd, byte(len(data))}, data...))
+}
+
+// ReadBlockData reads a block from the device's command cmd. The device
+// sends the length of the block first, which must be between 1 and
+// SMBusBlockMax.
+func (s *SMBus) ReadBlockData(addr uint16, cmd byte) ([]byte, error) {
+	return s.readBlock(addr, []byte{cmd})
+}
+
+// BlockProcessCall writes a block to the device's command cmd and reads
+// back the block the device returns, in a single transaction. The blocks
+// written and read may not total more than SMBusBlockMax bytes.
+func (s *SMBus) BlockProcessCall(addr uint16, cmd byte, data []byte) ([]byte, error) {
+	if len(data) > SMBusBlockMax-1 {
+		return nil, fmt.Errorf("SMBus block of %d bytes exceeds %d bytes", len(data), SMBusBlockMax-1)
+	}
+
+	block, err := s.readBlock(addr, append([]byte{cmd, byte(len(data))}, data...))
+	if err != nil {
+		return nil, err
+	}
+	if len(data)+len(block) > SMBusBlockMax {
+		return nil, fmt.Errorf("SMBus block process call returned %d bytes for %d bytes written",
+			len(block), len(data))
+	}
+	return block, nil
+}
+
+// write sends data to the device in a single message, followed by the PEC
+// if it is enabled.
+func (s *SMBus) write(addr uint16, data []byte) error {
+	if s.PEC {
+		data = append(data, crc8(0, append([]byte{smbusAddr(addr, false)}, data...)))
+	}
+	return s.bus.I2CTransfer([]I2CMsg{
+		{Addr: addr, Buf: data},
+	})
+}
+
+// read writes out to the device, if it is not empty, then reads n bytes
+// (and the PEC, if it is enabled) after a repeated start.
+func (s *SMBus) read(addr uint16, out []byte, n int) ([]byte, error) {
+	in := make([]byte, n, n+1)
+	if s.PEC {
+		in = in[:n+1]
+	}
+
+	if err := s.transfer(addr, out, in); err != nil {
+		return nil, err
+	}
+	return in[:n], nil
+}
+
+// readBlock writes out to the device, then reads a block, preceded by its
+// length, after a repeated start.
+func (s *SMBus) readBlock(addr uint16, out []byte) ([]byte, error) {
+	in := make([]byte, 1, 1+SMBusBlockMax+1)
+	if s.PEC {
+		in = in[:2]
+	}
+
+	msgs := []I2CMsg{
+		{Addr: addr, Buf: out},
+		{Addr: addr, Flags: I2CMsgRead | I2CMsgRecvLen, Buf: in},
+	}
+	if err := s.bus.I2CTransfer(msgs); err != nil {
+		return nil, err
+	}
+
+	in = msgs[1].Buf
+	if err := s.checkPEC(addr, out, in); err != nil {
+		return nil, err
+	}
+	return in[1 : 1+int(in[0])], nil
+}
+
+// transfer writes out to the device, if it is not empty, then fills in
+// after a repeated start and checks its PEC.
+func (s *SMBus) transfer(addr uint16, out, in []byte) error {
+	var msgs []I2CMsg
+	if len(out) > 0 {
+		msgs = append(msgs, I2CMsg{Addr: addr, Buf: out})
+	}
+	msgs = append(msgs, I2CMsg{Addr: addr, Flags: I2CMsgRead, Buf: in})
+
+	if err := s.bus.I2CTransfer(msgs); err != nil {
+		return err
+	}
+	return s.checkPEC(addr, out, in)
+}
+
+// checkPEC checks the PEC at the end of in, if PEC is enabled, against the
+// bytes of a transaction that wrote out and read in.
+func (s *SMBus) checkPEC(addr uint16, out, in []byte) error {
+	if !s.PEC {
+		return nil
+	}
+
+	var pec byte
+	if len(out) > 0 {
+		pec = crc8(pec, []byte{smbusAddr(addr, false)})
+		pec = crc8(pec, out)
+	}
+	pec = crc8(pec, []byte{smbusAddr(addr, true)})
+	pec = crc8(pec, in[:len(in)-1])
+
+	if received := in[len(in)-1]; received != pec {
+		var cmd byte
+		if len(out) > 0 {
+			cmd = out[0]
+		}
+		return &PECError{Addr: addr, Command: cmd, Received: received, Computed: pec}
+	}
+	return nil
+}
+
+// smbusAddr returns the address byte that is sent on the bus for the
+// 7-bit address addr.
+func smbusAddr(addr uint16, read bool) byte {
+	b := byte(addr << 1)
+	if read {
+		b |= 0x01
+	}
+	return b
+}
+
+// crc8 updates the SMBus PEC, a CRC-8 with the polynomial x^8 + x^2 + x + 1,
+// with data.
+func crc8(crc byte, data []byte) byte {
+	for _, v := range data {
+		crc ^= v
+		for i := 0; i < 8; i++ {
+			if crc&0x80 != 0 {
+				crc = crc<<1 ^ 0x07
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
@@ -0,0 +1,147 @@
+package libmpsse_test
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/vapor-ware/libmpsse"
+	"github.com/vapor-ware/libmpsse/i2csim"
+)
+
+func TestCRC8(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+		want byte
+	}{
+		{"empty", nil, 0x00},
+
+		// The check value of CRC-8/SMBUS.
+		{"check", []byte("123456789"), 0xF4},
+
+		// Read Word from address 0x5A, command 0x8B, returning 0x1234:
+		// the address byte for the write, the command, the address byte
+		// for the read and the data, low byte first.
+		{"read word", []byte{0xB4, 0x8B, 0xB5, 0x34, 0x12}, 0x0C},
+
+		// Write Byte of 0x80 to command 0x01 at address 0x5A.
+		{"write byte", []byte{0xB4, 0x01, 0x80}, 0xDD},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := libmpsse.CRC8(0, tt.data); got != tt.want {
+				t.Errorf("got 0x%02X, want 0x%02X", got, tt.want)
+			}
+
+			// The PEC can be computed over the data in pieces, and the
+			// PEC of the data followed by its PEC is zero.
+			for i := range tt.data {
+				if got := libmpsse.CRC8(libmpsse.CRC8(0, tt.data[:i]), tt.data[i:]); got != tt.want {
+					t.Errorf("split at %d: got 0x%02X, want 0x%02X", i, got, tt.want)
+				}
+			}
+			if got := libmpsse.CRC8(0, append(tt.data, tt.want)); got != 0 {
+				t.Errorf("data and PEC: got 0x%02X, want 0", got)
+			}
+		})
+	}
+}
+
+// newSMBus returns an SMBus on a simulated bus with a register device at
+// 0x5A.
+func newSMBus(pec bool) (*libmpsse.SMBus, *i2csim.Bus, *i2csim.Registers) {
+	bus := i2csim.NewBus()
+	regs := &i2csim.Registers{}
+	bus.Attach(0x5A, regs)
+
+	s := libmpsse.NewSMBus(bus)
+	s.PEC = pec
+	return s, bus, regs
+}
+
+func TestSMBus(t *testing.T) {
+	s, _, regs := newSMBus(false)
+
+	if err := s.WriteWordData(0x5A, 0x10, 0x1234); err != nil {
+		t.Fatal(err)
+	}
+	if got := regs.Regs[0x10:0x12]; !bytes.Equal(got, []byte{0x34, 0x12}) {
+		t.Errorf("WriteWordData wrote % X, want 34 12", got)
+	}
+	if v, err := s.ReadWordData(0x5A, 0x10); err != nil || v != 0x1234 {
+		t.Errorf("ReadWordData: got 0x%04X, %v, want 0x1234", v, err)
+	}
+	if v, err := s.ReadByteData(0x5A, 0x11); err != nil || v != 0x12 {
+		t.Errorf("ReadByteData: got 0x%02X, %v, want 0x12", v, err)
+	}
+
+	if err := s.WriteBlockData(0x5A, 0x20, []byte("abc")); err != nil {
+		t.Fatal(err)
+	}
+	if got := regs.Regs[0x20:0x24]; !bytes.Equal(got, []byte{3, 'a', 'b', 'c'}) {
+		t.Errorf("WriteBlockData wrote % X, want 03 61 62 63", got)
+	}
+	if block, err := s.ReadBlockData(0x5A, 0x20); err != nil || string(block) != "abc" {
+		t.Errorf("ReadBlockData: got %q, %v, want \"abc\"", block, err)
+	}
+
+	// Block lengths of 0 or more than SMBusBlockMax are invalid.
+	for _, n := range []byte{0, libmpsse.SMBusBlockMax + 1} {
+		regs.Regs[0x30] = n
+		if block, err := s.ReadBlockData(0x5A, 0x30); err == nil {
+			t.Errorf("block length %d: got % X, want error", n, block)
+		}
+	}
+	if err := s.WriteBlockData(0x5A, 0x20, make([]byte, libmpsse.SMBusBlockMax+1)); err == nil {
+		t.Error("WriteBlockData of too many bytes: got no error")
+	}
+}
+
+func TestSMBusErrors(t *testing.T) {
+	s, bus, _ := newSMBus(false)
+
+	_, err := s.ReadWordData(0x5B, 0x10)
+	want := &libmpsse.I2CNackError{Addr: 0x5B, Msg: 0, Byte: 0}
+	if !reflect.DeepEqual(err, want) {
+		t.Errorf("missing device: got error %v, want %v", err, want)
+	}
+	if err := s.QuickCommand(0x5B, false); !reflect.DeepEqual(err, want) {
+		t.Errorf("QuickCommand of missing device: got error %v, want %v", err, want)
+	}
+	if err := s.QuickCommand(0x5A, true); err != nil {
+		t.Errorf("QuickCommand: got error %v", err)
+	}
+
+	failed := errors.New("USB transfer failed")
+	bus.FailNext(failed)
+	if err := s.WriteByteData(0x5A, 0x10, 0x01); err != failed {
+		t.Errorf("got error %v, want %v", err, failed)
+	}
+}
+
+func TestSMBusPEC(t *testing.T) {
+	s, _, regs := newSMBus(true)
+
+	// The PEC of the write is sent after the data.
+	if err := s.WriteByteData(0x5A, 0x01, 0x80); err != nil {
+		t.Fatal(err)
+	}
+	if got := regs.Regs[0x01:0x03]; !bytes.Equal(got, []byte{0x80, 0xDD}) {
+		t.Errorf("WriteByteData wrote % X, want 80 DD", got)
+	}
+
+	copy(regs.Regs[0x8B:], []byte{0x34, 0x12, 0x0C})
+	if v, err := s.ReadWordData(0x5A, 0x8B); err != nil || v != 0x1234 {
+		t.Errorf("ReadWordData: got 0x%04X, %v, want 0x1234", v, err)
+	}
+
+	regs.Regs[0x8D] = 0x0D
+	_, err := s.ReadWordData(0x5A, 0x8B)
+	want := &libmpsse.PECError{Addr: 0x5A, Command: 0x8B, Received: 0x0D, Computed: 0x0C}
+	if !reflect.DeepEqual(err, want) {
+		t.Errorf("invalid PEC: got error %v, want %v", err, want)
+	}
+}
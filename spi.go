@@ -0,0 +1,52 @@
+package libmpsse
+
+import (
+	"fmt"
+)
+
+// SPIBus is implemented by anything that can carry SPI transactions, such
+// as an Mpsse opened in one of the SPI modes. Drivers that take an SPIBus
+// rather than an Mpsse can be used with any such bus.
+type SPIBus interface {
+	SPITransaction(out []byte, size int) ([]byte, error)
+}
+
+// SPITransaction asserts the chip select, writes out, then reads size
+// bytes back before deasserting the chip select. The Mpsse stays locked
+// for the whole transaction, so other calls can not interleave with it.
+// If the write or read fails, the chip select is still deasserted.
+//
+// For use in the SPI modes only.
+func (m *Mpsse) SPITransaction(out []byte, size int) ([]byte, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	return m.spiTransaction(out, size)
+}
+
+// spiTransaction is SPITransaction for callers that hold the lock.
+func (m *Mpsse) spiTransaction(out []byte, size int) ([]byte, error) {
+	if mode := m.state().mode; mode < SPI0 || mode > SPI3 {
+		return nil, fmt.Errorf("SPI transactions are only supported in SPI modes")
+	}
+
+	if err := m.start(); err != nil {
+		return nil, err
+	}
+
+	if err := m.write(string(out)); err != nil {
+		m.stop()
+		return nil, err
+	}
+
+	in := make([]byte, size)
+	if err := m.fastRead(in); err != nil {
+		m.stop()
+		return nil, err
+	}
+
+	if err := m.stop(); err != nil {
+		return nil, err
+	}
+	return in, nil
+}
@@ -0,0 +1,267 @@
+package spiflash
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+)
+
+// Verify selects how Program verifies the flash contents after writing.
+type Verify int
+
+// Supported verification methods.
+const (
+	VerifyNone Verify = iota
+	VerifyCRC32
+	VerifySHA256
+)
+
+// Stage identifies the step of Program that a Progress report refers to.
+type Stage int
+
+// Program stages.
+const (
+	StageProgram Stage = iota
+	StageVerify
+)
+
+// String returns the name of the stage.
+func (s Stage) String() string {
+	switch s {
+	case StageProgram:
+		return "program"
+	case StageVerify:
+		return "verify"
+	}
+	return fmt.Sprintf("Stage(%d)", int(s))
+}
+
+// Progress is reported to the ProgramOptions Progress callback as Program
+// works through the image.
+type Progress struct {
+	Stage Stage
+
+	// Done is the number of bytes of the image processed so far in this
+	// stage, and Total is the size of the image.
+	Done  int64
+	Total int64
+}
+
+// ProgramOptions configures Program.
+type ProgramOptions struct {
+	// Verify selects how the flash contents are checked against the image
+	// once programming has finished.
+	Verify Verify
+
+	// Progress, if set, is called after each sector is processed.
+	Progress func(Progress)
+}
+
+// ProgramResult summarizes the work done by Program.
+type ProgramResult struct {
+	// Size is the number of bytes of the image that were programmed.
+	Size int64
+
+	// Unchanged is the number of sectors that already matched the image.
+	Unchanged int
+
+	// Programmed is the number of sectors that were programmed without
+	// being erased, since they only needed bits cleared.
+	Programmed int
+
+	// Erased is the number of sectors that were erased and reprogrammed.
+	Erased int
+
+	// Checksum is the CRC32 (big endian) or SHA-256 of the image, if
+	// verification was requested.
+	Checksum []byte
+}
+
+// Program writes image to the flash, starting at address 0. The current
+// flash contents are read back one sector at a time and only the sectors
+// that differ from the image are touched. Sectors that only need bits
+// cleared are programmed in place; the rest are erased and reprogrammed,
+// skipping pages that are left fully erased. Flash beyond the end of the
+// image is left as is. The flash must not be write protected (see
+// Unprotect).
+//
+// If opts is nil, no verification or progress reporting is done.
+func (f *Flash) Program(image io.ReaderAt, opts *ProgramOptions) (*ProgramResult, error) {
+	if opts == nil {
+		opts = &ProgramOptions{}
+	}
+
+	size, err := f.imageSize(image)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &ProgramResult{Size: size}
+	sector := f.geometry.EraseTypes[0]
+	want := make([]byte, sector.Size)
+	have := make([]byte, sector.Size)
+
+	for addr := int64(0); addr < size; addr += int64(sector.Size) {
+		n := int(size - addr)
+		if n > sector.Size {
+			n = sector.Size
+		}
+
+		if _, err = image.ReadAt(want[:n], addr); err != nil && err != io.EOF {
+			return nil, err
+		}
+		if _, err = f.ReadAt(have, addr); err != nil {
+			return nil, err
+		}
+
+		// Any part of the last sector past the end of the image keeps its
+		// current contents.
+		copy(want[n:], have[n:])
+
+		switch {
+		case bytes.Equal(want, have):
+			result.Unchanged++
+
+		case onlyClearsBits(have, want):
+			if err = f.programDiff(uint32(addr), have, want); err != nil {
+				return nil, err
+			}
+			result.Programmed++
+
+		default:
+			if err = f.Erase(sector, uint32(addr)); err != nil {
+				return nil, err
+			}
+			if err = f.programDiff(uint32(addr), erased(sector.Size), want); err != nil {
+				return nil, err
+			}
+			result.Erased++
+		}
+
+		if opts.Progress != nil {
+			opts.Progress(Progress{Stage: StageProgram, Done: addr + int64(n), Total: size})
+		}
+	}
+
+	if opts.Verify != VerifyNone {
+		result.Checksum, err = f.verify(image, size, opts)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return result, nil
+}
+
+// imageSize determines the size of the image, which must fit in the flash.
+func (f *Flash) imageSize(image io.ReaderAt) (int64, error) {
+	if sized, ok := image.(interface{ Size() int64 }); ok {
+		size := sized.Size()
+		if size > f.geometry.Size {
+			return 0, fmt.Errorf("image of %d bytes is larger than flash (%d bytes)", size, f.geometry.Size)
+		}
+		return size, nil
+	}
+
+	// Without a Size method, probe for the end of the image with reads.
+	probe := make([]byte, 1)
+	if n, _ := image.ReadAt(probe, f.geometry.Size); n > 0 {
+		return 0, fmt.Errorf("image is larger than flash (%d bytes)", f.geometry.Size)
+	}
+
+	buf := make([]byte, f.geometry.EraseTypes[0].Size)
+	var size int64
+	for size < f.geometry.Size {
+		n, err := image.ReadAt(buf, size)
+		size += int64(n)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+	return size, nil
+}
+
+// programDiff programs the pages of a sector at addr whose wanted contents
+// differ from the current contents.
+func (f *Flash) programDiff(addr uint32, have, want []byte) error {
+	pageSize := f.geometry.PageSize
+	for off := 0; off < len(want); off += pageSize {
+		end := off + pageSize
+		if end > len(want) {
+			end = len(want)
+		}
+		if bytes.Equal(have[off:end], want[off:end]) {
+			continue
+		}
+		if err := f.PageProgram(addr+uint32(off), want[off:end]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// verify reads back the first size bytes of the flash and checks that
+// their checksum matches the image's.
+func (f *Flash) verify(image io.ReaderAt, size int64, opts *ProgramOptions) ([]byte, error) {
+	var imageHash, flashHash hash.Hash
+	switch opts.Verify {
+	case VerifyCRC32:
+		imageHash, flashHash = crc32.NewIEEE(), crc32.NewIEEE()
+	case VerifySHA256:
+		imageHash, flashHash = sha256.New(), sha256.New()
+	default:
+		return nil, fmt.Errorf("unsupported verification method %d", opts.Verify)
+	}
+
+	chunk := f.geometry.EraseTypes[0].Size
+	want := make([]byte, chunk)
+	have := make([]byte, chunk)
+
+	for addr := int64(0); addr < size; addr += int64(chunk) {
+		n := int(size - addr)
+		if n > chunk {
+			n = chunk
+		}
+
+		if _, err := image.ReadAt(want[:n], addr); err != nil && err != io.EOF {
+			return nil, err
+		}
+		if _, err := f.ReadAt(have[:n], addr); err != nil {
+			return nil, err
+		}
+		imageHash.Write(want[:n])
+		flashHash.Write(have[:n])
+
+		if opts.Progress != nil {
+			opts.Progress(Progress{Stage: StageVerify, Done: addr + int64(n), Total: size})
+		}
+	}
+
+	sum := imageHash.Sum(nil)
+	if !bytes.Equal(sum, flashHash.Sum(nil)) {
+		return nil, fmt.Errorf("verification failed: flash contents do not match image")
+	}
+	return sum, nil
+}
+
+// onlyClearsBits checks whether want can be programmed over have without
+// an erase, i.e. no bit needs to change from 0 to 1.
+func onlyClearsBits(have, want []byte) bool {
+	for i := range want {
+		if want[i]&^have[i] != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// erased returns the contents of an erased region of the given size.
+func erased(size int) []byte {
+	return bytes.Repeat([]byte{0xFF}, size)
+}
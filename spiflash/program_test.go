@@ -0,0 +1,186 @@
+package spiflash
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"hash/crc32"
+	"reflect"
+	"testing"
+)
+
+// sectorErase is the opcode of the simulated flash's 4KB sector erase.
+const sectorErase = 0x20
+
+// simFlash simulates a SPI NOR flash with 3-byte addresses, 256 byte pages
+// and 4KB sectors.
+type simFlash struct {
+	data   []byte
+	status byte
+
+	// stuck holds bits that can not be cleared, by address.
+	stuck map[int]byte
+
+	// erases and programs hold the address of each sector erase and page
+	// program.
+	erases   []int
+	programs []int
+}
+
+func newSimFlash(size int) *simFlash {
+	return &simFlash{data: bytes.Repeat([]byte{0xFF}, size)}
+}
+
+// flash returns a Flash on the simulated part.
+func (s *simFlash) flash() *Flash {
+	return &Flash{
+		bus: s,
+		geometry: Geometry{
+			Size:        int64(len(s.data)),
+			PageSize:    256,
+			EraseTypes:  []EraseType{{Size: 4096, Opcode: sectorErase}},
+			AddressMode: Address3Byte,
+		},
+		addrLen: 3,
+	}
+}
+
+func (s *simFlash) SPITransaction(out []byte, size int) ([]byte, error) {
+	in := make([]byte, size)
+	addr := 0
+	if len(out) >= 4 {
+		addr = int(out[1])<<16 | int(out[2])<<8 | int(out[3])
+	}
+
+	switch out[0] {
+	case cmdWriteEnable:
+		s.status |= StatusWEL
+	case cmdReadStatus:
+		for i := range in {
+			in[i] = s.status
+		}
+	case cmdRead:
+		copy(in, s.data[addr:])
+	case cmdPageProgram:
+		if s.status&StatusWEL != 0 {
+			s.programs = append(s.programs, addr)
+			page := addr &^ 0xFF
+			for i, b := range out[4:] {
+				a := page + (addr-page+i)%256
+				s.data[a] &= b | s.stuck[a]
+			}
+		}
+		s.status &^= StatusWEL
+	case sectorErase:
+		if s.status&StatusWEL != 0 {
+			s.erases = append(s.erases, addr)
+			sector := addr &^ 0xFFF
+			copy(s.data[sector:sector+4096], erased(4096))
+		}
+		s.status &^= StatusWEL
+	}
+	return in, nil
+}
+
+func TestProgram(t *testing.T) {
+	sim := newSimFlash(3 * 4096)
+	image := make([]byte, len(sim.data))
+	for i := range image {
+		image[i] = byte(i)
+	}
+
+	// Sector 0 already holds the image. Sector 1 has only its second page
+	// differ, and only by bits that are set in the flash. Sector 2 is
+	// all zero, so its image needs bits set.
+	copy(sim.data, image[:2*4096])
+	for i := 4096 + 256; i < 4096+512; i++ {
+		sim.data[i] |= 0x80
+	}
+	for i := 2 * 4096; i < 3*4096; i++ {
+		sim.data[i] = 0x00
+	}
+
+	var progress []Progress
+	opts := &ProgramOptions{
+		Verify:   VerifyCRC32,
+		Progress: func(p Progress) { progress = append(progress, p) },
+	}
+	result, err := sim.flash().Program(bytes.NewReader(image), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(sim.data, image) {
+		t.Error("flash does not hold the image")
+	}
+
+	crc := make([]byte, 4)
+	binary.BigEndian.PutUint32(crc, crc32.ChecksumIEEE(image))
+	want := &ProgramResult{Size: int64(len(image)), Unchanged: 1, Programmed: 1, Erased: 1, Checksum: crc}
+	if !reflect.DeepEqual(result, want) {
+		t.Errorf("got result %+v, want %+v", result, want)
+	}
+
+	// The unchanged sector is skipped, only the differing page of the
+	// second sector is programmed, and only the third sector is erased.
+	if want := []int{2 * 4096}; !reflect.DeepEqual(sim.erases, want) {
+		t.Errorf("got erases at %#x, want %#x", sim.erases, want)
+	}
+	if sim.programs[0] != 4096+256 {
+		t.Errorf("first page program at %#x, want %#x", sim.programs[0], 4096+256)
+	}
+	if len(sim.programs) != 1+16 {
+		t.Errorf("got %d page programs, want %d", len(sim.programs), 1+16)
+	}
+
+	last := progress[len(progress)-1]
+	if last.Stage != StageVerify || last.Done != int64(len(image)) || last.Total != int64(len(image)) {
+		t.Errorf("got final progress %+v", last)
+	}
+}
+
+func TestProgramVerifyFailure(t *testing.T) {
+	for _, verify := range []Verify{VerifyCRC32, VerifySHA256} {
+		sim := newSimFlash(4096)
+		sim.stuck = map[int]byte{100: 0x01}
+		image := make([]byte, 4096)
+
+		_, err := sim.flash().Program(bytes.NewReader(image), &ProgramOptions{Verify: verify})
+		if err == nil {
+			t.Errorf("verify %d: got no error", verify)
+		}
+	}
+
+	// Without the stuck bit, SHA-256 verification succeeds.
+	sim := newSimFlash(4096)
+	image := bytes.Repeat([]byte{0x5A}, 1000)
+	result, err := sim.flash().Program(bytes.NewReader(image), &ProgramOptions{Verify: VerifySHA256})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sum := sha256.Sum256(image); !bytes.Equal(result.Checksum, sum[:]) {
+		t.Errorf("got checksum %x, want %x", result.Checksum, sum)
+	}
+
+	// Flash past the end of the image is left erased.
+	if !bytes.Equal(sim.data[1000:], erased(4096-1000)) {
+		t.Error("flash past the end of the image was changed")
+	}
+}
+
+func TestOnlyClearsBits(t *testing.T) {
+	tests := []struct {
+		have, want []byte
+		ok         bool
+	}{
+		{[]byte{0xFF, 0xFF}, []byte{0x00, 0x5A}, true},
+		{[]byte{0xF0, 0x0F}, []byte{0xF0, 0x0F}, true},
+		{[]byte{0xF0, 0x0F}, []byte{0x10, 0x0F}, true},
+		{[]byte{0xF0, 0x0F}, []byte{0xF0, 0x1F}, false},
+	}
+
+	for _, tt := range tests {
+		if got := onlyClearsBits(tt.have, tt.want); got != tt.ok {
+			t.Errorf("onlyClearsBits(% X, % X): got %v, want %v", tt.have, tt.want, got, tt.ok)
+		}
+	}
+}
@@ -0,0 +1,233 @@
+package spiflash
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// SFDP layout, as defined by JEDEC JESD216.
+const (
+	sfdpSignature       = 0x50444653 // "SFDP"
+	sfdpHeaderSize      = 8
+	sfdpParamHeaderSize = 8
+
+	// The basic flash parameter table has an ID of 0xFF00.
+	basicParamIDLSB = 0x00
+	basicParamIDMSB = 0xFF
+
+	// Number of DWORDs in a JESD216 basic flash parameter table. Tables
+	// this short do not include the page size.
+	basicParamMinLen     = 9
+	basicParamPageLenMin = 11
+)
+
+// errNoSFDP is returned by readSFDP when the flash does not have a valid
+// SFDP table.
+var errNoSFDP = errors.New("flash does not support SFDP")
+
+// AddressMode describes which address widths the flash accepts.
+type AddressMode int
+
+// Supported address modes, matching the SFDP encoding.
+const (
+	Address3Byte      AddressMode = 0
+	Address3Or4Byte   AddressMode = 1
+	Address4Byte      AddressMode = 2
+	addressModeUnused AddressMode = 3
+)
+
+// EraseType is an erase operation supported by the flash.
+type EraseType struct {
+	// Size is the size of the erased region, in bytes.
+	Size int
+
+	// Opcode is the command used to perform the erase.
+	Opcode byte
+}
+
+// Geometry describes the size and layout of a flash.
+type Geometry struct {
+	// Size is the size of the flash, in bytes.
+	Size int64
+
+	// PageSize is the maximum number of bytes that can be programmed with
+	// a single page program command.
+	PageSize int
+
+	// EraseTypes are the erase operations supported by the flash, from
+	// smallest to largest.
+	EraseTypes []EraseType
+
+	// AddressMode is the address width the flash supports.
+	AddressMode AddressMode
+}
+
+// defaultGeometry builds a geometry for flashes that do not support SFDP.
+// Most manufacturers encode the size as a power of two in the capacity
+// byte of the JEDEC ID; 256 byte pages and 4KB/64KB erases are nearly
+// universal.
+func defaultGeometry(id JEDECID) (Geometry, error) {
+	if id.Capacity < 0x10 || id.Capacity > 0x1F {
+		return Geometry{}, fmt.Errorf("unable to determine size of flash with JEDEC ID %s", id)
+	}
+
+	g := Geometry{
+		Size:     1 << id.Capacity,
+		PageSize: 256,
+		EraseTypes: []EraseType{
+			{Size: 4 * 1024, Opcode: 0x20},
+			{Size: 64 * 1024, Opcode: 0xD8},
+		},
+		AddressMode: Address3Or4Byte,
+	}
+	if err := g.validate(); err != nil {
+		return Geometry{}, fmt.Errorf("unable to determine size of flash with JEDEC ID %s: %v", id, err)
+	}
+	return g, nil
+}
+
+// validate checks that the sizes in the geometry are powers of two, and
+// that every erase type divides the flash into whole regions. The
+// programming and erase code relies on both.
+func (g Geometry) validate() error {
+	if !isPowerOfTwo(g.Size) {
+		return fmt.Errorf("flash size %d is not a power of two", g.Size)
+	}
+	if !isPowerOfTwo(int64(g.PageSize)) {
+		return fmt.Errorf("page size %d is not a power of two", g.PageSize)
+	}
+	for _, e := range g.EraseTypes {
+		if !isPowerOfTwo(int64(e.Size)) || int64(e.Size) > g.Size {
+			return fmt.Errorf("invalid erase size %d for flash of %d bytes", e.Size, g.Size)
+		}
+	}
+	return nil
+}
+
+// isPowerOfTwo reports whether n is a positive power of two.
+func isPowerOfTwo(n int64) bool {
+	return n > 0 && n&(n-1) == 0
+}
+
+// readSFDP reads the SFDP header and the basic flash parameter table, and
+// builds the flash geometry from it.
+func (f *Flash) readSFDP() (Geometry, error) {
+	return parseSFDP(f.sfdpRead)
+}
+
+// parseSFDP parses the SFDP header and the basic flash parameter table,
+// reading them with read, and builds the flash geometry from them.
+func parseSFDP(read func(addr uint32, size int) ([]byte, error)) (Geometry, error) {
+	header, err := read(0, sfdpHeaderSize)
+	if err != nil {
+		return Geometry{}, err
+	}
+	if binary.LittleEndian.Uint32(header[0:4]) != sfdpSignature {
+		return Geometry{}, errNoSFDP
+	}
+
+	// The number of parameter headers is zero based.
+	count := int(header[6]) + 1
+	params, err := read(sfdpHeaderSize, count*sfdpParamHeaderSize)
+	if err != nil {
+		return Geometry{}, err
+	}
+
+	for i := 0; i < count; i++ {
+		p := params[i*sfdpParamHeaderSize : (i+1)*sfdpParamHeaderSize]
+		if p[0] != basicParamIDLSB || p[7] != basicParamIDMSB {
+			continue
+		}
+
+		length := int(p[3])
+		pointer := uint32(p[4]) | uint32(p[5])<<8 | uint32(p[6])<<16
+		table, err := read(pointer, length*4)
+		if err != nil {
+			return Geometry{}, err
+		}
+		return parseBasicParams(table)
+	}
+
+	return Geometry{}, errNoSFDP
+}
+
+// sfdpRead reads size bytes of the SFDP table starting at addr. SFDP reads
+// always use a 3-byte address followed by 8 dummy clocks.
+func (f *Flash) sfdpRead(addr uint32, size int) ([]byte, error) {
+	cmd := []byte{cmdReadSFDP, byte(addr >> 16), byte(addr >> 8), byte(addr), 0}
+	return f.transaction(cmd, size)
+}
+
+// parseBasicParams parses a JESD216 basic flash parameter table.
+func parseBasicParams(table []byte) (Geometry, error) {
+	if len(table) < basicParamMinLen*4 {
+		return Geometry{}, fmt.Errorf("SFDP basic parameter table too short (%d bytes)", len(table))
+	}
+
+	dword := func(n int) uint32 {
+		return binary.LittleEndian.Uint32(table[(n-1)*4:])
+	}
+
+	var g Geometry
+
+	// 1st DWORD: address bytes in bits 18:17.
+	g.AddressMode = AddressMode((dword(1) >> 17) & 0x03)
+	if g.AddressMode == addressModeUnused {
+		g.AddressMode = Address3Byte
+	}
+
+	// 2nd DWORD: density in bits. If bit 31 is set, the density is 2^N bits,
+	// otherwise it is N+1 bits.
+	density := dword(2)
+	if density&0x80000000 != 0 {
+		g.Size = (int64(1) << (density & 0x7FFFFFFF)) / 8
+	} else {
+		g.Size = (int64(density) + 1) / 8
+	}
+
+	// 8th and 9th DWORDs: up to four erase types, each with a size
+	// exponent and an opcode. A size of zero means the type is unused.
+	for _, d := range []uint32{dword(8), dword(9)} {
+		for _, shift := range []uint{0, 16} {
+			exp := (d >> shift) & 0xFF
+			if exp == 0 {
+				continue
+			}
+			g.EraseTypes = append(g.EraseTypes, EraseType{
+				Size:   1 << exp,
+				Opcode: byte(d >> (shift + 8)),
+			})
+		}
+	}
+
+	// Older tables may not list the erase types, but the 1st DWORD always
+	// includes the 4KB erase opcode when 4KB erases are supported.
+	if len(g.EraseTypes) == 0 && dword(1)&0x03 == 0x01 {
+		g.EraseTypes = append(g.EraseTypes, EraseType{
+			Size:   4 * 1024,
+			Opcode: byte(dword(1) >> 8),
+		})
+	}
+	if len(g.EraseTypes) == 0 {
+		return Geometry{}, fmt.Errorf("SFDP basic parameter table lists no erase types")
+	}
+	sort.Slice(g.EraseTypes, func(i, j int) bool {
+		return g.EraseTypes[i].Size < g.EraseTypes[j].Size
+	})
+
+	// 11th DWORD: page size is 2^N bytes in bits 7:4. JESD216 (rev 0)
+	// tables do not include it; those parts use 256 byte pages.
+	g.PageSize = 256
+	if len(table) >= basicParamPageLenMin*4 {
+		if exp := (dword(11) >> 4) & 0x0F; exp != 0 {
+			g.PageSize = 1 << exp
+		}
+	}
+
+	if err := g.validate(); err != nil {
+		return Geometry{}, fmt.Errorf("invalid SFDP basic parameter table: %v", err)
+	}
+	return g, nil
+}
@@ -0,0 +1,178 @@
+package spiflash
+
+import (
+	"encoding/binary"
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+// basicParams builds a basic flash parameter table from its DWORDs.
+func basicParams(dwords ...uint32) []byte {
+	table := make([]byte, 4*len(dwords))
+	for i, d := range dwords {
+		binary.LittleEndian.PutUint32(table[4*i:], d)
+	}
+	return table
+}
+
+// w25q16 is the basic flash parameter table of a 16 Mbit flash with 4KB,
+// 32KB and 64KB erases and 256 byte pages.
+var w25q16 = basicParams(
+	0xFFF120E5, // 4KB erase with opcode 0x20, 3-byte addresses
+	0x00FFFFFF, // 16 Mbit
+	0x6B08EB44, 0xBB423B08, 0xFFFFFFFE, 0x0000FFFF, 0xEB40FFFF,
+	0x520F200C,             // 4KB erase with 0x20, 32KB erase with 0x52
+	0x0000D810,             // 64KB erase with 0xD8
+	0x00A60236, 0x2014EA81, // page size 2^8 in the 11th DWORD
+)
+
+func TestParseBasicParams(t *testing.T) {
+	tests := []struct {
+		name  string
+		table []byte
+		want  Geometry
+	}{
+		{
+			name:  "JESD216A",
+			table: w25q16,
+			want: Geometry{
+				Size:     2 * 1024 * 1024,
+				PageSize: 256,
+				EraseTypes: []EraseType{
+					{Size: 4 * 1024, Opcode: 0x20},
+					{Size: 32 * 1024, Opcode: 0x52},
+					{Size: 64 * 1024, Opcode: 0xD8},
+				},
+				AddressMode: Address3Byte,
+			},
+		},
+		{
+			// Densities over 2^31 bits are encoded as a power of two.
+			name: "1 Gbit, 4-byte addresses",
+			table: basicParams(
+				0xFFF420E5, // 4KB erase, 4-byte addresses only
+				0x80000000|30,
+				0, 0, 0, 0, 0,
+				0xDC10210C, // 4KB erase with 0x21, 64KB erase with 0xDC
+				0, 0, 0x00000080,
+			),
+			want: Geometry{
+				Size:     128 * 1024 * 1024,
+				PageSize: 256,
+				EraseTypes: []EraseType{
+					{Size: 4 * 1024, Opcode: 0x21},
+					{Size: 64 * 1024, Opcode: 0xDC},
+				},
+				AddressMode: Address4Byte,
+			},
+		},
+		{
+			// JESD216 rev 0 tables are 9 DWORDs long and may only list
+			// the 4KB erase in the 1st DWORD.
+			name:  "JESD216 without erase types",
+			table: basicParams(0xFFF620E5, 0x07FFFFFF, 0, 0, 0, 0, 0, 0, 0),
+			want: Geometry{
+				Size:     16 * 1024 * 1024,
+				PageSize: 256,
+				EraseTypes: []EraseType{
+					{Size: 4 * 1024, Opcode: 0x20},
+				},
+				AddressMode: Address3Byte, // 0x3 is reserved
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g, err := parseBasicParams(tt.table)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(g, tt.want) {
+				t.Errorf("got %+v, want %+v", g, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseBasicParamsErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		table []byte
+	}{
+		{"too short", w25q16[:8*4]},
+		{"no erase types", basicParams(0xFFF120E4, 0x00FFFFFF, 0, 0, 0, 0, 0, 0, 0)},
+		{"size not a power of two", basicParams(0xFFF120E5, 3*1024*1024*8-1, 0, 0, 0, 0, 0, 0x0000200C, 0)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if g, err := parseBasicParams(tt.table); err == nil {
+				t.Errorf("got %+v, want error", g)
+			}
+		})
+	}
+}
+
+// sfdpImage is an SFDP table with a vendor specific parameter table
+// before the basic flash parameter table.
+func sfdpImage(basic []byte) []byte {
+	image := []byte{
+		'S', 'F', 'D', 'P', 0x06, 0x01, 0x01, 0xFF, // 2 parameter headers
+		0xEF, 0x00, 0x01, 0x04, 0x80, 0x00, 0x00, 0xFF, // vendor table
+		0x00, 0x06, 0x01, byte(len(basic) / 4), 0x30, 0x00, 0x00, 0xFF, // basic table
+	}
+	image = append(image, make([]byte, 0x30-len(image))...)
+	return append(image, basic...)
+}
+
+// reader returns a function that reads from image like sfdpRead.
+func reader(image []byte) func(addr uint32, size int) ([]byte, error) {
+	return func(addr uint32, size int) ([]byte, error) {
+		if int(addr)+size > len(image) {
+			return nil, fmt.Errorf("read of %d bytes at 0x%x past end of SFDP", size, addr)
+		}
+		return image[addr : int(addr)+size], nil
+	}
+}
+
+func TestParseSFDP(t *testing.T) {
+	g, err := parseSFDP(reader(sfdpImage(w25q16)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, _ := parseBasicParams(w25q16)
+	if !reflect.DeepEqual(g, want) {
+		t.Errorf("got %+v, want %+v", g, want)
+	}
+
+	bad := sfdpImage(w25q16)
+	bad[0] = 'X'
+	if _, err := parseSFDP(reader(bad)); err != errNoSFDP {
+		t.Errorf("bad signature: got error %v, want %v", err, errNoSFDP)
+	}
+
+	// Only the vendor table.
+	vendor := sfdpImage(w25q16)
+	vendor[6] = 0
+	if _, err := parseSFDP(reader(vendor)); err != errNoSFDP {
+		t.Errorf("no basic table: got error %v, want %v", err, errNoSFDP)
+	}
+}
+
+func TestDefaultGeometry(t *testing.T) {
+	g, err := defaultGeometry(JEDECID{Manufacturer: 0xEF, MemoryType: 0x40, Capacity: 0x18})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g.Size != 16*1024*1024 {
+		t.Errorf("got size %d, want %d", g.Size, 16*1024*1024)
+	}
+
+	for _, capacity := range []byte{0x00, 0x0F, 0x20, 0xFF} {
+		if _, err := defaultGeometry(JEDECID{Capacity: capacity}); err == nil {
+			t.Errorf("capacity 0x%02x: got no error", capacity)
+		}
+	}
+}
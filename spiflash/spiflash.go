@@ -0,0 +1,400 @@
+/*
+Package spiflash is a driver for SPI NOR flash chips attached to an FTDI
+chip through libmpsse.
+
+The flash geometry (size, page size and erase block sizes) is discovered
+using the chip's Serial Flash Discoverable Parameters (SFDP) table, falling
+back to the JEDEC ID and common defaults for older parts that do not
+implement SFDP. Chips larger than 16MB are switched into 4-byte address
+mode automatically.
+
+The flash is accessed through a libmpsse.SPIBus, such as an Mpsse opened in
+SPI0 or SPI3 mode, MSB first.
+*/
+package spiflash
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/vapor-ware/libmpsse"
+)
+
+// Standard SPI NOR flash commands.
+const (
+	cmdWriteStatus  = 0x01
+	cmdPageProgram  = 0x02
+	cmdRead         = 0x03
+	cmdWriteDisable = 0x04
+	cmdReadStatus   = 0x05
+	cmdWriteEnable  = 0x06
+	cmdReadSFDP     = 0x5A
+	cmdJEDECID      = 0x9F
+	cmdEnter4Byte   = 0xB7
+	cmdChipErase    = 0xC7
+	cmdExit4Byte    = 0xE9
+)
+
+// Status register bits.
+const (
+	// StatusWIP is set while a program, erase or status register write
+	// is in progress.
+	StatusWIP = 0x01
+
+	// StatusWEL is the write enable latch.
+	StatusWEL = 0x02
+
+	// StatusBP is the mask of the block protect bits (BP0-BP2).
+	StatusBP = 0x1C
+
+	// StatusSRWD is the status register write disable bit. When set, the
+	// status register can not be written while the WP# pin is held low.
+	StatusSRWD = 0x80
+)
+
+// Timeouts for operations that are polled for completion. These are
+// well above the maximums listed in the datasheets of common parts.
+const (
+	statusTimeout      = 100 * time.Millisecond
+	pageProgramTimeout = 100 * time.Millisecond
+	eraseTimeout       = 5 * time.Second
+	chipEraseTimeout   = 10 * time.Minute
+
+	pollInterval = time.Millisecond
+)
+
+// threeByteLimit is the size of the largest flash that can be addressed
+// with 3-byte addresses.
+const threeByteLimit = 1 << 24
+
+var (
+	// ErrTimeout is returned when the flash does not finish an operation
+	// within the expected time.
+	ErrTimeout = errors.New("timed out waiting for flash to become ready")
+
+	// ErrWriteProtected is returned when a status register write does not
+	// take effect, which usually means the status register is locked by
+	// the SRWD bit and the WP# pin.
+	ErrWriteProtected = errors.New("status register is write protected")
+)
+
+// JEDECID is the manufacturer and device identification returned by the
+// JEDEC Read Identification (0x9F) command.
+type JEDECID struct {
+	Manufacturer byte
+	MemoryType   byte
+	Capacity     byte
+}
+
+// String returns the JEDEC ID as a hex string, e.g. "ef4018".
+func (id JEDECID) String() string {
+	return fmt.Sprintf("%02x%02x%02x", id.Manufacturer, id.MemoryType, id.Capacity)
+}
+
+// Flash is a SPI NOR flash chip.
+type Flash struct {
+	bus      libmpsse.SPIBus
+	id       JEDECID
+	geometry Geometry
+	addrLen  int
+}
+
+// New identifies the flash attached to the SPI bus and discovers its
+// geometry. If the flash is larger than 16MB it is put into 4-byte address
+// mode; Close should be called when done with the flash to return it to
+// 3-byte address mode.
+func New(bus libmpsse.SPIBus) (*Flash, error) {
+	f := &Flash{
+		bus:     bus,
+		addrLen: 3,
+	}
+
+	id, err := f.ReadID()
+	if err != nil {
+		return nil, err
+	}
+	f.id = id
+
+	geometry, err := f.readSFDP()
+	if err == errNoSFDP {
+		geometry, err = defaultGeometry(id)
+	}
+	if err != nil {
+		return nil, err
+	}
+	f.geometry = geometry
+
+	if geometry.Size > threeByteLimit && geometry.AddressMode != Address4Byte {
+		if err = f.enter4Byte(); err != nil {
+			return nil, err
+		}
+	}
+	if geometry.Size > threeByteLimit || geometry.AddressMode == Address4Byte {
+		f.addrLen = 4
+	}
+
+	return f, nil
+}
+
+// Close returns a flash in 4-byte address mode to 3-byte address mode, so
+// that other hosts (for example a boot ROM) which expect the power-on
+// default can read it. It does not close the underlying bus.
+func (f *Flash) Close() error {
+	if f.addrLen == 4 && f.geometry.AddressMode != Address4Byte {
+		if err := f.command(cmdExit4Byte); err != nil {
+			return err
+		}
+		f.addrLen = 3
+	}
+	return nil
+}
+
+// ID returns the JEDEC ID read when the flash was opened.
+func (f *Flash) ID() JEDECID {
+	return f.id
+}
+
+// Geometry returns the geometry of the flash.
+func (f *Flash) Geometry() Geometry {
+	return f.geometry
+}
+
+// Size returns the size of the flash, in bytes.
+func (f *Flash) Size() int64 {
+	return f.geometry.Size
+}
+
+// ReadID reads the JEDEC manufacturer and device ID.
+func (f *Flash) ReadID() (JEDECID, error) {
+	data, err := f.transaction([]byte{cmdJEDECID}, 3)
+	if err != nil {
+		return JEDECID{}, err
+	}
+	return JEDECID{data[0], data[1], data[2]}, nil
+}
+
+// ReadAt reads len(p) bytes from the flash starting at offset off. It
+// implements io.ReaderAt.
+func (f *Flash) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 || off+int64(len(p)) > f.geometry.Size {
+		return 0, fmt.Errorf("read of %d bytes at 0x%x is outside of flash (size 0x%x)", len(p), off, f.geometry.Size)
+	}
+	if len(p) == 0 {
+		return 0, nil
+	}
+
+	data, err := f.transaction(f.addressed(cmdRead, uint32(off)), len(p))
+	if err != nil {
+		return 0, err
+	}
+	return copy(p, data), nil
+}
+
+// PageProgram programs data into a single page starting at addr. The data
+// must not cross a page boundary, and the target area should have been
+// erased first; programming can only change bits from 1 to 0.
+func (f *Flash) PageProgram(addr uint32, data []byte) error {
+	pageSize := uint32(f.geometry.PageSize)
+	if len(data) == 0 {
+		return nil
+	}
+	if len(data) > int(pageSize) || addr/pageSize != (addr+uint32(len(data))-1)/pageSize {
+		return fmt.Errorf("program of %d bytes at 0x%x crosses a page boundary", len(data), addr)
+	}
+
+	if err := f.writeEnable(); err != nil {
+		return err
+	}
+	if _, err := f.transaction(append(f.addressed(cmdPageProgram, addr), data...), 0); err != nil {
+		return err
+	}
+	return f.WaitReady(pageProgramTimeout)
+}
+
+// Write programs data starting at addr, splitting it into page program
+// operations along page boundaries. Like PageProgram, the target area
+// should have been erased first.
+func (f *Flash) Write(addr uint32, data []byte) error {
+	if int64(addr)+int64(len(data)) > f.geometry.Size {
+		return fmt.Errorf("write of %d bytes at 0x%x is outside of flash (size 0x%x)", len(data), addr, f.geometry.Size)
+	}
+
+	pageSize := uint32(f.geometry.PageSize)
+	for len(data) > 0 {
+		n := pageSize - addr%pageSize
+		if n > uint32(len(data)) {
+			n = uint32(len(data))
+		}
+		if err := f.PageProgram(addr, data[:n]); err != nil {
+			return err
+		}
+		addr += n
+		data = data[n:]
+	}
+	return nil
+}
+
+// EraseSector erases the sector containing addr using the smallest erase
+// type the flash supports (typically 4KB).
+func (f *Flash) EraseSector(addr uint32) error {
+	return f.Erase(f.geometry.EraseTypes[0], addr)
+}
+
+// EraseBlock erases the block containing addr using the largest erase
+// type the flash supports (typically 64KB).
+func (f *Flash) EraseBlock(addr uint32) error {
+	return f.Erase(f.geometry.EraseTypes[len(f.geometry.EraseTypes)-1], addr)
+}
+
+// Erase erases the region of the given erase type containing addr.
+func (f *Flash) Erase(et EraseType, addr uint32) error {
+	if int64(addr) >= f.geometry.Size {
+		return fmt.Errorf("erase at 0x%x is outside of flash (size 0x%x)", addr, f.geometry.Size)
+	}
+
+	addr &^= uint32(et.Size - 1)
+
+	if err := f.writeEnable(); err != nil {
+		return err
+	}
+	if _, err := f.transaction(f.addressed(et.Opcode, addr), 0); err != nil {
+		return err
+	}
+	return f.WaitReady(eraseTimeout)
+}
+
+// EraseChip erases the entire flash.
+func (f *Flash) EraseChip() error {
+	if err := f.writeEnable(); err != nil {
+		return err
+	}
+	if err := f.command(cmdChipErase); err != nil {
+		return err
+	}
+	return f.WaitReady(chipEraseTimeout)
+}
+
+// ReadStatus reads the status register.
+func (f *Flash) ReadStatus() (byte, error) {
+	data, err := f.transaction([]byte{cmdReadStatus}, 1)
+	if err != nil {
+		return 0, err
+	}
+	return data[0], nil
+}
+
+// WriteStatus writes the status register and checks that the write took
+// effect. The WIP and WEL bits are read-only and are ignored.
+func (f *Flash) WriteStatus(status byte) error {
+	if err := f.writeEnable(); err != nil {
+		return err
+	}
+	if _, err := f.transaction([]byte{cmdWriteStatus, status}, 0); err != nil {
+		return err
+	}
+	if err := f.WaitReady(statusTimeout); err != nil {
+		return err
+	}
+
+	current, err := f.ReadStatus()
+	if err != nil {
+		return err
+	}
+	if current&^(StatusWIP|StatusWEL) != status&^(StatusWIP|StatusWEL) {
+		return ErrWriteProtected
+	}
+	return nil
+}
+
+// Protected checks whether any of the block protect bits are set.
+func (f *Flash) Protected() (bool, error) {
+	status, err := f.ReadStatus()
+	if err != nil {
+		return false, err
+	}
+	return status&StatusBP != 0, nil
+}
+
+// Protect sets all of the block protect bits, write protecting the entire
+// flash array.
+func (f *Flash) Protect() error {
+	status, err := f.ReadStatus()
+	if err != nil {
+		return err
+	}
+	return f.WriteStatus(status | StatusBP)
+}
+
+// Unprotect clears the block protect bits and the status register write
+// disable bit so that the entire flash can be programmed and erased.
+func (f *Flash) Unprotect() error {
+	status, err := f.ReadStatus()
+	if err != nil {
+		return err
+	}
+	if status&(StatusBP|StatusSRWD) == 0 {
+		return nil
+	}
+	return f.WriteStatus(status &^ (StatusBP | StatusSRWD))
+}
+
+// WaitReady polls the status register until the write in progress bit
+// clears or the timeout expires.
+func (f *Flash) WaitReady(timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		status, err := f.ReadStatus()
+		if err != nil {
+			return err
+		}
+		if status&StatusWIP == 0 {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return ErrTimeout
+		}
+		time.Sleep(pollInterval)
+	}
+}
+
+// writeEnable sets the write enable latch, which must be done before each
+// program, erase or status register write.
+func (f *Flash) writeEnable() error {
+	return f.command(cmdWriteEnable)
+}
+
+// enter4Byte switches the flash into 4-byte address mode. Some parts
+// require the write enable latch to be set first, so it is set and then
+// cleared again around the mode switch.
+func (f *Flash) enter4Byte() error {
+	if err := f.writeEnable(); err != nil {
+		return err
+	}
+	if err := f.command(cmdEnter4Byte); err != nil {
+		return err
+	}
+	return f.command(cmdWriteDisable)
+}
+
+// command sends a single byte command.
+func (f *Flash) command(cmd byte) error {
+	_, err := f.transaction([]byte{cmd}, 0)
+	return err
+}
+
+// addressed builds a command followed by an address in the flash's
+// current address width.
+func (f *Flash) addressed(cmd byte, addr uint32) []byte {
+	buf := []byte{cmd}
+	for i := f.addrLen - 1; i >= 0; i-- {
+		buf = append(buf, byte(addr>>(uint(i)*8)))
+	}
+	return buf
+}
+
+// transaction writes out while the chip select is asserted, then reads
+// size bytes back before deasserting the chip select.
+func (f *Flash) transaction(out []byte, size int) ([]byte, error) {
+	return f.bus.SPITransaction(out, size)
+}
@@ -188,6 +188,7 @@ struct mpsse_context *OpenIndex(int vid, int pid, enum modes mode, int freq, int
 						/* Skip the setup functions if we're just operating in BITBANG mode */
 						if(ftdi_set_bitmode(&mpsse->ftdi, 0xFF, BITMODE_BITBANG) == 0)
 						{
+							mpsse->bitbang_dir = 0xFF;
 							mpsse->open = 1;
 						}
 					}
@@ -210,6 +211,8 @@ void Close(struct mpsse_context *mpsse)
 {
 	if(mpsse)
 	{
+		StreamStop(mpsse);
+
 		if(mpsse->open)
 		{
 			ftdi_set_bitmode(&mpsse->ftdi, 0, BITMODE_RESET);
@@ -217,6 +220,7 @@ void Close(struct mpsse_context *mpsse)
 			ftdi_deinit(&mpsse->ftdi);
 		}
 
+		free(mpsse->trace_buf);
 		free(mpsse);
 		mpsse = NULL;
 	}
@@ -286,8 +290,18 @@ int SetMode(struct mpsse_context *mpsse, int endianess)
 		/* Send ACKs by default */
 		SetAck(mpsse, ACK);
 
-		/* Ensure adaptive clock is disabled */
-		setup_commands[setup_commands_size++] = DISABLE_ADAPTIVE_CLOCK;
+		/* Adaptive clocking is disabled unless clock stretching has been enabled */
+		if(mpsse->clock_stretching)
+		{
+			/* GPIOL3 is the adaptive clock input, so it can not be driven */
+			mpsse->clock_stretching_tris = mpsse->tris & GPIO3;
+			mpsse->tris &= ~GPIO3;
+			setup_commands[setup_commands_size++] = ENABLE_ADAPTIVE_CLOCK;
+		}
+		else
+		{
+			setup_commands[setup_commands_size++] = DISABLE_ADAPTIVE_CLOCK;
+		}
 
 		switch(mpsse->mode)
 		{
@@ -570,6 +584,68 @@ int SetLoopback(struct mpsse_context *mpsse, int enable)
 	return retval;
 }
 
+/*
+ * Enables / disables I2C clock stretching. When enabled, the FTDI chip's adaptive
+ * clocking is used to wait for slaves that hold SCL low before continuing the clock.
+ * The GPIOL3 pin must be wired to SCL, and is made an input; disabling clock stretching
+ * restores the direction it had before. Only supported by the FT232H, FT2232H and
+ * FT4232H, and only in I2C mode.
+ *
+ * The setting persists across calls to SetMode.
+ *
+ * @mpsse  - MPSSE context pointer.
+ * @enable - Zero to disable clock stretching, 1 to enable clock stretching.
+ *
+ * Returns MPSSE_OK on success.
+ * Returns MPSSE_FAIL on failure.
+ */
+int SetClockStretching(struct mpsse_context *mpsse, int enable)
+{
+	unsigned char buf[1] = { 0 };
+	int retval = MPSSE_FAIL;
+
+	if(is_valid_context(mpsse))
+	{
+		if(mpsse->mode != I2C)
+		{
+			mpsse->ftdi.error_str = "Clock stretching is only supported in I2C mode";
+			return MPSSE_FAIL;
+		}
+
+		if(enable && !mpsse->clock_stretching)
+		{
+			/* Remember the direction of GPIOL3 so that it can be restored */
+			mpsse->clock_stretching_tris = mpsse->tris & GPIO3;
+		}
+		else if(!enable && mpsse->clock_stretching)
+		{
+			mpsse->tris = (mpsse->tris & ~GPIO3) | mpsse->clock_stretching_tris;
+		}
+
+		mpsse->clock_stretching = enable ? 1 : 0;
+
+		if(mpsse->clock_stretching)
+		{
+			mpsse->tris &= ~GPIO3;
+			buf[0] = ENABLE_ADAPTIVE_CLOCK;
+		}
+		else
+		{
+			buf[0] = DISABLE_ADAPTIVE_CLOCK;
+		}
+
+		retval = raw_write(mpsse, buf, 1);
+
+		/* Apply the new GPIOL3 direction */
+		if(retval == MPSSE_OK && mpsse->status == STOPPED)
+		{
+			retval = set_bits_low(mpsse, mpsse->pidle);
+		}
+	}
+
+	return retval;
+}
+
 /*
  * Sets the idle state of the chip select pin. CS idles high by default.
  *
@@ -1139,6 +1215,198 @@ int PinLow(struct mpsse_context *mpsse, int pin)
 	return retval;
 }
 
+/*
+ * Sets the input/output direction of the specified GPIO pin. Not for use in BITBANG mode.
+ * The direction of GPIOL pins can only be changed while no transaction is in progress,
+ * and GPIOL3 can not be made an output while clock stretching is enabled.
+ *
+ * @mpsse     - MPSSE context pointer.
+ * @pin       - Pin number (GPIOL0 - GPIOH7).
+ * @direction - INPUT or OUTPUT.
+ *
+ * Returns MPSSE_OK on success.
+ * Returns MPSSE_FAIL on failure.
+ */
+int SetPinDirection(struct mpsse_context *mpsse, int pin, int direction)
+{
+	int retval = MPSSE_FAIL;
+	uint8_t bit = 0;
+
+	if(is_valid_context(mpsse) && mpsse->mode != BITBANG)
+	{
+		if(pin >= 0 && pin < NUM_GPIOL_PINS && mpsse->status == STOPPED)
+		{
+			/* Convert pin number (0-3) to the corresponding pin bit */
+			bit = (GPIO0 << pin);
+
+			if(direction == OUTPUT && !(bit == GPIO3 && mpsse->clock_stretching))
+			{
+				mpsse->tris |= bit;
+				retval = set_bits_low(mpsse, mpsse->pidle);
+			}
+			else if(direction == INPUT)
+			{
+				mpsse->tris &= ~bit;
+				retval = set_bits_low(mpsse, mpsse->pidle);
+			}
+		}
+		else if(pin >= NUM_GPIOL_PINS && pin < NUM_GPIO_PINS)
+		{
+			/* Convert pin number (4 - 11) to the corresponding pin bit */
+			bit = (1 << (pin - NUM_GPIOL_PINS));
+
+			if(direction == OUTPUT)
+			{
+				mpsse->trish |= bit;
+				retval = set_bits_high(mpsse, mpsse->gpioh);
+			}
+			else if(direction == INPUT)
+			{
+				mpsse->trish &= ~bit;
+				retval = set_bits_high(mpsse, mpsse->gpioh);
+			}
+		}
+	}
+
+	return retval;
+}
+
+/*
+ * Reads the level of the specified GPIO pin, whether it is an input or an output.
+ * Not for use in BITBANG mode.
+ *
+ * @mpsse - MPSSE context pointer.
+ * @pin   - Pin number (GPIOL0 - GPIOH7).
+ *
+ * Returns 1 if the pin is high, 0 if the pin is low.
+ * Returns MPSSE_FAIL on failure.
+ */
+int ReadPin(struct mpsse_context *mpsse, int pin)
+{
+	int retval = MPSSE_FAIL;
+	unsigned char cmd[2] = { 0 };
+	unsigned char val = 0;
+	uint8_t bit = 0;
+
+	if(is_valid_context(mpsse) && mpsse->mode != BITBANG && pin >= 0 && pin < NUM_GPIO_PINS)
+	{
+		if(pin < NUM_GPIOL_PINS)
+		{
+			cmd[0] = GET_BITS_LOW;
+			bit = (GPIO0 << pin);
+		}
+		else
+		{
+			cmd[0] = GET_BITS_HIGH;
+			bit = (1 << (pin - NUM_GPIOL_PINS));
+		}
+		cmd[1] = SEND_IMMEDIATE;
+
+		if(raw_write(mpsse, cmd, sizeof(cmd)) == MPSSE_OK && raw_read(mpsse, &val, 1) == 1)
+		{
+			retval = (val & bit) ? 1 : 0;
+		}
+	}
+
+	return retval;
+}
+
+/*
+ * Sets several GPIO pins at once. Bit n of mask and value corresponds to GPIO pin n
+ * (GPIOL0 - GPIOH7); only the pins set in mask are changed. The GPIOL and GPIOH pins
+ * are updated by a single SET_BITS_LOW / SET_BITS_HIGH command pair, so that the pins
+ * change together. Not for use in BITBANG mode. As with PinHigh and PinLow, GPIOL
+ * pins can only be changed while no transaction is in progress. Bits of mask above
+ * GPIOH7 are ignored.
+ *
+ * @mpsse - MPSSE context pointer.
+ * @mask  - Mask of the pins to change.
+ * @value - New pin values.
+ *
+ * Returns MPSSE_OK on success.
+ * Returns MPSSE_FAIL on failure.
+ */
+int WriteGPIO(struct mpsse_context *mpsse, uint16_t mask, uint16_t value)
+{
+	int retval = MPSSE_FAIL, i = 0;
+	unsigned char buf[CMD_SIZE*2] = { 0 };
+	uint8_t maskl = 0, valuel = 0, maskh = 0, valueh = 0;
+
+	if(is_valid_context(mpsse) && mpsse->mode != BITBANG)
+	{
+		/* Convert pin numbers 0-3 to the corresponding low byte pin bits, and 4-11 to the high byte */
+		maskl = (mask & 0x0F) << 4;
+		valuel = (value & 0x0F) << 4;
+		maskh = (mask >> NUM_GPIOL_PINS) & 0xFF;
+		valueh = (value >> NUM_GPIOL_PINS) & 0xFF;
+
+		/* The low pins can't be changed unless we are in a stopped status */
+		if(maskl && mpsse->status != STOPPED)
+		{
+			mpsse->ftdi.error_str = "GPIOL pins can not be changed during a transaction";
+		}
+		else
+		{
+			if(maskl)
+			{
+				mpsse->pstart = (mpsse->pstart & ~maskl) | (valuel & maskl);
+				mpsse->pidle = (mpsse->pidle & ~maskl) | (valuel & maskl);
+				mpsse->pstop = (mpsse->pstop & ~maskl) | (valuel & maskl);
+
+				buf[i++] = SET_BITS_LOW;
+				buf[i++] = mpsse->pidle;
+				buf[i++] = mpsse->tris;
+			}
+
+			if(maskh)
+			{
+				mpsse->gpioh = (mpsse->gpioh & ~maskh) | (valueh & maskh);
+
+				buf[i++] = SET_BITS_HIGH;
+				buf[i++] = mpsse->gpioh;
+				buf[i++] = mpsse->trish;
+			}
+
+			/* There is nothing to do if the mask has no pins in it */
+			if(i > 0)
+			{
+				retval = raw_write(mpsse, buf, i);
+			}
+			else
+			{
+				retval = MPSSE_OK;
+			}
+		}
+	}
+
+	return retval;
+}
+
+/*
+ * Reads the levels of all of the GPIO pins at once. Not for use in BITBANG mode.
+ *
+ * @mpsse - MPSSE context pointer.
+ *
+ * Returns the pin levels, with bit n corresponding to GPIO pin n (GPIOL0 - GPIOH7).
+ * Returns MPSSE_FAIL on failure.
+ */
+int ReadGPIO(struct mpsse_context *mpsse)
+{
+	int retval = MPSSE_FAIL;
+	unsigned char cmd[3] = { GET_BITS_LOW, GET_BITS_HIGH, SEND_IMMEDIATE };
+	unsigned char val[2] = { 0 };
+
+	if(is_valid_context(mpsse) && mpsse->mode != BITBANG)
+	{
+		if(raw_write(mpsse, cmd, sizeof(cmd)) == MPSSE_OK && raw_read(mpsse, val, sizeof(val)) == sizeof(val))
+		{
+			retval = (val[0] >> 4) | (val[1] << NUM_GPIOL_PINS);
+		}
+	}
+
+	return retval;
+}
+
 /*
  * Sets the input/output direction of all pins. For use in BITBANG mode only.
  *
@@ -1155,8 +1423,9 @@ int SetDirection(struct mpsse_context *mpsse, uint8_t direction)
 	{
 		if(mpsse->mode == BITBANG)
 		{
-			if(ftdi_set_bitmode(&mpsse->ftdi, direction, BITMODE_BITBANG) == 0)
+			if(ftdi_set_bitmode(&mpsse->ftdi, direction, mpsse->sync_bitbang ? BITMODE_SYNCBB : BITMODE_BITBANG) == 0)
                 	{
+				mpsse->bitbang_dir = direction;
 				retval = MPSSE_OK;
 			}
 		}
@@ -1167,6 +1436,8 @@ int SetDirection(struct mpsse_context *mpsse, uint8_t direction)
 
 /*
  * Sets the input/output value of all pins. For use in BITBANG mode only.
+ * In synchronous bitbang the pin state sampled by the write is read back and
+ * discarded, so that it is not returned by the next TransferPattern.
  *
  * @mpsse - MPSSE context pointer.
  * @data  - Byte indicating bit hi/low value of each bit.
@@ -1176,14 +1447,18 @@ int SetDirection(struct mpsse_context *mpsse, uint8_t direction)
 int WritePins(struct mpsse_context *mpsse, uint8_t data)
 {
 	int retval = MPSSE_FAIL;
+	unsigned char sample = 0;
 
 	if(is_valid_context(mpsse))
 	{
 		if(mpsse->mode == BITBANG)
 		{
-			if(ftdi_write_data(&mpsse->ftdi, &data, 1) == 0)
+			if(ftdi_write_data(&mpsse->ftdi, &data, 1) == 1)
 			{
-				retval = MPSSE_OK;
+				if(!mpsse->sync_bitbang || raw_read(mpsse, &sample, 1) == 1)
+				{
+					retval = MPSSE_OK;
+				}
 			}
 		}
 	}
@@ -1236,6 +1511,139 @@ int PinState(struct mpsse_context *mpsse, int pin, int state)
 	return ((state & (1 << pin)) >> pin);
 }
 
+/*
+ * Sets the rate at which bytes written by WritePattern are clocked out to the pins.
+ * For use in BITBANG mode only. The rate is set through the chip's baud rate
+ * generator, so the actual rate is approximate and depends on the chip.
+ *
+ * @mpsse - MPSSE context pointer.
+ * @rate  - Bitbang rate.
+ *
+ * Returns MPSSE_OK on success.
+ * Returns MPSSE_FAIL on failure.
+ */
+int SetBitbangRate(struct mpsse_context *mpsse, int rate)
+{
+	int retval = MPSSE_FAIL;
+
+	if(is_valid_context(mpsse) && mpsse->mode == BITBANG && rate > 0)
+	{
+		if(ftdi_set_baudrate(&mpsse->ftdi, rate) == 0)
+		{
+			retval = MPSSE_OK;
+		}
+	}
+
+	return retval;
+}
+
+/*
+ * Enables / disables synchronous bitbang. In synchronous bitbang, the pins are sampled
+ * each time a byte is written to them, and the samples are returned to the host.
+ * For use in BITBANG mode only.
+ *
+ * @mpsse  - MPSSE context pointer.
+ * @enable - Zero for asynchronous bitbang, 1 for synchronous bitbang.
+ *
+ * Returns MPSSE_OK on success.
+ * Returns MPSSE_FAIL on failure.
+ */
+int SetSyncBitbang(struct mpsse_context *mpsse, int enable)
+{
+	int retval = MPSSE_FAIL;
+
+	if(is_valid_context(mpsse) && mpsse->mode == BITBANG)
+	{
+		if(ftdi_set_bitmode(&mpsse->ftdi, mpsse->bitbang_dir, enable ? BITMODE_SYNCBB : BITMODE_BITBANG) == 0)
+		{
+			mpsse->sync_bitbang = enable ? 1 : 0;
+			retval = MPSSE_OK;
+		}
+	}
+
+	return retval;
+}
+
+/*
+ * Writes a pattern of pin states, one byte per step, at the rate set by SetBitbangRate.
+ * For use in BITBANG mode only. In synchronous bitbang the sampled pin states are read
+ * back and discarded; use TransferPattern to keep them.
+ *
+ * @mpsse - MPSSE context pointer.
+ * @data  - Pin states to write.
+ * @size  - Size of data.
+ *
+ * Returns MPSSE_OK on success.
+ * Returns MPSSE_FAIL on failure.
+ */
+int WritePattern(struct mpsse_context *mpsse, unsigned char *data, int size)
+{
+	int retval = MPSSE_FAIL;
+	unsigned char *buf = NULL;
+
+	if(is_valid_context(mpsse) && mpsse->mode == BITBANG)
+	{
+		if(mpsse->sync_bitbang)
+		{
+			buf = malloc(size);
+			if(buf)
+			{
+				retval = TransferPattern(mpsse, data, buf, size);
+				free(buf);
+			}
+		}
+		else
+		{
+			retval = raw_write(mpsse, data, size);
+		}
+	}
+
+	return retval;
+}
+
+/*
+ * Writes a pattern of pin states and reads back the pin states sampled at each step.
+ * Each byte read is sampled just before the corresponding byte is written to the pins.
+ * For use in synchronous BITBANG mode only.
+ *
+ * @mpsse - MPSSE context pointer.
+ * @wdata - Pin states to write.
+ * @rdata - Buffer to read the sampled pin states into; must be at least size bytes.
+ * @size  - Size of wdata.
+ *
+ * Returns MPSSE_OK on success.
+ * Returns MPSSE_FAIL on failure.
+ */
+int TransferPattern(struct mpsse_context *mpsse, unsigned char *wdata, unsigned char *rdata, int size)
+{
+	int retval = MPSSE_FAIL, n = 0, chunk = 0;
+
+	if(is_valid_context(mpsse) && mpsse->mode == BITBANG && mpsse->sync_bitbang)
+	{
+		retval = MPSSE_OK;
+
+		/* Read back each chunk before writing the next, so that the chip's receive FIFO never fills */
+		while(n < size && retval == MPSSE_OK)
+		{
+			chunk = size - n;
+			if(chunk > SYNCBB_TRANSFER_SIZE)
+			{
+				chunk = SYNCBB_TRANSFER_SIZE;
+			}
+
+			retval = raw_write(mpsse, wdata+n, chunk);
+			if(retval == MPSSE_OK && raw_read(mpsse, rdata+n, chunk) != chunk)
+			{
+				retval = MPSSE_FAIL;
+			}
+
+			n += chunk;
+		}
+	}
+
+	return retval;
+}
+
 /*
  * Places all I/O pins into a tristate mode.
  *
@@ -1255,6 +1663,379 @@ int Tristate(struct mpsse_context *mpsse)
 	return raw_write(mpsse, cmd, sizeof(cmd));
 }
 
+/*
+ * Writes a buffer of raw MPSSE commands to the FTDI chip.
+ *
+ * @mpsse - MPSSE context pointer.
+ * @buf   - Buffer of commands to send.
+ * @size  - Size of buf.
+ *
+ * Returns MPSSE_OK on success.
+ * Returns MPSSE_FAIL on failure.
+ */
+int RawWrite(struct mpsse_context *mpsse, unsigned char *buf, int size)
+{
+	int retval = MPSSE_FAIL;
+
+	if(is_valid_context(mpsse))
+	{
+		retval = raw_write(mpsse, buf, size);
+	}
+
+	return retval;
+}
+
+/*
+ * Reads the data returned by previously written raw MPSSE commands.
+ *
+ * @mpsse - MPSSE context pointer.
+ * @buf   - Buffer to read data into.
+ * @size  - Number of bytes to read.
+ *
+ * Returns MPSSE_OK if size bytes were read.
+ * Returns MPSSE_FAIL on failure.
+ */
+int RawRead(struct mpsse_context *mpsse, unsigned char *buf, int size)
+{
+	int retval = MPSSE_FAIL;
+
+	if(is_valid_context(mpsse))
+	{
+		if(raw_read(mpsse, buf, size) == size)
+		{
+			retval = MPSSE_OK;
+		}
+	}
+
+	return retval;
+}
+
+#if LIBFTDI1 == 1
+/* A USB read request kept in flight by StreamRead */
+struct stream_transfer
+{
+	struct libusb_transfer *transfer;
+	unsigned char *buf;
+	int completed;
+	int offset;
+};
+
+/* The USB read requests of a stream, read in order starting at head */
+struct stream_context
+{
+	struct stream_transfer *transfers;
+	int count;
+	int head;
+};
+
+static void LIBUSB_CALL stream_callback(struct libusb_transfer *transfer)
+{
+	struct stream_transfer *st = transfer->user_data;
+
+	st->completed = 1;
+}
+
+/* Submits a stream transfer, reading into its buffer from the start */
+static int stream_submit(struct stream_transfer *st)
+{
+	st->offset = 0;
+	st->completed = 0;
+
+	if(libusb_submit_transfer(st->transfer) != 0)
+	{
+		st->completed = 1;
+		return MPSSE_FAIL;
+	}
+
+	return MPSSE_OK;
+}
+#endif
+
+/*
+ * Starts keeping several USB read requests in flight, so that the data returned by
+ * raw MPSSE commands is collected by StreamRead without a gap between requests.
+ * Overlapped reads need libftdi1; with older versions of libftdi this does nothing
+ * and StreamRead reads one request at a time, as RawRead does.
+ *
+ * @mpsse     - MPSSE context pointer.
+ * @transfers - Number of read requests to keep in flight.
+ * @size      - Number of data bytes each request should be able to hold.
+ *
+ * Returns MPSSE_OK on success.
+ * Returns MPSSE_FAIL on failure.
+ */
+int StreamStart(struct mpsse_context *mpsse, int transfers, int size)
+{
+	int retval = MPSSE_FAIL;
+#if LIBFTDI1 == 1
+	struct stream_context *stream = NULL;
+	int i = 0, packet = 0;
+#endif
+
+	if(is_valid_context(mpsse) && mpsse->stream == NULL && transfers > 0 && size > 0)
+	{
+#if LIBFTDI1 == 1
+		/* Each packet read from the chip starts with two modem status bytes */
+		packet = mpsse->ftdi.max_packet_size;
+		size = ((size + packet - 3) / (packet - 2)) * packet;
+
+		stream = calloc(1, sizeof(struct stream_context));
+		if(stream)
+		{
+			stream->transfers = calloc(transfers, sizeof(struct stream_transfer));
+		}
+		if(stream == NULL || stream->transfers == NULL)
+		{
+			free(stream);
+			mpsse->ftdi.error_str = "Stream: out of memory";
+			return MPSSE_FAIL;
+		}
+
+		stream->count = transfers;
+		for(i = 0; i < transfers; i++)
+		{
+			stream->transfers[i].completed = 1;
+		}
+		mpsse->stream = stream;
+
+		retval = MPSSE_OK;
+		for(i = 0; i < transfers && retval == MPSSE_OK; i++)
+		{
+			struct stream_transfer *st = &stream->transfers[i];
+
+			st->buf = malloc(size);
+			st->transfer = libusb_alloc_transfer(0);
+			if(st->buf == NULL || st->transfer == NULL)
+			{
+				mpsse->ftdi.error_str = "Stream: out of memory";
+				retval = MPSSE_FAIL;
+				break;
+			}
+
+			libusb_fill_bulk_transfer(st->transfer, mpsse->ftdi.usb_dev, mpsse->ftdi.out_ep, st->buf, size, stream_callback, st, mpsse->ftdi.usb_read_timeout);
+			if(stream_submit(st) != MPSSE_OK)
+			{
+				mpsse->ftdi.error_str = "Stream: failed to submit USB read request";
+				retval = MPSSE_FAIL;
+			}
+		}
+
+		if(retval != MPSSE_OK)
+		{
+			StreamStop(mpsse);
+		}
+#else
+		retval = MPSSE_OK;
+#endif
+	}
+
+	return retval;
+}
+
+/*
+ * Reads the data returned by previously written raw MPSSE commands, from the
+ * read requests started by StreamStart. Each request is submitted again once
+ * its data has been read.
+ *
+ * @mpsse - MPSSE context pointer.
+ * @buf   - Buffer to read data into.
+ * @size  - Number of bytes to read.
+ *
+ * Returns MPSSE_OK if size bytes were read.
+ * Returns MPSSE_FAIL on failure.
+ */
+int StreamRead(struct mpsse_context *mpsse, unsigned char *buf, int size)
+{
+#if LIBFTDI1 == 1
+	struct stream_context *stream = NULL;
+	struct stream_transfer *st = NULL;
+	int n = 0, r = 0, length = 0, packet = 0;
+#endif
+
+	if(!is_valid_context(mpsse))
+	{
+		return MPSSE_FAIL;
+	}
+
+#if LIBFTDI1 == 1
+	stream = mpsse->stream;
+	if(stream == NULL)
+	{
+		return RawRead(mpsse, buf, size);
+	}
+
+	packet = mpsse->ftdi.max_packet_size;
+	while(n < size)
+	{
+		st = &stream->transfers[stream->head];
+		while(!st->completed)
+		{
+			if(libusb_handle_events_completed(mpsse->ftdi.usb_ctx, &st->completed) != 0)
+			{
+				mpsse->ftdi.error_str = "Stream: failed to handle USB events";
+				return MPSSE_FAIL;
+			}
+		}
+
+		/* A request that timed out may still have returned some data */
+		if(st->transfer->status != LIBUSB_TRANSFER_COMPLETED && st->transfer->status != LIBUSB_TRANSFER_TIMED_OUT)
+		{
+			mpsse->ftdi.error_str = "Stream: USB read request failed";
+			return MPSSE_FAIL;
+		}
+
+		length = st->transfer->actual_length;
+		while(n < size && st->offset < length)
+		{
+			/* Skip the modem status bytes at the start of each packet */
+			if(st->offset % packet == 0)
+			{
+				st->offset += 2;
+				continue;
+			}
+
+			r = packet - (st->offset % packet);
+			if(r > length - st->offset)
+			{
+				r = length - st->offset;
+			}
+			if(r > size - n)
+			{
+				r = size - n;
+			}
+
+			memcpy(buf + n, st->buf + st->offset, r);
+			st->offset += r;
+			n += r;
+		}
+
+		if(st->offset >= length)
+		{
+			if(stream_submit(st) != MPSSE_OK)
+			{
+				mpsse->ftdi.error_str = "Stream: failed to submit USB read request";
+				return MPSSE_FAIL;
+			}
+			stream->head = (stream->head + 1) % stream->count;
+		}
+	}
+
+	trace_record(mpsse, TRACE_READ, buf, n);
+	return MPSSE_OK;
+#else
+	return RawRead(mpsse, buf, size);
+#endif
+}
+
+/*
+ * Cancels the read requests started by StreamStart and frees them. Data that
+ * had been read by the requests but not by StreamRead is discarded.
+ *
+ * @mpsse - MPSSE context pointer.
+ *
+ * Returns void.
+ */
+void StreamStop(struct mpsse_context *mpsse)
+{
+#if LIBFTDI1 == 1
+	struct stream_context *stream = NULL;
+	int i = 0, ok = 1;
+
+	if(mpsse == NULL || mpsse->stream == NULL)
+	{
+		return;
+	}
+
+	stream = mpsse->stream;
+	for(i = 0; i < stream->count; i++)
+	{
+		if(!stream->transfers[i].completed)
+		{
+			libusb_cancel_transfer(stream->transfers[i].transfer);
+		}
+	}
+
+	for(i = 0; i < stream->count && ok; i++)
+	{
+		while(!stream->transfers[i].completed)
+		{
+			if(libusb_handle_events_completed(mpsse->ftdi.usb_ctx, &stream->transfers[i].completed) != 0)
+			{
+				ok = 0;
+				break;
+			}
+		}
+	}
+
+	/* Requests that may still be in flight can not be freed, so they are leaked */
+	if(ok)
+	{
+		for(i = 0; i < stream->count; i++)
+		{
+			libusb_free_transfer(stream->transfers[i].transfer);
+			free(stream->transfers[i].buf);
+		}
+		free(stream->transfers);
+		free(stream);
+	}
+
+	mpsse->stream = NULL;
+#endif
+
+	return;
+}
+
+/*
+ * Starts tracing the commands written to and the data read from the FTDI chip.
+ * Any previous trace is discarded. The trace is kept in the trace_buf field of
+ * the context, as a sequence of records of trace_size bytes in total; see
+ * trace_record.
+ *
+ * @mpsse - MPSSE context pointer.
+ *
+ * Returns void.
+ */
+void StartTrace(struct mpsse_context *mpsse)
+{
+	if(is_valid_context(mpsse))
+	{
+		mpsse->trace_size = 0;
+		mpsse->trace_truncated = 0;
+		mpsse->trace = 1;
+	}
+
+	return;
+}
+
+/*
+ * Stops tracing. The trace recorded so far is kept until the next call to StartTrace.
+ *
+ * @mpsse - MPSSE context pointer.
+ *
+ * Returns MPSSE_OK on success.
+ * Returns MPSSE_FAIL if the trace buffer could not be grown and the trace is truncated.
+ */
+int StopTrace(struct mpsse_context *mpsse)
+{
+	int retval = MPSSE_FAIL;
+
+	if(is_valid_context(mpsse))
+	{
+		mpsse->trace = 0;
+
+		if(mpsse->trace_truncated)
+		{
+			mpsse->ftdi.error_str = "Trace truncated: out of memory";
+		}
+		else
+		{
+			retval = MPSSE_OK;
+		}
+	}
+
+	return retval;
+}
+
 /* 
  * Returns the libmpsse version number. 
  * High nibble is major version, low nibble is minor version.
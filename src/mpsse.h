@@ -19,6 +19,8 @@
 #define SPI_RW_SIZE		(63 * 1024) 
 #define SPI_TRANSFER_SIZE	512
 #define I2C_TRANSFER_SIZE	64
+#define SYNCBB_TRANSFER_SIZE	128	/* Fits in the smallest receive FIFO of the chips supporting synchronous bitbang */
+#define TRACE_BUFFER_SIZE	4096	/* Initial size of the trace buffer, which grows as needed */
 
 #define LATENCY_MS		2
 #define TIMEOUT_DIVISOR		1000000
@@ -37,6 +39,10 @@
 #define NUM_GPIOL_PINS		4
 #define NUM_GPIO_PINS		12
 
+#define TRACE_WRITE		0x01	/* Trace record of bytes written to the chip */
+#define TRACE_READ		0x02	/* Trace record of bytes read from the chip */
+#define TRACE_HEADER_SIZE	5	/* Record type followed by a 32-bit little endian length */
+
 #define NULL_CONTEXT_ERROR_MSG	"NULL MPSSE context pointer!"
 
 /* FTDI interfaces */
@@ -111,6 +117,12 @@ enum i2c_ack
 	NACK = 1
 };
 
+enum pin_directions
+{
+	INPUT  = 0,
+	OUTPUT = 1
+};
+
 #define DEFAULT_TRIS            (SK | DO | CS | GPIO0 | GPIO1 | GPIO2 | GPIO3)  /* SK/DO/CS and GPIOs are outputs, DI is an input */
 #define DEFAULT_PORT            (SK | CS)       				/* SK and CS are high, all others low */
 
@@ -158,6 +170,16 @@ struct mpsse_context
 	int xsize;
 	int open;
 	int endianess;
+	int clock_stretching;
+	uint8_t clock_stretching_tris;
+	int sync_bitbang;
+	uint8_t bitbang_dir;
+	int trace;
+	unsigned char *trace_buf;
+	int trace_size;
+	int trace_cap;
+	int trace_truncated;
+	void *stream;
 	uint8_t tris;
 	uint8_t pstart;
 	uint8_t pstop;
@@ -185,6 +207,7 @@ int GetVid(struct mpsse_context *mpsse);
 int GetPid(struct mpsse_context *mpsse);
 const char *GetDescription(struct mpsse_context *mpsse);
 int SetLoopback(struct mpsse_context *mpsse, int enable);
+int SetClockStretching(struct mpsse_context *mpsse, int enable);
 void SetCSIdle(struct mpsse_context *mpsse, int idle);
 int Start(struct mpsse_context *mpsse);
 int Write(struct mpsse_context *mpsse, char *data, int size);
@@ -196,13 +219,28 @@ void SendNacks(struct mpsse_context *mpsse);
 void FlushAfterRead(struct mpsse_context *mpsse, int tf);
 int PinHigh(struct mpsse_context *mpsse, int pin);
 int PinLow(struct mpsse_context *mpsse, int pin);
+int SetPinDirection(struct mpsse_context *mpsse, int pin, int direction);
+int ReadPin(struct mpsse_context *mpsse, int pin);
+int WriteGPIO(struct mpsse_context *mpsse, uint16_t mask, uint16_t value);
+int ReadGPIO(struct mpsse_context *mpsse);
 int SetDirection(struct mpsse_context *mpsse, uint8_t direction);
 int WriteBits(struct mpsse_context *mpsse, char bits, int size);
 char ReadBits(struct mpsse_context *mpsse, int size);
 int WritePins(struct mpsse_context *mpsse, uint8_t data);
 int ReadPins(struct mpsse_context *mpsse);
 int PinState(struct mpsse_context *mpsse, int pin, int state);
+int SetBitbangRate(struct mpsse_context *mpsse, int rate);
+int SetSyncBitbang(struct mpsse_context *mpsse, int enable);
+int WritePattern(struct mpsse_context *mpsse, unsigned char *data, int size);
+int TransferPattern(struct mpsse_context *mpsse, unsigned char *wdata, unsigned char *rdata, int size);
 int Tristate(struct mpsse_context *mpsse);
+int RawWrite(struct mpsse_context *mpsse, unsigned char *buf, int size);
+int RawRead(struct mpsse_context *mpsse, unsigned char *buf, int size);
+int StreamStart(struct mpsse_context *mpsse, int transfers, int size);
+int StreamRead(struct mpsse_context *mpsse, unsigned char *buf, int size);
+void StreamStop(struct mpsse_context *mpsse);
+void StartTrace(struct mpsse_context *mpsse);
+int StopTrace(struct mpsse_context *mpsse);
 char Version(void);
 
 #ifdef SWIGPYTHON
@@ -6,6 +6,7 @@
  */
 
 #include <string.h>
+#include <stdlib.h>
 
 #if LIBFTDI1 == 1
 #include <libftdi1/ftdi.h>
@@ -26,6 +27,7 @@ int raw_write(struct mpsse_context *mpsse, unsigned char *buf, int size)
 		if(ftdi_write_data(&mpsse->ftdi, buf, size) == size)
         	{
                 	retval = MPSSE_OK;
+			trace_record(mpsse, TRACE_WRITE, buf, size);
 		}
         }
 
@@ -41,7 +43,7 @@ int raw_read(struct mpsse_context *mpsse, unsigned char *buf, int size)
 	{
 		while(n < size)
 		{
-			r = ftdi_read_data(&mpsse->ftdi, buf, size);
+			r = ftdi_read_data(&mpsse->ftdi, buf+n, size-n);
 			if(r < 0) break;
 			n += r;
 		}
@@ -55,11 +57,57 @@ int raw_read(struct mpsse_context *mpsse, unsigned char *buf, int size)
 			 */
 			ftdi_usb_purge_rx_buffer(&mpsse->ftdi);
 		}
+
+		trace_record(mpsse, TRACE_READ, buf, n);
 	}
 
 	return n;
 }
 
+/* 
+ * Appends a record of the bytes written to or read from the chip to the trace buffer, if tracing is enabled.
+ * Each record is the record type, the length of the data as a 32-bit little endian value, then the data.
+ * If the buffer cannot be grown, tracing is stopped and the trace is marked as truncated.
+ */
+void trace_record(struct mpsse_context *mpsse, int type, unsigned char *buf, int size)
+{
+	unsigned char *trace_buf = NULL;
+	int cap = 0;
+
+	if(mpsse->trace && size > 0)
+	{
+		cap = mpsse->trace_cap ? mpsse->trace_cap : TRACE_BUFFER_SIZE;
+		while(cap < mpsse->trace_size + TRACE_HEADER_SIZE + size)
+		{
+			cap *= 2;
+		}
+
+		if(cap != mpsse->trace_cap)
+		{
+			trace_buf = realloc(mpsse->trace_buf, cap);
+			if(!trace_buf)
+			{
+				mpsse->trace = 0;
+				mpsse->trace_truncated = 1;
+				return;
+			}
+
+			mpsse->trace_buf = trace_buf;
+			mpsse->trace_cap = cap;
+		}
+
+		trace_buf = mpsse->trace_buf + mpsse->trace_size;
+		trace_buf[0] = type;
+		trace_buf[1] = (size & 0xFF);
+		trace_buf[2] = ((size >> 8) & 0xFF);
+		trace_buf[3] = ((size >> 16) & 0xFF);
+		trace_buf[4] = ((size >> 24) & 0xFF);
+		memcpy(trace_buf + TRACE_HEADER_SIZE, buf, size);
+
+		mpsse->trace_size += TRACE_HEADER_SIZE + size;
+	}
+}
+
 /* Sets the read and write timeout periods for bulk usb data transfers. */
 void set_timeouts(struct mpsse_context *mpsse, int timeout)
 {
@@ -0,0 +1,330 @@
+package libmpsse
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+)
+
+// streamCmdMax is the largest number of read command bytes that a Stream
+// queues in the FTDI chip ahead of the data it has read. It is well below
+// the size of the chip's command buffer, so writing the commands never
+// blocks while the chip is waiting for its data to be read.
+const streamCmdMax = 256
+
+// streamChunkMax is the largest number of bytes a single MPSSE read
+// command can clock in.
+const streamChunkMax = 65536
+
+// streamTransfers is the number of USB read requests a Stream keeps in
+// flight, each holding up to one frame (or streamChunkMax bytes).
+const streamTransfers = 4
+
+// streamPort is the part of an Mpsse that a Stream reads frames through.
+type streamPort interface {
+	rawWrite(buf []byte) error
+	streamRead(buf []byte) error
+}
+
+// Stream continuously reads fixed size frames from an SPI device, such as
+// an ADC, and delivers them on a channel.
+//
+// Frames are read into a fixed pool of buffers by a background goroutine.
+// The read commands for several frames (up to one per buffer) are queued
+// in the MPSSE before their data is collected, and another is queued each
+// time a frame has been read, so the MPSSE clocks the next frames in while
+// the earlier ones are transferred over USB. The data is collected by
+// several USB read requests kept in flight at once, so there is no gap on
+// the bus between one request completing and the next being submitted.
+// The clock only pauses between frames if the chip's receive FIFO fills
+// because frames are not being read from USB fast enough.
+//
+// Overlapped USB reads need libftdi1. When libmpsse is built against an
+// older libftdi, the reads are made one at a time, as RawRead makes them.
+//
+// The read commands are the ones FastRead sends, but FastRead waits for
+// each block of data before sending the command for the next, which is
+// the gap between USB transactions that a Stream avoids; so the commands
+// are queued and collected here directly instead.
+//
+// Each frame received must be handed back with Release once the consumer
+// is done with it. If no buffer is free when a frame is read, the frame is
+// still read (to keep the device's data flowing) but is discarded and
+// counted as an overrun.
+type Stream struct {
+	// overruns is accessed atomically, so it is kept first in the struct
+	// to guarantee 64-bit alignment on 32-bit platforms.
+	overruns uint64
+
+	mpsse   *Mpsse
+	port    streamPort
+	frames  chan []byte
+	free    chan []byte
+	scratch []byte
+	done    chan struct{}
+	wg      sync.WaitGroup
+
+	// delivered records, by the address of their first byte, whether each
+	// of the stream's buffers has been delivered on frames and not yet
+	// released.
+	bufLock   sync.Mutex
+	delivered map[*byte]bool
+
+	// cmd is the MPSSE commands that read one frame, and ahead is the
+	// number of frames whose reads are kept queued.
+	cmd   []byte
+	ahead int
+
+	closeOnce sync.Once
+	closeErr  error
+
+	errLock sync.Mutex
+	err     error
+}
+
+// Stream starts streaming frames of frameSize bytes using the given number
+// of buffers (at least 2). The Mpsse must be in one of the SPI modes, with
+// FlushAfterRead disabled. The chip select is asserted for the lifetime of
+// the stream; Close must be called to stop reading and deassert it. The
+// Mpsse is locked while the stream is running, so other calls on it (and
+// the polling of watched pins) block until the stream is closed; making
+// such a call from the goroutine that is to call Close deadlocks.
+func (m *Mpsse) Stream(frameSize, buffers int) (*Stream, error) {
+	if frameSize <= 0 {
+		return nil, fmt.Errorf("invalid stream frame size %d", frameSize)
+	}
+	if buffers < 2 {
+		return nil, fmt.Errorf("stream needs at least 2 buffers, got %d", buffers)
+	}
+
+	m.lock.Lock()
+	state := m.state()
+	if state.mode < SPI0 || state.mode > SPI3 {
+		m.lock.Unlock()
+		return nil, fmt.Errorf("streaming is only supported in SPI modes")
+	}
+	if state.flushAfterRead {
+		m.lock.Unlock()
+		return nil, fmt.Errorf("streaming requires FlushAfterRead to be disabled")
+	}
+
+	s := newStream(m, frameSize, buffers, streamCommand(state.rx, frameSize))
+	s.mpsse = m
+
+	if err := m.start(); err != nil {
+		m.lock.Unlock()
+		return nil, err
+	}
+
+	size := frameSize
+	if size > streamChunkMax {
+		size = streamChunkMax
+	}
+	if err := m.streamStart(streamTransfers, size); err != nil {
+		m.stop()
+		m.lock.Unlock()
+		return nil, err
+	}
+
+	s.wg.Add(1)
+	go s.run()
+
+	return s, nil
+}
+
+// newStream returns a Stream that reads frames of frameSize bytes from port
+// using the MPSSE commands cmd. The caller starts it by running run.
+func newStream(port streamPort, frameSize, buffers int, cmd []byte) *Stream {
+	s := &Stream{
+		port:    port,
+		frames:  make(chan []byte, buffers),
+		free:    make(chan []byte, buffers),
+		scratch: make([]byte, frameSize),
+		done:    make(chan struct{}),
+		cmd:     cmd,
+	}
+	s.addBuffers(frameSize, buffers)
+
+	s.ahead = streamCmdMax / len(s.cmd)
+	if s.ahead > buffers {
+		s.ahead = buffers
+	}
+	if s.ahead < 1 {
+		s.ahead = 1
+	}
+	return s
+}
+
+// streamCommand returns the MPSSE commands that read size bytes using the
+// read command rx.
+func streamCommand(rx byte, size int) []byte {
+	var cmd []byte
+	for size > 0 {
+		n := size
+		if n > streamChunkMax {
+			n = streamChunkMax
+		}
+		cmd = append(cmd, rx, byte(n-1), byte((n-1)>>8))
+		size -= n
+	}
+	return cmd
+}
+
+// addBuffers fills the pool of free buffers.
+func (s *Stream) addBuffers(frameSize, buffers int) {
+	s.delivered = make(map[*byte]bool, buffers)
+	for i := 0; i < buffers; i++ {
+		buf := make([]byte, frameSize)
+		s.delivered[&buf[0]] = false
+		s.free <- buf
+	}
+}
+
+// Frames returns the channel that frames are delivered on. The channel is
+// closed when the stream is closed or a read fails; Err reports the cause
+// of a failure.
+func (s *Stream) Frames() <-chan []byte {
+	return s.frames
+}
+
+// Release returns a frame received from Frames to the stream so that its
+// buffer can be reused. An error is returned, and the frame is not reused,
+// if it was not received from the stream or has already been released.
+func (s *Stream) Release(frame []byte) error {
+	frame = frame[:cap(frame)]
+	if len(frame) == 0 {
+		return fmt.Errorf("frame was not received from this stream")
+	}
+
+	s.bufLock.Lock()
+	defer s.bufLock.Unlock()
+
+	delivered, ok := s.delivered[&frame[0]]
+	if !ok {
+		return fmt.Errorf("frame was not received from this stream")
+	}
+	if !delivered {
+		return fmt.Errorf("frame has already been released")
+	}
+	s.delivered[&frame[0]] = false
+
+	// Only delivered buffers are released, so there is always room for
+	// them in the pool.
+	s.free <- frame
+	return nil
+}
+
+// Overruns returns the number of frames that were discarded because no
+// buffer was free.
+func (s *Stream) Overruns() uint64 {
+	return atomic.LoadUint64(&s.overruns)
+}
+
+// Err returns the error that stopped the stream, if any.
+func (s *Stream) Err() error {
+	s.errLock.Lock()
+	defer s.errLock.Unlock()
+	return s.err
+}
+
+// Close stops the stream, reads and discards the frames that are still
+// queued, deasserts the chip select and unlocks the Mpsse. It returns the
+// error that stopped the stream, if any. Calling Close more than once
+// returns the same error.
+func (s *Stream) Close() error {
+	s.closeOnce.Do(func() {
+		s.halt()
+
+		defer s.mpsse.lock.Unlock()
+		s.mpsse.streamStop()
+		if err := s.mpsse.stop(); err != nil {
+			s.closeErr = err
+			return
+		}
+		s.closeErr = s.Err()
+	})
+	return s.closeErr
+}
+
+// halt stops run and waits for it to drain the queued frames.
+func (s *Stream) halt() {
+	close(s.done)
+	s.wg.Wait()
+}
+
+// fail records the error that stopped the stream.
+func (s *Stream) fail(err error) {
+	s.errLock.Lock()
+	s.err = err
+	s.errLock.Unlock()
+}
+
+// run reads frames until the stream is closed or a read fails.
+func (s *Stream) run() {
+	defer s.wg.Done()
+	defer close(s.frames)
+
+	queued := 0
+	for ; queued < s.ahead; queued++ {
+		if err := s.port.rawWrite(s.cmd); err != nil {
+			s.fail(err)
+			s.drain(queued)
+			return
+		}
+	}
+
+	for {
+		select {
+		case <-s.done:
+			s.drain(queued)
+			return
+		default:
+		}
+
+		var buf []byte
+		select {
+		case buf = <-s.free:
+		default:
+			buf = s.scratch
+		}
+
+		err := s.port.streamRead(buf)
+		queued--
+		if err == nil {
+			if err = s.port.rawWrite(s.cmd); err == nil {
+				queued++
+			}
+		}
+		if err != nil {
+			s.fail(err)
+			s.drain(queued)
+			return
+		}
+
+		if &buf[0] == &s.scratch[0] {
+			atomic.AddUint64(&s.overruns, 1)
+			continue
+		}
+
+		s.bufLock.Lock()
+		s.delivered[&buf[0]] = true
+		s.bufLock.Unlock()
+
+		// There are as many slots in the frames channel as there are
+		// buffers, so this never blocks.
+		s.frames <- buf
+	}
+}
+
+// drain reads and discards the data of the frames whose reads are still
+// queued, so that it is not returned by later reads.
+func (s *Stream) drain(queued int) {
+	for ; queued > 0; queued-- {
+		if err := s.port.streamRead(s.scratch); err != nil {
+			if s.Err() == nil {
+				s.fail(err)
+			}
+			return
+		}
+	}
+}
@@ -0,0 +1,152 @@
+package libmpsse
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"testing"
+	"time"
+)
+
+// fakeStreamPort returns numbered frames of 8 bytes, each holding its
+// number twice, for the frame read commands written to it.
+type fakeStreamPort struct {
+	cmd []byte
+
+	// queued is the number of frames whose read commands have been written
+	// but whose data has not been read, and reads is the number of frames
+	// read.
+	queued int
+	reads  int
+}
+
+func (p *fakeStreamPort) rawWrite(buf []byte) error {
+	if !bytes.Equal(buf, p.cmd) {
+		return errors.New("unexpected command")
+	}
+	p.queued++
+	return nil
+}
+
+func (p *fakeStreamPort) streamRead(buf []byte) error {
+	if p.queued == 0 {
+		return errors.New("read with no frame queued")
+	}
+	if len(buf) != 8 {
+		return errors.New("partial frame read")
+	}
+	binary.LittleEndian.PutUint32(buf, uint32(p.reads))
+	binary.LittleEndian.PutUint32(buf[4:], uint32(p.reads))
+	p.queued--
+	p.reads++
+	return nil
+}
+
+// startStream starts a Stream on a fake port.
+func startStream(buffers int) (*Stream, *fakeStreamPort) {
+	port := &fakeStreamPort{cmd: streamCommand(0x20, 8)}
+	s := newStream(port, 8, buffers, port.cmd)
+	s.wg.Add(1)
+	go s.run()
+	return s, port
+}
+
+// checkFrame checks that frame holds a single frame number, and returns it.
+func checkFrame(t *testing.T, frame []byte) int {
+	if !bytes.Equal(frame[:4], frame[4:]) {
+		t.Fatalf("frame % X mixes the data of two frames", frame)
+	}
+	return int(binary.LittleEndian.Uint32(frame))
+}
+
+func TestStreamRelease(t *testing.T) {
+	s := &Stream{free: make(chan []byte, 2)}
+	s.addBuffers(4, 2)
+
+	// Take a buffer from the pool and deliver it, as run does.
+	buf := <-s.free
+	s.delivered[&buf[0]] = true
+
+	if err := s.Release(buf[:2]); err != nil {
+		t.Fatalf("Release: got error %v", err)
+	}
+	if len(s.free) != 2 {
+		t.Errorf("got %d free buffers, want 2", len(s.free))
+	}
+	if err := s.Release(buf); err == nil {
+		t.Error("released twice: got no error")
+	}
+	if err := s.Release(make([]byte, 4)); err == nil {
+		t.Error("foreign frame: got no error")
+	}
+	if err := s.Release(nil); err == nil {
+		t.Error("nil frame: got no error")
+	}
+	if len(s.free) != 2 {
+		t.Errorf("got %d free buffers, want 2", len(s.free))
+	}
+}
+
+func TestStreamOrder(t *testing.T) {
+	s, port := startStream(4)
+
+	// Frames are delivered in the order they were read, though some may
+	// be skipped as overruns.
+	received, last := 0, -1
+	for ; received < 100; received++ {
+		frame := <-s.Frames()
+		n := checkFrame(t, frame)
+		if n <= last {
+			t.Fatalf("frame %d received after frame %d", n, last)
+		}
+		last = n
+		if err := s.Release(frame); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	s.halt()
+	for range s.Frames() {
+		received++
+	}
+	if err := s.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Every frame read was either delivered, counted as an overrun, or
+	// still queued when the stream stopped and drained.
+	if got := received + int(s.Overruns()) + s.ahead; got != port.reads {
+		t.Errorf("got %d frames delivered, overrun or drained, want the %d read", got, port.reads)
+	}
+	if port.queued != 0 {
+		t.Errorf("got %d frames still queued", port.queued)
+	}
+}
+
+func TestStreamOverruns(t *testing.T) {
+	s, port := startStream(4)
+
+	// No frame is released, so once the 4 buffers are delivered every
+	// frame read is an overrun.
+	for deadline := time.Now().Add(5 * time.Second); s.Overruns() < 10; {
+		if time.Now().After(deadline) {
+			t.Fatal("no overruns counted")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	s.halt()
+
+	var got []int
+	for frame := range s.Frames() {
+		got = append(got, checkFrame(t, frame))
+	}
+	if len(got) != 4 || got[0] != 0 || got[1] != 1 || got[2] != 2 || got[3] != 3 {
+		t.Errorf("got frames %v, want [0 1 2 3]", got)
+	}
+	if want := uint64(port.reads - 4 - s.ahead); s.Overruns() != want {
+		t.Errorf("got %d overruns, want %d", s.Overruns(), want)
+	}
+	if port.queued != 0 {
+		t.Errorf("got %d frames still queued", port.queued)
+	}
+}
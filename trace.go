@@ -0,0 +1,515 @@
+package libmpsse
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// Trace record types and header size. These values match the definitions
+// in the C implementation.
+const (
+	traceWrite      = 0x01
+	traceRead       = 0x02
+	traceHeaderSize = 5
+)
+
+// System clocks that the MPSSE clock divisor applies to, with the divide
+// by 5 prescaler disabled and enabled.
+const (
+	traceClockX5 = 60000000
+	traceClockD5 = 12000000
+)
+
+// traceNames are the names of the signals in an exported trace. Bit n of
+// the pin levels is signal n: the low byte pins, then the high byte pins.
+var traceNames = []string{
+	"SK", "DO", "DI", "CS", "GPIOL0", "GPIOL1", "GPIOL2", "GPIOL3",
+	"GPIOH0", "GPIOH1", "GPIOH2", "GPIOH3", "GPIOH4", "GPIOH5", "GPIOH6", "GPIOH7",
+}
+
+// Trace is a record of the commands written to the FTDI chip and the data
+// read back between StartTrace and StopTrace. The levels of the pins are
+// reconstructed from it by interpreting the commands, starting from the
+// pin states and clock rate that were configured when the trace started.
+//
+// The reconstruction shows what the library drove, not what was measured
+// on the bus: every command that sets the pins takes half a clock period,
+// every clock cycle takes one clock period (1.5 with three phase clocking,
+// as in I2C mode), and the time between USB transfers is not shown. Input
+// pins show the last level that was read from them; in particular, DO
+// shows the level read on DI while it is an input, as in I2C mode where
+// the two are wired together.
+type Trace struct {
+	start   mpsseState
+	clock   int
+	records []byte
+}
+
+// StartTrace starts recording the commands written to the FTDI chip and
+// the data read back, so that the SPI, I2C and GPIO activity can be
+// exported from the Trace returned by StopTrace. Any trace in progress is
+// discarded. Tracing is not supported in BITBANG mode.
+//
+// Traces should be started while no transaction is in progress, as the
+// pin levels reconstructed from the start of the trace assume that the
+// pins are idle.
+func (m *Mpsse) StartTrace() error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	state := m.state()
+	if state.mode == BITBANG {
+		return fmt.Errorf("tracing is not supported in BITBANG mode")
+	}
+
+	m.trace = &Trace{start: state, clock: m.getClock()}
+	m.startTrace()
+	return nil
+}
+
+// StopTrace stops recording and returns the trace started by StartTrace.
+// If memory ran out while recording, the trace is incomplete and an error
+// is returned instead.
+func (m *Mpsse) StopTrace() (*Trace, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if m.trace == nil {
+		return nil, fmt.Errorf("no trace has been started")
+	}
+
+	t := m.trace
+	m.trace = nil
+	records, err := m.stopTrace()
+	if err != nil {
+		return nil, err
+	}
+	t.records = records
+	return t, nil
+}
+
+// WriteVCD writes the pin levels reconstructed from the trace to w as a
+// Value Change Dump file, which can be opened in GTKWave or PulseView.
+func (t *Trace) WriteVCD(w io.Writer) error {
+	changes, end, err := t.render()
+	if err != nil {
+		return err
+	}
+
+	v, err := newVCDWriter(w, "mpsse", traceNames)
+	if err != nil {
+		return err
+	}
+	for _, c := range changes {
+		v.set(c.time/1000, c.pins)
+	}
+	return v.finish(end / 1000)
+}
+
+// traceSigrokMaxSamples is the largest number of samples that WriteSigrok
+// writes. Each sample takes 2 bytes, which are held in memory.
+const traceSigrokMaxSamples = 1 << 24
+
+// WriteSigrok writes the pin levels reconstructed from the trace to w as
+// a sigrok session file (.sr), which can be opened in PulseView. The pins
+// are sampled twice per period of the fastest clock rate in the trace. A
+// trace that would take more than 16M samples, such as a trace of slow
+// transactions at a fast clock rate with long gaps between them, is not
+// written; an error is returned, and WriteVCD can be used instead.
+func (t *Trace) WriteSigrok(w io.Writer) error {
+	changes, end, err := t.render()
+	if err != nil {
+		return err
+	}
+
+	samples, rate, err := sampleTrace(changes, end, traceSigrokMaxSamples)
+	if err != nil {
+		return err
+	}
+	return writeSigrok(w, samples, 2, rate, traceNames)
+}
+
+// sampleTrace samples the pin levels of a rendered trace twice per period
+// of its fastest clock rate, and returns the samples, 2 bytes each, and the
+// sample rate. An error is returned if more than max samples are needed.
+func sampleTrace(changes []traceChange, end int64, max int64) ([]byte, uint32, error) {
+	rate := uint32(0)
+	for _, c := range changes {
+		if r := uint32(2 * c.clock); r > rate {
+			rate = r
+		}
+	}
+	if rate == 0 {
+		return nil, 0, fmt.Errorf("invalid trace clock rate")
+	}
+
+	period := int64(1000000000000) / int64(rate)
+	count := (end + period - 1) / period
+	if count > max {
+		return nil, 0, fmt.Errorf("trace needs %d samples at %d Hz, more than the %d a sigrok file is limited to", count, rate, max)
+	}
+
+	// Each sample is taken in the middle of its sample period, so that
+	// rounding does not move it onto a change.
+	samples := make([]byte, 0, 2*count)
+	for i, n := 0, int64(0); n*period < end; n++ {
+		at := n*period + period/2
+		for i+1 < len(changes) && changes[i+1].time <= at {
+			i++
+		}
+		samples = append(samples, byte(changes[i].pins), byte(changes[i].pins>>8))
+	}
+	return samples, rate, nil
+}
+
+// traceChange is the levels of the pins from a time in a trace, in
+// picoseconds, and the clock rate in effect at that time.
+type traceChange struct {
+	time  int64
+	pins  uint32
+	clock int
+}
+
+// render interprets the commands in the trace and returns the changes in
+// the pin levels, and the time at which the trace ends.
+func (t *Trace) render() ([]traceChange, int64, error) {
+	var cmds, resp []byte
+	for rec := t.records; len(rec) > 0; {
+		if len(rec) < traceHeaderSize {
+			return nil, 0, fmt.Errorf("truncated trace record")
+		}
+		n := int(binary.LittleEndian.Uint32(rec[1:traceHeaderSize]))
+		if len(rec) < traceHeaderSize+n {
+			return nil, 0, fmt.Errorf("truncated trace record")
+		}
+
+		data := rec[traceHeaderSize : traceHeaderSize+n]
+		switch rec[0] {
+		case traceWrite:
+			cmds = append(cmds, data...)
+		case traceRead:
+			resp = append(resp, data...)
+		default:
+			return nil, 0, fmt.Errorf("invalid trace record type 0x%02x", rec[0])
+		}
+		rec = rec[traceHeaderSize+n:]
+	}
+
+	low := t.start.pidle
+	if t.start.started {
+		low = t.start.pstart
+	}
+	r := &traceRenderer{
+		cmds:       cmds,
+		resp:       resp,
+		base:       traceClockD5,
+		threePhase: t.start.mode == I2C,
+		low:        low,
+		tris:       t.start.tris,
+		high:       t.start.gpioh,
+		trish:      t.start.trish,
+		in:         low,
+		inh:        t.start.gpioh,
+	}
+	if t.clock > traceClockD5/2 {
+		r.base = traceClockX5
+	}
+	if err := r.setClock(t.clock); err != nil {
+		return nil, 0, err
+	}
+
+	// The levels at the start of the trace are shown for half a period,
+	// before the first command.
+	r.emit()
+	r.time += r.tick
+
+	if err := r.run(); err != nil {
+		return nil, 0, err
+	}
+	return r.changes, r.time, nil
+}
+
+// traceRenderer interprets MPSSE commands, tracking the levels of the
+// pins and the time.
+type traceRenderer struct {
+	cmds []byte
+	resp []byte
+
+	changes []traceChange
+	time    int64
+
+	// The clock rate, the time of half a clock period in picoseconds, and
+	// the system clock that the clock divisor applies to.
+	clock int
+	tick  int64
+	base  int
+
+	threePhase bool
+
+	// Pin values and directions (1 is out) set by the commands, and the
+	// levels last read from the input pins, of the low and high bytes.
+	low, tris   byte
+	high, trish byte
+	in, inh     byte
+}
+
+// run interprets all of the commands.
+func (r *traceRenderer) run() error {
+	for len(r.cmds) > 0 {
+		op := r.cmds[0]
+		r.cmds = r.cmds[1:]
+
+		if op&0x80 == 0 {
+			if err := r.shift(op); err != nil {
+				return err
+			}
+			continue
+		}
+
+		switch op {
+		case cmdSetBitsLow, cmdSetBitsHigh:
+			args, err := r.args(2)
+			if err != nil {
+				return err
+			}
+			if op == cmdSetBitsLow {
+				r.low, r.tris = args[0], args[1]
+			} else {
+				r.high, r.trish = args[0], args[1]
+			}
+			r.emit()
+			r.time += r.tick
+
+		case cmdGetBitsLow:
+			r.in = r.read(1)[0]
+			r.emit()
+
+		case cmdGetBitsHigh:
+			r.inh = r.read(1)[0]
+			r.emit()
+
+		case cmdTCKDivisor:
+			args, err := r.args(2)
+			if err != nil {
+				return err
+			}
+			div := int(binary.LittleEndian.Uint16(args))
+			if err := r.setClock(r.base / ((1 + div) * 2)); err != nil {
+				return err
+			}
+
+		case cmdTCKX5:
+			r.base = traceClockX5
+
+		case cmdTCKD5:
+			r.base = traceClockD5
+
+		case cmdEnable3PhaseClock, cmdDisable3PhaseClock:
+			r.threePhase = op == cmdEnable3PhaseClock
+
+		case cmdClockNCycles:
+			args, err := r.args(1)
+			if err != nil {
+				return err
+			}
+			r.cycles(int(args[0]) + 1)
+
+		case cmdClockN8Cycles:
+			args, err := r.args(2)
+			if err != nil {
+				return err
+			}
+			r.cycles((int(binary.LittleEndian.Uint16(args)) + 1) * 8)
+
+		case cmdTristateIO:
+			if _, err := r.args(2); err != nil {
+				return err
+			}
+
+		case cmdSendImmediate, cmdLoopbackStart, cmdLoopbackEnd,
+			cmdEnableAdaptiveClock, cmdDisableAdaptiveClock:
+
+		default:
+			return fmt.Errorf("unsupported MPSSE command 0x%02x in trace", op)
+		}
+	}
+	return nil
+}
+
+// shift interprets the data shifting command op.
+func (r *traceRenderer) shift(op byte) error {
+	write := op&mpsseDoWrite != 0
+	read := op&mpsseDoRead != 0
+	if op&mpsseWriteTMS != 0 || !write && !read {
+		return fmt.Errorf("unsupported MPSSE command 0x%02x in trace", op)
+	}
+	lsb := op&mpsseLSB != 0
+
+	if op&mpsseBitmode != 0 {
+		args, err := r.args(1)
+		if err != nil {
+			return err
+		}
+		n := int(args[0]&0x07) + 1
+
+		var out, in byte
+		if write {
+			args, err := r.args(1)
+			if err != nil {
+				return err
+			}
+			out = args[0]
+		}
+		if read {
+			in = r.read(1)[0]
+		}
+
+		// Bits read are shifted in at the opposite end of the byte to
+		// the one they are sent from.
+		for i := 0; i < n; i++ {
+			o, d := bitOf(out, 7-i), bitOf(in, n-1-i)
+			if lsb {
+				o, d = bitOf(out, i), bitOf(in, 8-n+i)
+			}
+			r.bit(op, write, o, read, d)
+		}
+	} else {
+		args, err := r.args(2)
+		if err != nil {
+			return err
+		}
+		n := int(binary.LittleEndian.Uint16(args)) + 1
+
+		out := make([]byte, n)
+		if write {
+			if out, err = r.args(n); err != nil {
+				return err
+			}
+		}
+		in := make([]byte, n)
+		if read {
+			in = r.read(n)
+		}
+
+		for j := 0; j < n; j++ {
+			for i := 0; i < 8; i++ {
+				k := 7 - i
+				if lsb {
+					k = i
+				}
+				r.bit(op, write, bitOf(out[j], k), read, bitOf(in[j], k))
+			}
+		}
+	}
+
+	// The clock is left at its idle level.
+	r.emit()
+	return nil
+}
+
+// cycles clocks n cycles without data.
+func (r *traceRenderer) cycles(n int) {
+	for i := 0; i < n; i++ {
+		r.bit(0, false, false, false, false)
+	}
+	r.emit()
+}
+
+// bit clocks one bit of the data shifting command op, writing out on DO
+// if write is set and reading in from DI if read is set. The clock starts
+// and ends at the level of SK set by the last SET_BITS_LOW command.
+func (r *traceRenderer) bit(op byte, write, out, read, in bool) {
+	idle := r.low & pinSK
+
+	if write {
+		r.low = setBit(r.low, pinDO, out)
+	}
+	if read {
+		r.in = setBit(r.in, pinDI, in)
+		if r.tris&pinDO == 0 {
+			r.in = setBit(r.in, pinDO, in)
+		}
+	}
+
+	// The levels of SK for each half period of the bit. The data changes
+	// at the start of the bit, on the edge it is launched on, or half a
+	// period before the edge it is sampled on.
+	active := idle ^ pinSK
+	levels := []byte{idle, active}
+	switch {
+	case r.threePhase:
+		levels = []byte{idle, active, idle}
+	case write && (op&mpsseWriteNeg != 0) == (idle != 0),
+		!write && (op&mpsseReadNeg != 0) != (idle != 0):
+		levels = []byte{active, idle}
+	}
+
+	for _, sk := range levels {
+		r.low = r.low&^pinSK | sk
+		r.emit()
+		r.time += r.tick
+	}
+	r.low = r.low&^pinSK | idle
+}
+
+// pins returns the levels of the pins: the values set for the outputs,
+// and the levels last read for the inputs.
+func (r *traceRenderer) pins() uint32 {
+	low := r.low&r.tris | r.in&^r.tris
+	high := r.high&r.trish | r.inh&^r.trish
+	return uint32(low) | uint32(high)<<8
+}
+
+// emit records the levels of the pins at the current time, replacing any
+// levels already recorded at that time.
+func (r *traceRenderer) emit() {
+	pins := r.pins()
+	if n := len(r.changes); n > 0 {
+		last := &r.changes[n-1]
+		if last.time == r.time {
+			last.pins, last.clock = pins, r.clock
+			return
+		}
+		if last.pins == pins && last.clock == r.clock {
+			return
+		}
+	}
+	r.changes = append(r.changes, traceChange{time: r.time, pins: pins, clock: r.clock})
+}
+
+// setClock sets the clock rate for the commands that follow.
+func (r *traceRenderer) setClock(clock int) error {
+	if clock <= 0 {
+		return fmt.Errorf("invalid trace clock rate %d", clock)
+	}
+	r.clock = clock
+	r.tick = int64(1000000000000) / int64(2*clock)
+	return nil
+}
+
+// args returns the next n bytes of the command stream.
+func (r *traceRenderer) args(n int) ([]byte, error) {
+	if len(r.cmds) < n {
+		return nil, fmt.Errorf("truncated MPSSE command in trace")
+	}
+	args := r.cmds[:n]
+	r.cmds = r.cmds[n:]
+	return args, nil
+}
+
+// read returns the next n bytes of the data read back. If the read was
+// cut short, the missing bytes are read as 0xFF.
+func (r *traceRenderer) read(n int) []byte {
+	data := make([]byte, n)
+	for i := range data {
+		data[i] = 0xFF
+	}
+	r.resp = r.resp[copy(data, r.resp):]
+	return data
+}
+
+// bitOf returns bit n of b.
+func bitOf(b byte, n int) bool {
+	return b>>uint(n)&1 != 0
+}
@@ -0,0 +1,185 @@
+package libmpsse
+
+import (
+	"reflect"
+	"testing"
+)
+
+// traceRecord returns a trace record of the given type holding data.
+func traceRecord(typ byte, data ...byte) []byte {
+	n := len(data)
+	rec := []byte{typ, byte(n), byte(n >> 8), byte(n >> 16), byte(n >> 24)}
+	return append(rec, data...)
+}
+
+// change is a change in the pin levels at a time in microseconds, which
+// is half a clock period at the 500 kHz clock that the tests use.
+type change struct {
+	time int64
+	pins uint32
+}
+
+func TestTraceRender(t *testing.T) {
+	tests := []struct {
+		name    string
+		start   mpsseState
+		records [][]byte
+		changes []change
+		end     int64
+	}{
+		{
+			// Start, a full duplex transfer of 0xA5 that reads 0x3C, and
+			// Stop.
+			name:  "spi mode 0",
+			start: mpsseState{mode: SPI0, pidle: 0x08, pstart: 0x00, tris: 0xFB},
+			records: [][]byte{
+				traceRecord(traceWrite,
+					cmdSetBitsLow, 0x00, 0xFB,
+					mpsseDoWrite|mpsseDoRead|mpsseWriteNeg, 0x00, 0x00, 0xA5,
+					cmdSetBitsLow, 0x08, 0xFB),
+				traceRecord(traceRead, 0x3C),
+			},
+			changes: []change{
+				// CS is high, then goes low.
+				{0, 0x08}, {1, 0x00},
+				// Each bit changes DO and DI with SK low, then raises SK.
+				{2, 0x02}, {3, 0x03},
+				{4, 0x00}, {5, 0x01},
+				{6, 0x06}, {7, 0x07},
+				{8, 0x04}, {9, 0x05},
+				{10, 0x04}, {11, 0x05},
+				{12, 0x06}, {13, 0x07},
+				{14, 0x00}, {15, 0x01},
+				{16, 0x02}, {17, 0x03},
+				// CS goes high with SK back at its idle level.
+				{18, 0x08},
+			},
+			end: 19,
+		},
+		{
+			// Start, a write of address 0xA0 that the device ACKs, and
+			// Stop, as sent by Start, Write and Stop in I2C mode.
+			name:  "i2c",
+			start: mpsseState{mode: I2C, pidle: 0x0F, pstart: 0x09, pstop: 0x09, tris: 0xFB},
+			records: [][]byte{
+				traceRecord(traceWrite,
+					cmdSetBitsLow, 0x09, 0xFB,
+					cmdSetBitsLow, 0x08, 0xFB,
+					mpsseDoWrite|mpsseWriteNeg, 0x00, 0x00, 0xA0,
+					cmdSetBitsLow, 0x08, 0xF9,
+					mpsseDoRead|mpsseBitmode, 0x00,
+					cmdSendImmediate),
+				traceRecord(traceRead, 0x00),
+				traceRecord(traceWrite,
+					cmdSetBitsLow, 0x0C, 0xFB,
+					cmdSetBitsLow, 0x09, 0xFB,
+					cmdSetBitsLow, 0x0F, 0xFB),
+			},
+			changes: []change{
+				// SDA falls while SCL is high, then SCL falls.
+				{0, 0x0F}, {1, 0x0D}, {2, 0x0C},
+				// Each bit takes three half periods with three phase
+				// clocking. Bits that leave the levels unchanged at the
+				// start of the bit do not add a change.
+				{3, 0x0E}, {4, 0x0F}, {5, 0x0E},
+				{6, 0x0C}, {7, 0x0D}, {8, 0x0C},
+				{9, 0x0E}, {10, 0x0F}, {11, 0x0E},
+				{12, 0x0C}, {13, 0x0D}, {14, 0x0C},
+				{16, 0x0D}, {17, 0x0C},
+				{19, 0x0D}, {20, 0x0C},
+				{22, 0x0D}, {23, 0x0C},
+				{25, 0x0D}, {26, 0x0C},
+				// SDA is released, then the ACK is read as low.
+				{27, 0x0E},
+				{28, 0x08}, {29, 0x09}, {30, 0x08},
+				// SCL rises, then SDA rises for the stop condition. DI
+				// keeps the level last read from it.
+				{32, 0x09}, {33, 0x0B},
+			},
+			end: 34,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			trace := &Trace{start: tt.start, clock: 500000}
+			for _, rec := range tt.records {
+				trace.records = append(trace.records, rec...)
+			}
+
+			changes, end, err := trace.render()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got := make([]change, len(changes))
+			for i, c := range changes {
+				got[i] = change{c.time / 1000000, c.pins}
+				if c.time%1000000 != 0 || c.clock != 500000 {
+					t.Errorf("change %d: got time %d ps, clock %d", i, c.time, c.clock)
+				}
+			}
+			if !reflect.DeepEqual(got, tt.changes) {
+				t.Errorf("got changes %x, want %x", got, tt.changes)
+			}
+			if end != tt.end*1000000 {
+				t.Errorf("got end %d ps, want %d us", end, tt.end)
+			}
+		})
+	}
+}
+
+func TestTraceRenderErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		records []byte
+	}{
+		{"short header", []byte{traceWrite, 0x01, 0x00}},
+		{"short data", []byte{traceWrite, 0x02, 0x00, 0x00, 0x00, cmdSetBitsLow}},
+		{"record type", traceRecord(0x03, 0x00)},
+		{"short command", traceRecord(traceWrite, cmdSetBitsLow, 0x00)},
+		{"unsupported command", traceRecord(traceWrite, 0x9F)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			trace := &Trace{start: mpsseState{mode: SPI0}, clock: 500000, records: tt.records}
+			if _, _, err := trace.render(); err == nil {
+				t.Error("got no error")
+			}
+		})
+	}
+}
+
+func TestSampleTrace(t *testing.T) {
+	// At a 500 kHz clock, samples are taken every microsecond, in the
+	// middle of each microsecond.
+	changes := []traceChange{
+		{time: 0, pins: 0x0108, clock: 500000},
+		{time: 1000000, pins: 0x0100, clock: 500000},
+		{time: 2600000, pins: 0x0001, clock: 500000},
+	}
+	samples, rate, err := sampleTrace(changes, 4000000, traceSigrokMaxSamples)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rate != 1000000 {
+		t.Errorf("got rate %d, want 1000000", rate)
+	}
+	want := []byte{0x08, 0x01, 0x00, 0x01, 0x00, 0x01, 0x01, 0x00}
+	if !reflect.DeepEqual(samples, want) {
+		t.Errorf("got samples % X, want % X", samples, want)
+	}
+
+	// One more sample than the limit is an error.
+	if _, _, err := sampleTrace(changes, 4000000, 3); err == nil {
+		t.Error("4 samples with a limit of 3: got no error")
+	}
+
+	// A second at 30 MHz needs 60M samples, which is refused without
+	// allocating them.
+	long := []traceChange{{time: 0, clock: 30000000}}
+	if _, _, err := sampleTrace(long, 1000000000000, traceSigrokMaxSamples); err == nil {
+		t.Error("60M samples: got no error")
+	}
+}
@@ -0,0 +1,91 @@
+package libmpsse
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+)
+
+// vcdWriter writes Value Change Dump files of up to 32 single bit signals.
+// Signal values are passed as a bitmask, with bit n holding signal n.
+type vcdWriter struct {
+	w       *bufio.Writer
+	signals int
+
+	started bool
+	time    int64
+	values  uint32
+}
+
+// newVCDWriter writes the VCD header declaring the named signals in the
+// given module. Times are in nanoseconds.
+func newVCDWriter(w io.Writer, module string, names []string) (*vcdWriter, error) {
+	if len(names) == 0 || len(names) > 32 {
+		return nil, fmt.Errorf("invalid VCD signal count %d", len(names))
+	}
+
+	v := &vcdWriter{
+		w:       bufio.NewWriter(w),
+		signals: len(names),
+	}
+
+	fmt.Fprintf(v.w, "$version libmpsse $end\n")
+	fmt.Fprintf(v.w, "$timescale 1 ns $end\n")
+	fmt.Fprintf(v.w, "$scope module %s $end\n", module)
+	for i, name := range names {
+		fmt.Fprintf(v.w, "$var wire 1 %s %s $end\n", vcdID(i), name)
+	}
+	fmt.Fprintf(v.w, "$upscope $end\n")
+	fmt.Fprintf(v.w, "$enddefinitions $end\n")
+
+	return v, nil
+}
+
+// set records the values of the signals at time t, which must not be
+// earlier than the previous time. Only the signals that changed are
+// written.
+func (v *vcdWriter) set(t int64, values uint32) {
+	values &= uint32(1)<<uint(v.signals) - 1
+
+	if !v.started {
+		fmt.Fprintf(v.w, "#%d\n$dumpvars\n", t)
+		for i := 0; i < v.signals; i++ {
+			fmt.Fprintf(v.w, "%d%s\n", values>>uint(i)&1, vcdID(i))
+		}
+		fmt.Fprintf(v.w, "$end\n")
+
+		v.started = true
+		v.time = t
+		v.values = values
+		return
+	}
+
+	changed := values ^ v.values
+	if changed == 0 {
+		return
+	}
+
+	if t != v.time {
+		fmt.Fprintf(v.w, "#%d\n", t)
+		v.time = t
+	}
+	for i := 0; i < v.signals; i++ {
+		if changed>>uint(i)&1 != 0 {
+			fmt.Fprintf(v.w, "%d%s\n", values>>uint(i)&1, vcdID(i))
+		}
+	}
+	v.values = values
+}
+
+// finish writes the final timestamp and flushes the output.
+func (v *vcdWriter) finish(t int64) error {
+	if v.started && t > v.time {
+		fmt.Fprintf(v.w, "#%d\n", t)
+	}
+	return v.w.Flush()
+}
+
+// vcdID returns the identifier code of signal n.
+func vcdID(n int) string {
+	return string(rune('!' + n))
+}
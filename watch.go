@@ -0,0 +1,256 @@
+package libmpsse
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Edge selects the pin transitions that WatchPin reports.
+type Edge int
+
+// Pin transitions.
+const (
+	RisingEdge Edge = 1 << iota
+	FallingEdge
+
+	BothEdges = RisingEdge | FallingEdge
+)
+
+// DefaultPollInterval is the interval that watched pins are polled at
+// unless SetPollInterval has been called.
+const DefaultPollInterval = 10 * time.Millisecond
+
+// Event is a transition of a watched pin.
+type Event struct {
+	Pin GPIOPin
+
+	// Edge is RisingEdge or FallingEdge.
+	Edge Edge
+
+	// Time is the time of the poll that first saw the pin at its new
+	// level. With debouncing, the event is delivered later than this.
+	Time time.Time
+}
+
+// watch is the state of a watched pin.
+type watch struct {
+	edge    Edge
+	handler func(Event)
+
+	// level is the debounced level of the pin, once a first poll has
+	// initialized it. changing is set while the pin has been seen at the
+	// other level since the given time, but not yet for long enough.
+	initialized bool
+	level       bool
+	changing    bool
+	since       time.Time
+}
+
+// pinWatcher polls the GPIO pins of an Mpsse for WatchPin.
+type pinWatcher struct {
+	lock     sync.Mutex
+	watches  map[GPIOPin]*watch
+	interval time.Duration
+	debounce time.Duration
+
+	running bool
+	done    chan struct{}
+	wg      sync.WaitGroup
+	err     error
+}
+
+// WatchPin calls handler for each transition of pin that matches edge. The
+// GPIO pins are polled in the background, all of them in one USB round
+// trip, every poll interval (see SetPollInterval); transitions shorter
+// than the poll interval may be missed. A pin must stay at its new level
+// for the debounce time (see SetDebounce) for the transition to be
+// reported. Watching a pin that is already watched replaces its handler.
+//
+// Handlers are called from the polling goroutine, one at a time, and must
+// not call Close. Polling stops when the Mpsse is closed, or if reading the
+// pins fails; WatchErr reports the failure.
+//
+// The Mpsse's methods lock it while they use the device, so it can still
+// be used from other goroutines while pins are watched; a poll waits for
+// any transaction or stream in progress to finish.
+//
+// Not for use in BITBANG mode.
+func (m *Mpsse) WatchPin(pin GPIOPin, edge Edge, handler func(Event)) error {
+	if pin < GPIOL0 || pin > GPIOH7 {
+		return fmt.Errorf("invalid GPIO pin %d", pin)
+	}
+	if edge&BothEdges == 0 {
+		return fmt.Errorf("invalid edge %d", edge)
+	}
+	if handler == nil {
+		return fmt.Errorf("nil handler for GPIO pin %d", pin)
+	}
+
+	m.watcher.watch(pin, edge, handler, m.ReadGPIO)
+	return nil
+}
+
+// watch adds a watch of pin, and starts polling the pins with read if the
+// poller is not running.
+func (w *pinWatcher) watch(pin GPIOPin, edge Edge, handler func(Event), read func() (uint16, error)) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	if w.watches == nil {
+		w.watches = make(map[GPIOPin]*watch)
+	}
+	w.watches[pin] = &watch{edge: edge, handler: handler}
+
+	if !w.running {
+		w.running = true
+		w.err = nil
+		w.done = make(chan struct{})
+		w.wg.Add(1)
+		go w.poll(read, w.done)
+	}
+}
+
+// UnwatchPin stops watching pin.
+func (m *Mpsse) UnwatchPin(pin GPIOPin) {
+	w := &m.watcher
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	delete(w.watches, pin)
+}
+
+// SetPollInterval sets the interval that watched pins are polled at.
+func (m *Mpsse) SetPollInterval(interval time.Duration) {
+	w := &m.watcher
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	w.interval = interval
+}
+
+// SetDebounce sets how long a watched pin must stay at a new level before
+// the transition is reported. The default of 0 reports every transition
+// that a poll sees.
+func (m *Mpsse) SetDebounce(debounce time.Duration) {
+	w := &m.watcher
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	w.debounce = debounce
+}
+
+// WatchErr returns the error that stopped pin polling, if any.
+func (m *Mpsse) WatchErr() error {
+	w := &m.watcher
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	return w.err
+}
+
+// stopWatching stops pin polling and waits for the poller to exit.
+func (m *Mpsse) stopWatching() {
+	m.watcher.stop()
+}
+
+// stop stops polling and waits for the poller to exit.
+func (w *pinWatcher) stop() {
+	w.lock.Lock()
+	if w.running {
+		close(w.done)
+		w.running = false
+	}
+	w.lock.Unlock()
+
+	w.wg.Wait()
+}
+
+// poll polls the watched pins with read until done is closed or a read
+// fails.
+func (w *pinWatcher) poll(read func() (uint16, error), done chan struct{}) {
+	defer w.wg.Done()
+
+	for {
+		w.lock.Lock()
+		interval := w.interval
+		w.lock.Unlock()
+		if interval <= 0 {
+			interval = DefaultPollInterval
+		}
+
+		select {
+		case <-done:
+			return
+		case <-time.After(interval):
+		}
+
+		pins, err := read()
+		now := time.Now()
+
+		w.lock.Lock()
+		if err != nil {
+			// Only report the error if the watcher has not been stopped
+			// (and possibly restarted) in the meantime.
+			if w.done == done {
+				w.err = err
+				w.running = false
+			}
+			w.lock.Unlock()
+			return
+		}
+		events, handlers := w.update(pins, now)
+		w.lock.Unlock()
+
+		for i, event := range events {
+			handlers[i](event)
+		}
+	}
+}
+
+// update updates the watched pins from the pin levels read at now, and
+// returns the events to deliver along with their handlers. The caller must
+// hold the lock.
+func (w *pinWatcher) update(pins uint16, now time.Time) ([]Event, []func(Event)) {
+	var events []Event
+	var handlers []func(Event)
+
+	for pin := GPIOL0; pin <= GPIOH7; pin++ {
+		wt, ok := w.watches[pin]
+		if !ok {
+			continue
+		}
+
+		level := pins&(1<<uint(pin)) != 0
+
+		if !wt.initialized {
+			wt.initialized = true
+			wt.level = level
+			continue
+		}
+		if level == wt.level {
+			wt.changing = false
+			continue
+		}
+		if !wt.changing {
+			wt.changing = true
+			wt.since = now
+		}
+		if now.Sub(wt.since) < w.debounce {
+			continue
+		}
+
+		wt.level = level
+		wt.changing = false
+
+		edge := FallingEdge
+		if level {
+			edge = RisingEdge
+		}
+		if wt.edge&edge != 0 {
+			events = append(events, Event{Pin: pin, Edge: edge, Time: wt.since})
+			handlers = append(handlers, wt.handler)
+		}
+	}
+	return events, handlers
+}
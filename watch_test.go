@@ -0,0 +1,121 @@
+package libmpsse
+
+import (
+	"errors"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestPinWatcherUpdate(t *testing.T) {
+	type sample struct {
+		ms   int
+		high bool
+		want []Event
+	}
+	start := time.Unix(1000, 0)
+	at := func(ms int) time.Time { return start.Add(time.Duration(ms) * time.Millisecond) }
+	rising := func(ms int) []Event { return []Event{{Pin: GPIOL1, Edge: RisingEdge, Time: at(ms)}} }
+	falling := func(ms int) []Event { return []Event{{Pin: GPIOL1, Edge: FallingEdge, Time: at(ms)}} }
+
+	tests := []struct {
+		name     string
+		edge     Edge
+		debounce time.Duration
+		samples  []sample
+	}{
+		{
+			"first sample only initializes",
+			BothEdges, 0,
+			[]sample{{0, true, nil}, {10, true, nil}, {20, false, falling(20)}},
+		},
+		{
+			"every transition without debounce",
+			BothEdges, 0,
+			[]sample{{0, false, nil}, {10, true, rising(10)}, {20, false, falling(20)}},
+		},
+		{
+			"glitch shorter than the debounce is dropped",
+			BothEdges, 20 * time.Millisecond,
+			[]sample{{0, false, nil}, {10, true, nil}, {20, false, nil}, {30, false, nil}},
+		},
+		{
+			"event time is the first poll at the new level",
+			BothEdges, 20 * time.Millisecond,
+			[]sample{{0, false, nil}, {10, true, nil}, {20, true, nil}, {30, true, rising(10)}, {40, true, nil}},
+		},
+		{
+			"edge filter",
+			RisingEdge, 0,
+			[]sample{{0, true, nil}, {10, false, nil}, {20, true, rising(20)}},
+		},
+	}
+
+	for _, tt := range tests {
+		w := &pinWatcher{
+			watches:  map[GPIOPin]*watch{GPIOL1: {edge: tt.edge, handler: func(Event) {}}},
+			debounce: tt.debounce,
+		}
+		for _, s := range tt.samples {
+			// Other pins change too, but are not watched.
+			pins := uint16(0xFFFD)
+			if s.high {
+				pins = 0x0002
+			}
+			events, handlers := w.update(pins, at(s.ms))
+			if !reflect.DeepEqual(events, s.want) {
+				t.Errorf("%s: at %d ms: got events %+v, want %+v", tt.name, s.ms, events, s.want)
+			}
+			if len(handlers) != len(events) {
+				t.Errorf("%s: at %d ms: got %d handlers for %d events", tt.name, s.ms, len(handlers), len(events))
+			}
+		}
+	}
+}
+
+func TestPinWatcherStop(t *testing.T) {
+	w := &pinWatcher{interval: time.Millisecond}
+	var reads int32
+	polled := make(chan struct{}, 1)
+	read := func() (uint16, error) {
+		atomic.AddInt32(&reads, 1)
+		select {
+		case polled <- struct{}{}:
+		default:
+		}
+		return 0, nil
+	}
+
+	w.watch(GPIOL0, BothEdges, func(Event) {}, read)
+	<-polled
+
+	// Once stop returns, the poller has exited and reads no more.
+	w.stop()
+	n := atomic.LoadInt32(&reads)
+	time.Sleep(20 * time.Millisecond)
+	if got := atomic.LoadInt32(&reads); got != n {
+		t.Errorf("got %d reads after stop", got-n)
+	}
+	if w.running {
+		t.Error("watcher still running after stop")
+	}
+}
+
+func TestPinWatcherReadError(t *testing.T) {
+	w := &pinWatcher{interval: time.Millisecond}
+	failed := errors.New("USB transfer failed")
+	done := make(chan struct{})
+	read := func() (uint16, error) {
+		close(done)
+		return 0, failed
+	}
+
+	// A failed read stops the poller and is reported.
+	w.watch(GPIOL0, BothEdges, func(Event) {}, read)
+	<-done
+	w.stop()
+	if w.err != failed || w.running {
+		t.Errorf("got error %v and running %v, want %v and not running", w.err, w.running, failed)
+	}
+}